@@ -10,16 +10,22 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/singh-gur/api_cache/internal/admin"
 	"github.com/singh-gur/api_cache/internal/cache"
 	"github.com/singh-gur/api_cache/internal/config"
 	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/metrics"
 	"github.com/singh-gur/api_cache/internal/middleware"
 	"github.com/singh-gur/api_cache/internal/proxy"
 )
 
+// version is the build version, set with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
 	// Parse command-line flags
 	configPath := flag.String("config", "config.yaml", "path to configuration file")
+	watchInterval := flag.Duration("config-watch-interval", 5*time.Second, "how often to check the configuration file for changes (0 disables; SIGHUP always reloads)")
 	flag.Parse()
 
 	// Load configuration
@@ -35,25 +41,30 @@ func main() {
 		os.Exit(1)
 	}
 
-	logger.Log.Info("Starting API Cache Proxy")
+	logger.Log.WithField("version", version).Info("Starting API Cache Proxy")
+
+	// Hold the configuration so it can be swapped on reload
+	configs := config.NewHolder(cfg)
 
 	// Initialize cache client
-	cacheClient, err := cache.NewClient(cfg)
+	cacheClient, err := cache.NewClient(configs)
 	if err != nil {
 		logger.Log.Fatalf("Failed to initialize cache client: %v", err)
 	}
 	defer cacheClient.Close()
 
 	// Create proxy handler
-	proxyHandler := proxy.NewHandler(cacheClient, cfg)
+	proxyHandler := proxy.NewHandler(cacheClient, configs)
 
 	// Create rate limiter
-	rateLimiter := middleware.NewRateLimiter(&cfg.RateLimit)
+	rateLimiter := middleware.NewRateLimiter(configs, cacheClient)
 
 	// Setup routes
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health", proxyHandler.Health())
-	mux.Handle("/", rateLimiter.Middleware(cfg)(proxyHandler))
+	mux.HandleFunc("/health", proxyHandler.Health(version))
+	mux.HandleFunc("/livez", proxyHandler.Livez(version))
+	mux.HandleFunc("/readyz", proxyHandler.Readyz(version))
+	mux.Handle("/", rateLimiter.Middleware()(proxyHandler))
 
 	// Wrap with request ID middleware
 	handler := middleware.RequestID(mux)
@@ -76,6 +87,52 @@ func main() {
 		}
 	}()
 
+	// Start admin API on its own listener
+	var adminServer *http.Server
+	if cfg.Admin.Enabled {
+		adminAddr := fmt.Sprintf("%s:%d", cfg.Admin.Host, cfg.Admin.Port)
+		adminServer = &http.Server{
+			Addr:         adminAddr,
+			Handler:      middleware.RequestID(admin.NewServer(cacheClient, configs, proxyHandler.Warmer()).Handler()),
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+		}
+
+		go func() {
+			logger.Log.Infof("Admin API listening on %s%s", adminAddr, cfg.Admin.BasePath())
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Log.Fatalf("Admin server failed: %v", err)
+			}
+		}()
+	}
+
+	// Serve metrics on their own listener, kept up while metrics are disabled
+	// so that a reload can turn them on
+	var metricsServer *http.Server
+	if cfg.Metrics.Port != 0 {
+		metricsAddr := fmt.Sprintf("%s:%d", cfg.Metrics.Host, cfg.Metrics.Port)
+		metricsServer = &http.Server{
+			Addr:         metricsAddr,
+			Handler:      metrics.Handler(configs),
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+			IdleTimeout:  cfg.Server.IdleTimeout,
+		}
+
+		go func() {
+			logger.Log.Infof("Metrics listening on %s%s", metricsAddr, cfg.Metrics.ScrapePath())
+			if err := metricsServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				logger.Log.Fatalf("Metrics server failed: %v", err)
+			}
+		}()
+	}
+
+	// Reload configuration on SIGHUP and file changes
+	watchCtx, stopWatching := context.WithCancel(context.Background())
+	defer stopWatching()
+	go watchConfig(watchCtx, *configPath, configs, *watchInterval)
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -83,6 +140,9 @@ func main() {
 
 	logger.Log.Info("Shutting down server...")
 
+	// Don't hold up shutdown waiting to retry upstream calls or for quotas to reset
+	proxyHandler.Stop()
+
 	// Graceful shutdown
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
@@ -91,5 +151,17 @@ func main() {
 		logger.Log.Errorf("Server forced to shutdown: %v", err)
 	}
 
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			logger.Log.Errorf("Admin server forced to shutdown: %v", err)
+		}
+	}
+
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			logger.Log.Errorf("Metrics server forced to shutdown: %v", err)
+		}
+	}
+
 	logger.Log.Info("Server stopped")
 }
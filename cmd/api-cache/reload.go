@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// restartRequired lists settings (or setting prefixes) that are read once at
+// startup, so changing them only takes effect after a restart
+var restartRequired = []string{
+	"server.",
+	"valkey.",
+	"metrics.host",
+	"metrics.port",
+	"admin.enabled",
+	"admin.host",
+	"admin.port",
+	"admin.path_prefix",
+	"cache.l1.enabled",
+	"cache.l1.max_entries",
+	"cache.l1.max_bytes",
+	"logging.level",
+	"logging.format",
+	"logging.output",
+	"logging.file_path",
+}
+
+// watchConfig reloads the configuration on SIGHUP and, when interval is
+// positive, whenever the file's modification time or size changes. It returns
+// when ctx is done.
+func watchConfig(ctx context.Context, path string, configs *config.Holder, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var poll <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	lastMod, lastSize := statConfig(path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			lastMod, lastSize = statConfig(path)
+			reloadConfig(path, configs, "sighup")
+		case <-poll:
+			mod, size := statConfig(path)
+			if mod.IsZero() || (mod.Equal(lastMod) && size == lastSize) {
+				continue
+			}
+			lastMod, lastSize = mod, size
+			reloadConfig(path, configs, "file_change")
+		}
+	}
+}
+
+// statConfig returns the modification time and size of the config file, or
+// zero values if it cannot be read (e.g. mid-replace by an editor)
+func statConfig(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, 0
+	}
+	return info.ModTime(), info.Size()
+}
+
+// reloadConfig swaps in the configuration at path, keeping the current one if
+// the new one is invalid, and logs what changed
+func reloadConfig(path string, configs *config.Holder, trigger string) {
+	previous, current, err := configs.Reload(path)
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"trigger": trigger,
+			"path":    path,
+			"error":   err,
+		}).Error("Configuration reload failed, keeping current configuration")
+		return
+	}
+
+	changes := config.Diff(previous, current)
+	var needsRestart []string
+	for _, change := range changes {
+		for _, prefix := range restartRequired {
+			if strings.HasPrefix(change, prefix) {
+				needsRestart = append(needsRestart, change)
+				break
+			}
+		}
+	}
+
+	fields := map[string]interface{}{
+		"trigger": trigger,
+		"path":    path,
+		"changes": changes,
+	}
+	if len(needsRestart) > 0 {
+		fields["restart_required"] = needsRestart
+		logger.WithFields(fields).Warn("Configuration reloaded; some changes take effect only after a restart")
+		return
+	}
+	logger.WithFields(fields).Info("Configuration reloaded")
+}
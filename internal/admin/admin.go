@@ -0,0 +1,427 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/middleware"
+	"github.com/singh-gur/api_cache/internal/warmer"
+)
+
+type contextKey string
+
+const callerKey contextKey = "admin_caller"
+
+// Server exposes cache inspection, invalidation, and warming over an
+// authenticated HTTP API
+type Server struct {
+	cache  *cache.Client
+	config *config.Holder
+	warmer *warmer.Warmer
+}
+
+// NewServer creates a new admin API server. Tokens and endpoint configs follow
+// configuration reloads; the listener and path prefix do not.
+func NewServer(cacheClient *cache.Client, configs *config.Holder, cacheWarmer *warmer.Warmer) *Server {
+	return &Server{
+		cache:  cacheClient,
+		config: configs,
+		warmer: cacheWarmer,
+	}
+}
+
+// Handler returns the admin API routes wrapped in token authentication
+func (s *Server) Handler() http.Handler {
+	base := s.config.Get().Admin.BasePath()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+base+"/entries", s.lookupRequest)
+	mux.HandleFunc("DELETE "+base+"/entries", s.purgeRequest)
+	mux.HandleFunc("GET "+base+"/entries/{key}", s.lookupKey)
+	mux.HandleFunc("DELETE "+base+"/entries/{key}", s.purgeKey)
+	mux.HandleFunc("GET "+base+"/keys", s.describeKey)
+	mux.HandleFunc("GET "+base+"/endpoints", s.listEndpoints)
+	mux.HandleFunc("DELETE "+base+"/endpoints", s.purgeEndpoint)
+	mux.HandleFunc("GET "+base+"/tags/{tag}", s.lookupTag)
+	mux.HandleFunc("DELETE "+base+"/tags", s.purgeTags)
+	mux.HandleFunc("DELETE "+base+"/cache", s.purgeAll)
+	mux.HandleFunc("GET "+base+"/warmer", s.warmerStatus)
+	mux.HandleFunc("POST "+base+"/warmer", s.startWarming)
+
+	return s.authenticate(mux)
+}
+
+// authenticate rejects requests without a configured bearer token and records the
+// token's name as the caller identity
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			for _, t := range s.config.Get().Admin.Tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+					ctx := context.WithValue(r.Context(), callerKey, t.Name)
+					next.ServeHTTP(w, r.WithContext(ctx))
+					return
+				}
+			}
+		}
+
+		logger.WithFields(map[string]interface{}{
+			"request_id": middleware.GetRequestID(r.Context()),
+			"method":     r.Method,
+			"path":       r.URL.Path,
+			"remote":     r.RemoteAddr,
+		}).Warn("Unauthorized admin request")
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+	})
+}
+
+// caller returns the authenticated caller identity for a request
+func caller(r *http.Request) string {
+	name, _ := r.Context().Value(callerKey).(string)
+	return name
+}
+
+// audit writes an admin action to the log with the caller identity
+func (s *Server) audit(r *http.Request, action string, fields map[string]interface{}) {
+	logFields := map[string]interface{}{
+		"request_id": middleware.GetRequestID(r.Context()),
+		"admin_user": caller(r),
+		"action":     action,
+		"remote":     r.RemoteAddr,
+	}
+	for k, v := range fields {
+		logFields[k] = v
+	}
+	logger.WithFields(logFields).Info("Admin action")
+}
+
+// targetRequest describes the proxied request an admin call refers to, resolved
+// to its endpoint config and cache key the same way the proxy resolves them
+type targetRequest struct {
+	req   *http.Request
+	match config.EndpointMatch
+	key   string
+}
+
+// parseTargetRequest builds the target request from the method, path, query, and
+// repeated header ("Name: value") query parameters of an admin call
+func (s *Server) parseTargetRequest(r *http.Request) (*targetRequest, string) {
+	params := r.URL.Query()
+	path := params.Get("path")
+	if path == "" {
+		return nil, "path is required"
+	}
+	method := strings.ToUpper(params.Get("method"))
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	req := &http.Request{
+		Method: method,
+		URL:    &url.URL{Path: path, RawQuery: params.Get("query")},
+		Header: make(http.Header),
+	}
+	for _, h := range params["header"] {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return nil, "headers must be formatted as Name: value"
+		}
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	cfg := s.config.Get()
+	match := cfg.GetEndpointCacheConfigMatch(req.URL.Path, req.Method, req.URL.Query())
+	upstream := cfg.ResolveUpstream(req.URL.Path).Upstream.Name
+	return &targetRequest{
+		req:   req,
+		match: match,
+		key:   s.cache.GenerateCacheKey(req, upstream, match.Config),
+	}, ""
+}
+
+// entryInfo is the metadata returned for a cache entry lookup
+type entryInfo struct {
+	CacheKey            string              `json:"cache_key"`
+	Found               bool                `json:"found"`
+	EndpointID          string              `json:"endpoint_id,omitempty"`
+	MatchType           string              `json:"match_type,omitempty"`
+	StatusCode          int                 `json:"status_code,omitempty"`
+	CachedAt            *time.Time          `json:"cached_at,omitempty"`
+	AgeSeconds          float64             `json:"age_seconds,omitempty"`
+	RemainingTTLSeconds float64             `json:"remaining_ttl_seconds,omitempty"`
+	Fresh               bool                `json:"fresh,omitempty"`
+	BodySize            int                 `json:"body_size,omitempty"`
+	Encoding            string              `json:"encoding,omitempty"`
+	Headers             map[string][]string `json:"headers,omitempty"`
+}
+
+// describe loads a cache entry and its remaining TTL from Valkey, bypassing L1
+func (s *Server) describe(ctx context.Context, key string) (*entryInfo, error) {
+	info := &entryInfo{CacheKey: key}
+
+	cached, err := s.cache.Peek(ctx, key)
+	if err != nil || cached == nil {
+		return info, err
+	}
+
+	info.Found = true
+	info.EndpointID = cached.EndpointID
+	info.StatusCode = cached.StatusCode
+	info.CachedAt = &cached.CachedAt
+	info.AgeSeconds = time.Since(cached.CachedAt).Seconds()
+	info.Fresh = cached.IsFresh(time.Now())
+	info.BodySize = len(cached.Body)
+	info.Encoding = cached.Encoding
+	info.Headers = cached.Headers
+
+	if ttl, err := s.cache.RemainingTTL(ctx, key); err == nil && ttl > 0 {
+		info.RemainingTTLSeconds = ttl.Seconds()
+	}
+	return info, nil
+}
+
+// lookupRequest returns metadata for the entry a request would be served from
+func (s *Server) lookupRequest(w http.ResponseWriter, r *http.Request) {
+	target, msg := s.parseTargetRequest(r)
+	if target == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	key := target.key
+	if s.config.Get().Cache.RespectUpstreamCacheHeaders {
+		if vary, err := s.cache.GetVary(r.Context(), key); err == nil {
+			key = cache.VariantKey(key, target.req.Header, vary)
+		}
+	}
+
+	info, err := s.describe(r.Context(), key)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+	info.MatchType = string(target.match.MatchType)
+	if target.match.Config != nil {
+		info.EndpointID = target.match.Config.EndpointIdentifier()
+	}
+
+	s.audit(r, "lookup_request", map[string]interface{}{
+		"cache_key": key,
+		"path":      target.req.URL.Path,
+		"query":     s.config.Get().SanitizeQuery(target.req.URL.RawQuery),
+		"found":     info.Found,
+	})
+	writeJSON(w, http.StatusOK, info)
+}
+
+// lookupKey returns metadata for an entry by its cache key
+func (s *Server) lookupKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if !cache.IsEntryKey(key) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "not a cache entry key"})
+		return
+	}
+
+	info, err := s.describe(r.Context(), key)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+
+	s.audit(r, "lookup_key", map[string]interface{}{
+		"cache_key": key,
+		"found":     info.Found,
+	})
+	writeJSON(w, http.StatusOK, info)
+}
+
+// describeKey shows how the cache key for a request is derived
+func (s *Server) describeKey(w http.ResponseWriter, r *http.Request) {
+	target, msg := s.parseTargetRequest(r)
+	if target == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	cfg := s.config.Get()
+	upstream := cfg.ResolveUpstream(target.req.URL.Path).Upstream.Name
+	desc := s.cache.DescribeCacheKey(target.req, upstream, target.match)
+	if cfg.Cache.RespectUpstreamCacheHeaders {
+		if vary, err := s.cache.GetVary(r.Context(), desc.CacheKey); err == nil {
+			if key := cache.VariantKey(desc.CacheKey, target.req.Header, vary); key != desc.CacheKey {
+				desc.VariantKey = key
+			}
+		}
+	}
+
+	s.audit(r, "describe_key", map[string]interface{}{
+		"cache_key": desc.CacheKey,
+		"path":      target.req.URL.Path,
+		"query":     cfg.SanitizeQuery(target.req.URL.RawQuery),
+	})
+	writeJSON(w, http.StatusOK, desc)
+}
+
+// purgeRequest removes the entry (and any Vary variants) for a request
+func (s *Server) purgeRequest(w http.ResponseWriter, r *http.Request) {
+	target, msg := s.parseTargetRequest(r)
+	if target == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": msg})
+		return
+	}
+
+	deleted, err := s.cache.DeleteRequest(r.Context(), target.key)
+	s.writePurgeResult(w, r, "purge_request", deleted, err, map[string]interface{}{
+		"cache_key": target.key,
+		"path":      target.req.URL.Path,
+		"query":     s.config.Get().SanitizeQuery(target.req.URL.RawQuery),
+	})
+}
+
+// purgeKey removes an entry by its cache key
+func (s *Server) purgeKey(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if !cache.IsEntryKey(key) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "not a cache entry key"})
+		return
+	}
+
+	deleted, err := s.cache.DeleteRequest(r.Context(), key)
+	s.writePurgeResult(w, r, "purge_key", deleted, err, map[string]interface{}{
+		"cache_key": key,
+	})
+}
+
+// listEndpoints returns the identifiers of the configured cache endpoints
+func (s *Server) listEndpoints(w http.ResponseWriter, r *http.Request) {
+	endpoints := s.config.Get().Cache.Endpoints
+	ids := make([]string, 0, len(endpoints))
+	for i := range endpoints {
+		ids = append(ids, endpoints[i].EndpointIdentifier())
+	}
+
+	s.audit(r, "list_endpoints", map[string]interface{}{
+		"endpoints": len(ids),
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"endpoints": ids})
+}
+
+// purgeEndpoint removes every entry cached under an endpoint config
+func (s *Server) purgeEndpoint(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	known := false
+	endpoints := s.config.Get().Cache.Endpoints
+	for i := range endpoints {
+		if endpoints[i].EndpointIdentifier() == id {
+			known = true
+			break
+		}
+	}
+	if !known {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown endpoint id"})
+		return
+	}
+
+	deleted, err := s.cache.DeleteEntries(r.Context(), func(_ string, cached *cache.CachedResponse) bool {
+		return cached.EndpointID == id
+	})
+	s.writePurgeResult(w, r, "purge_endpoint", deleted, err, map[string]interface{}{
+		"endpoint_id": id,
+	})
+}
+
+// lookupTag lists the keys of the entries carrying a tag
+func (s *Server) lookupTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.PathValue("tag")
+	keys, err := s.cache.TaggedKeys(r.Context(), tag)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+
+	s.audit(r, "lookup_tag", map[string]interface{}{
+		"tag":     tag,
+		"entries": len(keys),
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tag": tag, "keys": keys})
+}
+
+// purgeTags removes every entry carrying any of the given tags
+func (s *Server) purgeTags(w http.ResponseWriter, r *http.Request) {
+	tags := r.URL.Query()["tag"]
+	if len(tags) == 0 || slices.Contains(tags, "") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "tag is required"})
+		return
+	}
+
+	deleted, err := s.cache.DeleteTags(r.Context(), tags)
+	s.writePurgeResult(w, r, "purge_tags", deleted, err, map[string]interface{}{
+		"tags": tags,
+	})
+}
+
+// purgeAll removes every cache entry and the tag and path indexes
+func (s *Server) purgeAll(w http.ResponseWriter, r *http.Request) {
+	deleted, err := s.cache.DeleteAll(r.Context())
+	s.writePurgeResult(w, r, "purge_all", deleted, err, nil)
+}
+
+// warmerStatus reports the progress of the current or last warming run
+func (s *Server) warmerStatus(w http.ResponseWriter, r *http.Request) {
+	status := s.warmer.Status()
+	s.audit(r, "warmer_status", map[string]interface{}{
+		"running": status.Running,
+	})
+	writeJSON(w, http.StatusOK, status)
+}
+
+// startWarming starts a warming run
+func (s *Server) startWarming(w http.ResponseWriter, r *http.Request) {
+	err := s.warmer.Trigger()
+	fields := map[string]interface{}{}
+	if err != nil {
+		fields["error"] = err
+	}
+	s.audit(r, "start_warming", fields)
+
+	if err != nil {
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "started"})
+}
+
+// writePurgeResult audits a purge and reports how many keys it removed
+func (s *Server) writePurgeResult(w http.ResponseWriter, r *http.Request, action string, deleted int, err error, fields map[string]interface{}) {
+	if fields == nil {
+		fields = make(map[string]interface{})
+	}
+	fields["deleted"] = deleted
+	if err != nil {
+		fields["error"] = err
+	}
+	s.audit(r, action, fields)
+
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, map[string]interface{}{"error": err.Error(), "deleted": deleted})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deleted": deleted})
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,276 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/warmer"
+)
+
+const testToken = "s3cret"
+
+// initLogger initializes logging once for every test in the package
+var initLogger sync.Once
+
+// newTestServer returns an admin server over an in-memory Valkey with L1
+// enabled, along with the Valkey instance for inspection
+func newTestServer(t *testing.T) (*Server, *miniredis.Miniredis) {
+	t.Helper()
+	initLogger.Do(func() { logger.Init(config.LoggingConfig{Level: "error"}) })
+
+	mr := miniredis.RunT(t)
+	host, port, _ := net.SplitHostPort(mr.Addr())
+	cfg := &config.Config{}
+	cfg.Valkey.Host = host
+	cfg.Valkey.Port, _ = strconv.Atoi(port)
+	cfg.Cache.L1 = config.L1CacheConfig{Enabled: true, MaxEntries: 100, TTL: time.Minute}
+	cfg.Cache.Endpoints = []config.EndpointCacheConfig{{Path: "/users", Methods: []string{http.MethodGet}, TTL: time.Minute}}
+	cfg.Admin.Tokens = []config.AdminTokenConfig{{Name: "ops", Token: testToken}}
+
+	configs := config.NewHolder(cfg)
+	cacheClient, err := cache.NewClient(configs)
+	if err != nil {
+		t.Fatalf("cache.NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { cacheClient.Close() })
+	return NewServer(cacheClient, configs, warmer.New(configs, nil, nil)), mr
+}
+
+// call sends an authenticated admin request and decodes the JSON response
+func call(t *testing.T, s *Server, method, target string) (int, map[string]interface{}) {
+	t.Helper()
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("%s %s: invalid JSON response %q", method, target, w.Body.String())
+	}
+	return w.Code, body
+}
+
+// store caches a response for path and returns its cache key
+func store(t *testing.T, s *Server, path string) string {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	key := s.cache.GenerateCacheKey(req, config.DefaultUpstreamName, nil)
+	now := time.Now()
+	err := s.cache.Set(context.Background(), key, &cache.CachedResponse{
+		StatusCode: http.StatusOK,
+		Body:       []byte("cached"),
+		CachedAt:   now,
+		FreshUntil: now.Add(time.Minute),
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	return key
+}
+
+func TestAuthenticate(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{name: "missing token", want: http.StatusUnauthorized},
+		{name: "wrong token", authorization: "Bearer wrong", want: http.StatusUnauthorized},
+		{name: "wrong scheme", authorization: "Basic " + testToken, want: http.StatusUnauthorized},
+		{name: "correct token", authorization: "Bearer " + testToken, want: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/endpoints", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateRecordsCaller(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	var got string
+	handler := s.authenticate(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		got = caller(r)
+	}))
+	r := httptest.NewRequest(http.MethodGet, "/admin/endpoints", nil)
+	r.Header.Set("Authorization", "Bearer "+testToken)
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if got != "ops" {
+		t.Errorf("caller = %q, want the token's name", got)
+	}
+}
+
+func TestParseTargetRequest(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	tests := []struct {
+		name       string
+		params     url.Values
+		wantErr    string
+		wantMethod string
+		wantHeader http.Header
+		wantMatch  config.MatchType
+	}{
+		{
+			name:    "path required",
+			params:  url.Values{"method": {"GET"}},
+			wantErr: "path is required",
+		},
+		{
+			name:       "method defaults to GET",
+			params:     url.Values{"path": {"/users"}},
+			wantMethod: http.MethodGet,
+			wantMatch:  config.MatchTypeFallbackExact,
+		},
+		{
+			name:       "method is upper-cased",
+			params:     url.Values{"path": {"/other"}, "method": {"post"}},
+			wantMethod: http.MethodPost,
+			wantMatch:  config.MatchTypeDefault,
+		},
+		{
+			name:       "headers",
+			params:     url.Values{"path": {"/users"}, "header": {"Accept: application/json", " X-Tenant :acme"}},
+			wantMethod: http.MethodGet,
+			wantHeader: http.Header{"Accept": {"application/json"}, "X-Tenant": {"acme"}},
+			wantMatch:  config.MatchTypeFallbackExact,
+		},
+		{
+			name:    "malformed header",
+			params:  url.Values{"path": {"/users"}, "header": {"Accept"}},
+			wantErr: "headers must be formatted as Name: value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/admin/entries?"+tt.params.Encode(), nil)
+			target, msg := s.parseTargetRequest(r)
+			if tt.wantErr != "" {
+				if target != nil || msg != tt.wantErr {
+					t.Errorf("parseTargetRequest() = %v, %q, want error %q", target, msg, tt.wantErr)
+				}
+				return
+			}
+			if target == nil {
+				t.Fatalf("parseTargetRequest() error = %q", msg)
+			}
+			if target.req.Method != tt.wantMethod {
+				t.Errorf("method = %q, want %q", target.req.Method, tt.wantMethod)
+			}
+			for name, values := range tt.wantHeader {
+				if got := target.req.Header.Values(name); len(got) != 1 || got[0] != values[0] {
+					t.Errorf("header %s = %q, want %q", name, got, values)
+				}
+			}
+			if target.match.MatchType != tt.wantMatch {
+				t.Errorf("match type = %q, want %q", target.match.MatchType, tt.wantMatch)
+			}
+
+			// The key must be the one the proxy would use for the same request
+			proxied := httptest.NewRequest(tt.wantMethod, tt.params.Get("path"), nil)
+			if want := s.cache.GenerateCacheKey(proxied, config.DefaultUpstreamName, target.match.Config); target.key != want {
+				t.Errorf("key = %q, want %q", target.key, want)
+			}
+		})
+	}
+}
+
+func TestPurgeKey(t *testing.T) {
+	s, mr := newTestServer(t)
+	key := store(t, s, "/users")
+	other := store(t, s, "/orders")
+
+	code, body := call(t, s, http.MethodDelete, "/admin/entries/"+key)
+	if code != http.StatusOK || body["deleted"] != 1.0 {
+		t.Errorf("purge = %d %v, want 1 deleted", code, body)
+	}
+	if mr.Exists(key) || !mr.Exists(other) {
+		t.Error("purge should remove only the requested key")
+	}
+
+	if code, _ := call(t, s, http.MethodDelete, "/admin/entries/cache:tag:users"); code != http.StatusBadRequest {
+		t.Errorf("purging a non-entry key = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestPurgeRequest(t *testing.T) {
+	s, mr := newTestServer(t)
+	key := store(t, s, "/orders")
+
+	code, body := call(t, s, http.MethodDelete, "/admin/entries?path=/orders")
+	if code != http.StatusOK || body["deleted"] != 1.0 {
+		t.Errorf("purge = %d %v, want 1 deleted", code, body)
+	}
+	if mr.Exists(key) {
+		t.Error("purge should remove the request's entry")
+	}
+
+	if code, _ := call(t, s, http.MethodDelete, "/admin/entries"); code != http.StatusBadRequest {
+		t.Errorf("purge without path = %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestPurgeAllRemovesIndexes(t *testing.T) {
+	s, mr := newTestServer(t)
+	ctx := context.Background()
+	key := store(t, s, "/orders")
+	err := s.cache.IndexEntry(ctx, key, cache.EntryIndex{
+		Tags: []string{"orders"},
+		Path: &cache.RequestPath{Upstream: config.DefaultUpstreamName, Path: "/orders"},
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("IndexEntry() error = %v", err)
+	}
+
+	if code, _ := call(t, s, http.MethodDelete, "/admin/cache"); code != http.StatusOK {
+		t.Fatalf("purge all = %d, want %d", code, http.StatusOK)
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("keys left after purging everything: %v", keys)
+	}
+}
+
+func TestLookupDoesNotFillL1(t *testing.T) {
+	s, mr := newTestServer(t)
+	key := store(t, s, "/users")
+
+	code, body := call(t, s, http.MethodGet, "/admin/entries/"+key)
+	if code != http.StatusOK || body["found"] != true {
+		t.Fatalf("lookup = %d %v, want the entry", code, body)
+	}
+
+	// Had the lookup filled L1, this replica would keep serving the entry
+	mr.Del(key)
+	cached, err := s.cache.Get(context.Background(), key)
+	if err != nil || cached != nil {
+		t.Errorf("Get() after delete = %v, %v, want a miss", cached, err)
+	}
+}
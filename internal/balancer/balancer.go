@@ -0,0 +1,332 @@
+package balancer
+
+import (
+	"context"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/breaker"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// checkInterval is how often the health check loop looks for due probes
+const checkInterval = time.Second
+
+// target is the state of one upstream replica
+type target struct {
+	url      string
+	inFlight int
+	// failures counts consecutive failed calls for passive ejection
+	failures     int
+	ejectedUntil time.Time
+	// unhealthy is set by active health probes
+	unhealthy      bool
+	probeFailures  int
+	probeSuccesses int
+	lastProbe      time.Time
+	probing        bool
+}
+
+// available reports whether the target should get traffic
+func (t *target) available(now time.Time) bool {
+	return !t.unhealthy && !now.Before(t.ejectedUntil)
+}
+
+// upstream is the balancing state of one upstream
+type upstream struct {
+	next    int
+	targets map[string]*target
+}
+
+// TargetStatus describes a target for the health endpoint
+type TargetStatus struct {
+	Healthy  bool `json:"healthy"`
+	Ejected  bool `json:"ejected,omitempty"`
+	InFlight int  `json:"in_flight"`
+}
+
+// Set balances calls across the targets of every upstream, following
+// configuration reloads
+type Set struct {
+	config    *config.Holder
+	now       func() time.Time
+	clients   func(config.NamedUpstreamConfig) *http.Client
+	probe     func(ctx context.Context, client *http.Client, url string) bool
+	mu        sync.Mutex
+	upstreams map[string]*upstream
+}
+
+// NewSet creates a balancer with no state; targets are tracked once used or
+// probed. Health probes are sent with the client clients returns for the
+// upstream, so they share its transport and connection limits.
+func NewSet(configs *config.Holder, clients func(config.NamedUpstreamConfig) *http.Client) *Set {
+	return &Set{
+		config:    configs,
+		now:       time.Now,
+		clients:   clients,
+		probe:     probeTarget,
+		upstreams: make(map[string]*upstream),
+	}
+}
+
+// state returns the balancing state of up, creating entries for new targets.
+// The caller must hold the lock.
+func (s *Set) state(up config.NamedUpstreamConfig) (*upstream, []*target) {
+	u, ok := s.upstreams[up.Name]
+	if !ok {
+		u = &upstream{targets: make(map[string]*target)}
+		s.upstreams[up.Name] = u
+	}
+	urls := up.TargetURLs()
+	targets := make([]*target, 0, len(urls))
+	for _, url := range urls {
+		t, ok := u.targets[url]
+		if !ok {
+			t = &target{url: url}
+			u.targets[url] = t
+		}
+		targets = append(targets, t)
+	}
+	return u, targets
+}
+
+// Pick chooses the target for one call to up and returns its base URL.
+// Targets in tried, used by earlier attempts of the same request, are avoided
+// while another is available. If every target is unhealthy or ejected, calls
+// are spread across all of them rather than failing outright. hashKey is used
+// by consistent_hash balancing. The returned function must be called with the
+// call's outcome.
+func (s *Set) Pick(up config.NamedUpstreamConfig, hashKey string, tried []string) (string, func(breaker.Outcome)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	u, targets := s.state(up)
+
+	candidates := filterTargets(targets, func(t *target) bool {
+		return t.available(now) && !slices.Contains(tried, t.url)
+	})
+	if len(candidates) == 0 {
+		candidates = filterTargets(targets, func(t *target) bool { return t.available(now) })
+	}
+	if len(candidates) == 0 {
+		candidates = targets
+	}
+
+	var picked *target
+	switch up.Balance {
+	case config.BalanceLeastInFlight:
+		start := u.next % len(candidates)
+		u.next++
+		for i := range candidates {
+			t := candidates[(start+i)%len(candidates)]
+			if picked == nil || t.inFlight < picked.inFlight {
+				picked = t
+			}
+		}
+	case config.BalanceConsistentHash:
+		// Rendezvous hashing: the target with the highest score for the key
+		// wins, so removing a target only moves the keys it held
+		var best uint64
+		for _, t := range candidates {
+			if score := hashScore(hashKey, t.url); picked == nil || score > best {
+				picked, best = t, score
+			}
+		}
+	default:
+		picked = candidates[u.next%len(candidates)]
+		u.next++
+	}
+
+	picked.inFlight++
+	return picked.url, func(outcome breaker.Outcome) {
+		s.record(up, picked, outcome)
+	}
+}
+
+// filterTargets returns the targets for which keep is true
+func filterTargets(targets []*target, keep func(*target) bool) []*target {
+	var kept []*target
+	for _, t := range targets {
+		if keep(t) {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+// hashScore scores a target for a key in rendezvous hashing
+func hashScore(key, url string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	h.Write([]byte{0})
+	h.Write([]byte(url))
+	return h.Sum64()
+}
+
+// record applies the outcome of a call, ejecting the target after too many
+// consecutive failures
+func (s *Set) record(up config.NamedUpstreamConfig, t *target, outcome breaker.Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.inFlight--
+	switch outcome {
+	case breaker.Success:
+		t.failures = 0
+	case breaker.Failure:
+		t.failures++
+		ejection := up.Ejection.WithDefaults()
+		if up.Ejection.Enabled && t.failures >= ejection.ConsecutiveFailures {
+			t.failures = 0
+			t.ejectedUntil = s.now().Add(ejection.Duration)
+			logger.WithFields(map[string]interface{}{
+				"upstream":             up.Name,
+				"target":               t.url,
+				"consecutive_failures": ejection.ConsecutiveFailures,
+				"ejected_for":          ejection.Duration.Seconds(),
+			}).Warn("Upstream target ejected after consecutive failures")
+		}
+	}
+}
+
+// Run probes the targets of upstreams with health checks enabled until stop
+// is closed
+func (s *Set) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.probeDue(ctx)
+		}
+	}
+}
+
+// probeDue starts a probe of every target whose interval has elapsed, and
+// drops state for upstreams and targets no longer configured
+func (s *Set) probeDue(ctx context.Context) {
+	cfg := s.config.Get()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	configured := make(map[string]bool)
+	for _, up := range cfg.AllUpstreams() {
+		configured[up.Name] = true
+		u, targets := s.state(up)
+
+		urls := make(map[string]bool, len(targets))
+		for _, t := range targets {
+			urls[t.url] = true
+		}
+		for url, t := range u.targets {
+			if !urls[url] && t.inFlight == 0 {
+				delete(u.targets, url)
+			}
+		}
+
+		if !up.HealthCheck.Enabled {
+			// Forget probe results so re-enabling starts from healthy
+			for _, t := range targets {
+				t.unhealthy, t.probeFailures, t.probeSuccesses = false, 0, 0
+			}
+			continue
+		}
+		hc := up.HealthCheck.WithDefaults()
+		for _, t := range targets {
+			if t.probing || now.Sub(t.lastProbe) < hc.Interval {
+				continue
+			}
+			t.probing = true
+			t.lastProbe = now
+			go s.runProbe(ctx, up, t, strings.TrimSuffix(t.url, "/")+"/"+strings.TrimPrefix(hc.Path, "/"), hc)
+		}
+	}
+	for name := range s.upstreams {
+		if !configured[name] {
+			delete(s.upstreams, name)
+		}
+	}
+}
+
+// runProbe probes one target and updates its health
+func (s *Set) runProbe(ctx context.Context, up config.NamedUpstreamConfig, t *target, url string, hc config.HealthCheckConfig) {
+	probeCtx, cancel := context.WithTimeout(ctx, hc.Timeout)
+	ok := s.probe(probeCtx, s.clients(up), url)
+	cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t.probing = false
+	if ok {
+		t.probeFailures = 0
+		t.probeSuccesses++
+	} else {
+		t.probeSuccesses = 0
+		t.probeFailures++
+	}
+
+	fields := map[string]interface{}{
+		"upstream": up.Name,
+		"target":   t.url,
+		"probe":    url,
+	}
+	switch {
+	case t.unhealthy && t.probeSuccesses >= hc.HealthyThreshold:
+		t.unhealthy = false
+		logger.WithFields(fields).Info("Upstream target healthy again")
+	case !t.unhealthy && t.probeFailures >= hc.UnhealthyThreshold:
+		t.unhealthy = true
+		logger.WithFields(fields).Warn("Upstream target failed health checks, taking it out of rotation")
+	}
+}
+
+// probeTarget requests a health check URL and reports whether it answered 2xx
+func probeTarget(ctx context.Context, client *http.Client, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 300
+}
+
+// Statuses returns the state of every tracked target, keyed by upstream name
+// and target URL
+func (s *Set) Statuses() map[string]map[string]TargetStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	statuses := make(map[string]map[string]TargetStatus, len(s.upstreams))
+	for name, u := range s.upstreams {
+		targets := make(map[string]TargetStatus, len(u.targets))
+		for url, t := range u.targets {
+			targets[url] = TargetStatus{
+				Healthy:  !t.unhealthy,
+				Ejected:  now.Before(t.ejectedUntil),
+				InFlight: t.inFlight,
+			}
+		}
+		statuses[name] = targets
+	}
+	return statuses
+}
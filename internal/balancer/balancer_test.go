@@ -0,0 +1,266 @@
+package balancer
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/breaker"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+var testTargets = []string{"http://a:9000", "http://b:9000", "http://c:9000"}
+
+// testClient is the client newTestSet's balancer probes every upstream with
+var testClient = &http.Client{}
+
+// newTestSet returns a balancer with a controllable clock
+func newTestSet(t *testing.T, cfg *config.Config) (*Set, *time.Time) {
+	t.Helper()
+	logger.Init(config.LoggingConfig{Level: "error"})
+
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	set := NewSet(config.NewHolder(cfg), func(config.NamedUpstreamConfig) *http.Client { return testClient })
+	set.now = func() time.Time { return now }
+	return set, &now
+}
+
+// pick picks a target and immediately records outcome
+func pick(set *Set, up config.NamedUpstreamConfig, outcome breaker.Outcome, tried ...string) string {
+	target, done := set.Pick(up, "", tried)
+	done(outcome)
+	return target
+}
+
+func TestRoundRobin(t *testing.T) {
+	up := config.NamedUpstreamConfig{Name: "api", UpstreamConfig: config.UpstreamConfig{Targets: testTargets}}
+	set, _ := newTestSet(t, &config.Config{})
+
+	for i := 0; i < 6; i++ {
+		if got, want := pick(set, up, breaker.Success), testTargets[i%3]; got != want {
+			t.Errorf("pick %d = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestSingleBaseURL(t *testing.T) {
+	up := config.NamedUpstreamConfig{Name: "api", UpstreamConfig: config.UpstreamConfig{BaseURL: "http://only:9000"}}
+	set, _ := newTestSet(t, &config.Config{})
+
+	if got := pick(set, up, breaker.Success); got != "http://only:9000" {
+		t.Errorf("pick = %s, want base_url", got)
+	}
+	// A retry reuses the only target rather than failing
+	if got := pick(set, up, breaker.Success, "http://only:9000"); got != "http://only:9000" {
+		t.Errorf("retry pick = %s, want base_url", got)
+	}
+}
+
+func TestRetryPrefersUntriedTarget(t *testing.T) {
+	for _, balance := range []string{config.BalanceRoundRobin, config.BalanceLeastInFlight, config.BalanceConsistentHash} {
+		t.Run(balance, func(t *testing.T) {
+			up := config.NamedUpstreamConfig{Name: "api", UpstreamConfig: config.UpstreamConfig{Targets: testTargets, Balance: balance}}
+			set, _ := newTestSet(t, &config.Config{})
+
+			first, done := set.Pick(up, "/items/1", nil)
+			done(breaker.Failure)
+			second, done := set.Pick(up, "/items/1", []string{first})
+			done(breaker.Success)
+			if second == first {
+				t.Errorf("retry picked %s again", first)
+			}
+		})
+	}
+}
+
+func TestLeastInFlight(t *testing.T) {
+	up := config.NamedUpstreamConfig{Name: "api", UpstreamConfig: config.UpstreamConfig{Targets: testTargets, Balance: config.BalanceLeastInFlight}}
+	set, _ := newTestSet(t, &config.Config{})
+
+	// Hold two calls open so the third target is the least loaded
+	first, doneFirst := set.Pick(up, "", nil)
+	second, doneSecond := set.Pick(up, "", nil)
+	third, doneThird := set.Pick(up, "", nil)
+	if first == second || second == third || first == third {
+		t.Fatalf("picks %s, %s, %s should spread across targets", first, second, third)
+	}
+	doneThird(breaker.Success)
+
+	if got := pick(set, up, breaker.Success); got != third {
+		t.Errorf("pick = %s, want idle target %s", got, third)
+	}
+	doneFirst(breaker.Success)
+	doneSecond(breaker.Success)
+}
+
+func TestConsistentHash(t *testing.T) {
+	up := config.NamedUpstreamConfig{Name: "api", UpstreamConfig: config.UpstreamConfig{Targets: testTargets, Balance: config.BalanceConsistentHash}}
+	set, _ := newTestSet(t, &config.Config{})
+
+	picks := make(map[string]string)
+	for _, key := range []string{"/a", "/b", "/c", "/d", "/e", "/f"} {
+		target, done := set.Pick(up, key, nil)
+		done(breaker.Success)
+		picks[key] = target
+
+		again, done := set.Pick(up, key, nil)
+		done(breaker.Success)
+		if again != target {
+			t.Errorf("key %s moved from %s to %s", key, target, again)
+		}
+	}
+
+	// Removing a target only moves the keys it held
+	removed := testTargets[0]
+	up.Targets = testTargets[1:]
+	for key, before := range picks {
+		after, done := set.Pick(up, key, nil)
+		done(breaker.Success)
+		if before != removed && after != before {
+			t.Errorf("key %s moved from %s to %s after removing %s", key, before, after, removed)
+		}
+	}
+}
+
+func TestPassiveEjection(t *testing.T) {
+	up := config.NamedUpstreamConfig{
+		Name: "api",
+		UpstreamConfig: config.UpstreamConfig{
+			Targets:  testTargets[:2],
+			Ejection: config.EjectionConfig{Enabled: true, ConsecutiveFailures: 2, Duration: 10 * time.Second},
+		},
+	}
+	set, now := newTestSet(t, &config.Config{})
+
+	// Target a fails twice in a row (b succeeds in between)
+	pick(set, up, breaker.Failure)
+	pick(set, up, breaker.Success)
+	pick(set, up, breaker.Failure)
+
+	for i := 0; i < 3; i++ {
+		if got := pick(set, up, breaker.Success); got != testTargets[1] {
+			t.Fatalf("pick = %s while a is ejected, want %s", got, testTargets[1])
+		}
+	}
+	if status := set.Statuses()["api"][testTargets[0]]; !status.Ejected {
+		t.Errorf("status = %+v, want ejected", status)
+	}
+
+	*now = now.Add(10 * time.Second)
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		seen[pick(set, up, breaker.Success)] = true
+	}
+	if !seen[testTargets[0]] {
+		t.Error("ejected target should return after the ejection duration")
+	}
+}
+
+func TestAllTargetsDownFailsOpen(t *testing.T) {
+	up := config.NamedUpstreamConfig{
+		Name: "api",
+		UpstreamConfig: config.UpstreamConfig{
+			Targets:  testTargets[:2],
+			Ejection: config.EjectionConfig{Enabled: true, ConsecutiveFailures: 1},
+		},
+	}
+	set, _ := newTestSet(t, &config.Config{})
+
+	pick(set, up, breaker.Failure)
+	pick(set, up, breaker.Failure)
+
+	seen := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		seen[pick(set, up, breaker.Ignored)] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("with every target ejected, picks should spread across all of them, got %v", seen)
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	cfg := &config.Config{Upstream: config.UpstreamConfig{
+		Targets: testTargets[:2],
+		HealthCheck: config.HealthCheckConfig{
+			Enabled:            true,
+			Path:               "/healthz",
+			Interval:           5 * time.Second,
+			HealthyThreshold:   1,
+			UnhealthyThreshold: 2,
+		},
+	}}
+	set, now := newTestSet(t, cfg)
+	up := cfg.AllUpstreams()[0]
+
+	healthy := map[string]bool{testTargets[0]: false, testTargets[1]: true}
+	probed := make(chan string, 10)
+	set.probe = func(_ context.Context, client *http.Client, url string) bool {
+		if client != testClient {
+			t.Error("probe should use the upstream's client")
+		}
+		probed <- url
+		return healthy[url[:len(url)-len("/healthz")]]
+	}
+
+	// probeAll runs one round of due probes and waits for them to finish
+	probeAll := func() {
+		t.Helper()
+		set.probeDue(context.Background())
+		for i := 0; i < 2; i++ {
+			select {
+			case url := <-probed:
+				if url != testTargets[0]+"/healthz" && url != testTargets[1]+"/healthz" {
+					t.Fatalf("probed %s", url)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("probe did not run")
+			}
+		}
+		// Let the probe goroutines record their results
+		for {
+			set.mu.Lock()
+			busy := false
+			for _, target := range set.upstreams[config.DefaultUpstreamName].targets {
+				busy = busy || target.probing
+			}
+			set.mu.Unlock()
+			if !busy {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	probeAll()
+	if !set.Statuses()[config.DefaultUpstreamName][testTargets[0]].Healthy {
+		t.Fatal("one failed probe should not reach the unhealthy threshold")
+	}
+
+	// Probes are not repeated before the interval
+	set.probeDue(context.Background())
+	select {
+	case url := <-probed:
+		t.Fatalf("probed %s before the interval elapsed", url)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	*now = now.Add(5 * time.Second)
+	probeAll()
+	if set.Statuses()[config.DefaultUpstreamName][testTargets[0]].Healthy {
+		t.Fatal("target should be unhealthy after two failed probes")
+	}
+	for i := 0; i < 3; i++ {
+		if got := pick(set, up, breaker.Success); got != testTargets[1] {
+			t.Fatalf("pick = %s, want healthy target", got)
+		}
+	}
+
+	healthy[testTargets[0]] = true
+	*now = now.Add(5 * time.Second)
+	probeAll()
+	if !set.Statuses()[config.DefaultUpstreamName][testTargets[0]].Healthy {
+		t.Error("target should be healthy again after a passing probe")
+	}
+}
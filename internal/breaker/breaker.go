@@ -0,0 +1,232 @@
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/metrics"
+)
+
+// State is the position of a circuit breaker
+type State string
+
+const (
+	// StateClosed lets every call through while counting failures
+	StateClosed State = "closed"
+	// StateOpen rejects every call until the open timeout elapses
+	StateOpen State = "open"
+	// StateHalfOpen lets a limited number of probe calls through
+	StateHalfOpen State = "half_open"
+)
+
+// ErrOpen is matched by errors returned when a breaker rejects a call
+var ErrOpen = errors.New("circuit breaker open")
+
+// OpenError reports which breaker rejected a call and when it will next probe
+type OpenError struct {
+	Endpoint   string
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("circuit breaker open for endpoint %s, retry in %s", e.Endpoint, e.RetryAfter.Round(time.Second))
+}
+
+// Is makes errors.Is(err, ErrOpen) match
+func (e *OpenError) Is(target error) bool {
+	return target == ErrOpen
+}
+
+// Outcome is the result of a call let through by a breaker
+type Outcome int
+
+const (
+	// Success is a call the upstream answered without a server error
+	Success Outcome = iota
+	// Failure is a transport error or 5xx response
+	Failure
+	// Ignored is a call that says nothing about upstream health, such as one
+	// abandoned by the client
+	Ignored
+)
+
+// Status describes a breaker for the health endpoint
+type Status struct {
+	State    State `json:"state"`
+	Requests int   `json:"requests"`
+	Failures int   `json:"failures"`
+	// RetryAfter is the number of seconds until an open breaker probes again
+	RetryAfter float64 `json:"retry_after_seconds,omitempty"`
+}
+
+// breaker is the state of one endpoint's circuit
+type breaker struct {
+	state State
+	// generation changes on every transition so outcomes of calls admitted
+	// in an earlier state are discarded
+	generation  uint64
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	// probes and successes count half-open calls admitted and succeeded
+	probes    int
+	successes int
+}
+
+// Set holds a circuit breaker per endpoint, following configuration reloads
+type Set struct {
+	config   *config.Holder
+	now      func() time.Time
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewSet creates an empty set of breakers
+func NewSet(configs *config.Holder) *Set {
+	return &Set{
+		config:   configs,
+		now:      time.Now,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// Allow asks whether a call to endpoint may proceed. If it may, the returned
+// function must be called with the call's outcome; otherwise an *OpenError is
+// returned.
+func (s *Set) Allow(endpoint string) (func(Outcome), error) {
+	cfg := s.config.Get().CircuitBreaker.WithDefaults()
+	if !cfg.Enabled {
+		return func(Outcome) {}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	b, ok := s.breakers[endpoint]
+	if !ok {
+		b = &breaker{state: StateClosed, windowStart: now}
+		s.breakers[endpoint] = b
+	}
+
+	switch b.state {
+	case StateClosed:
+		if now.Sub(b.windowStart) >= cfg.Window {
+			b.windowStart = now
+			b.requests, b.failures = 0, 0
+		}
+	case StateOpen:
+		if wait := b.openedAt.Add(cfg.OpenTimeout).Sub(now); wait > 0 {
+			return nil, &OpenError{Endpoint: endpoint, RetryAfter: wait}
+		}
+		s.transition(endpoint, b, StateHalfOpen, now)
+	}
+
+	if b.state == StateHalfOpen {
+		if b.probes >= cfg.HalfOpenRequests {
+			return nil, &OpenError{Endpoint: endpoint}
+		}
+		b.probes++
+	}
+
+	generation := b.generation
+	return func(outcome Outcome) {
+		s.record(endpoint, b, generation, outcome)
+	}, nil
+}
+
+// record applies the outcome of a call admitted in the given generation
+func (s *Set) record(endpoint string, b *breaker, generation uint64, outcome Outcome) {
+	cfg := s.config.Get().CircuitBreaker.WithDefaults()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b.generation != generation {
+		return
+	}
+	now := s.now()
+
+	switch b.state {
+	case StateClosed:
+		if outcome == Ignored {
+			return
+		}
+		b.requests++
+		if outcome == Failure {
+			b.failures++
+		}
+		if b.requests >= cfg.MinRequests && float64(b.failures)/float64(b.requests) >= cfg.FailureRatio {
+			s.transition(endpoint, b, StateOpen, now)
+		}
+	case StateHalfOpen:
+		switch outcome {
+		case Ignored:
+			b.probes--
+		case Failure:
+			s.transition(endpoint, b, StateOpen, now)
+		case Success:
+			b.successes++
+			if b.successes >= cfg.HalfOpenRequests {
+				s.transition(endpoint, b, StateClosed, now)
+			}
+		}
+	}
+}
+
+// transition moves a breaker to a new state and logs it. The caller must hold
+// the lock.
+func (s *Set) transition(endpoint string, b *breaker, to State, now time.Time) {
+	fields := map[string]interface{}{
+		"endpoint": endpoint,
+		"from":     string(b.state),
+		"to":       string(to),
+	}
+	if b.state == StateClosed {
+		fields["requests"] = b.requests
+		fields["failures"] = b.failures
+	}
+	entry := logger.WithFields(fields)
+	if to == StateOpen {
+		entry.Warn("Circuit breaker opened, short-circuiting upstream calls")
+	} else {
+		entry.Info("Circuit breaker state changed")
+	}
+	metrics.RecordCircuitBreakerTransition(endpoint, string(to))
+
+	b.state = to
+	b.generation++
+	b.requests, b.failures = 0, 0
+	b.probes, b.successes = 0, 0
+	switch to {
+	case StateOpen:
+		b.openedAt = now
+	case StateClosed:
+		b.windowStart = now
+	}
+}
+
+// Statuses returns the state of every breaker that has seen a call, keyed by
+// endpoint
+func (s *Set) Statuses() map[string]Status {
+	cfg := s.config.Get().CircuitBreaker.WithDefaults()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	statuses := make(map[string]Status, len(s.breakers))
+	for endpoint, b := range s.breakers {
+		status := Status{State: b.state, Requests: b.requests, Failures: b.failures}
+		if b.state == StateOpen {
+			status.RetryAfter = max(b.openedAt.Add(cfg.OpenTimeout).Sub(now), 0).Seconds()
+		}
+		statuses[endpoint] = status
+	}
+	return statuses
+}
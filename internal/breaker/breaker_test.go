@@ -0,0 +1,154 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// newTestSet returns a breaker set with a controllable clock
+func newTestSet(t *testing.T, cfg config.CircuitBreakerConfig) (*Set, *time.Time) {
+	t.Helper()
+	logger.Init(config.LoggingConfig{Level: "error"})
+
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	set := NewSet(config.NewHolder(&config.Config{CircuitBreaker: cfg}))
+	set.now = func() time.Time { return now }
+	return set, &now
+}
+
+// call runs one call through the breaker with the given outcome
+func call(t *testing.T, set *Set, outcome Outcome) error {
+	t.Helper()
+	done, err := set.Allow("/api")
+	if err != nil {
+		return err
+	}
+	done(outcome)
+	return nil
+}
+
+func TestBreakerOpensOnFailureRatio(t *testing.T) {
+	set, _ := newTestSet(t, config.CircuitBreakerConfig{Enabled: true, FailureRatio: 0.5, MinRequests: 4})
+
+	for _, outcome := range []Outcome{Success, Failure, Success} {
+		if err := call(t, set, outcome); err != nil {
+			t.Fatalf("call rejected while closed: %v", err)
+		}
+	}
+	if state := set.Statuses()["/api"].State; state != StateClosed {
+		t.Fatalf("state = %s before min_requests, want closed", state)
+	}
+
+	if err := call(t, set, Failure); err != nil {
+		t.Fatalf("call rejected while closed: %v", err)
+	}
+	if state := set.Statuses()["/api"].State; state != StateOpen {
+		t.Fatalf("state = %s after 2/4 failures, want open", state)
+	}
+
+	err := call(t, set, Success)
+	var open *OpenError
+	if !errors.Is(err, ErrOpen) || !errors.As(err, &open) || open.RetryAfter != config.DefaultBreakerOpenTimeout {
+		t.Errorf("Allow error = %v, want OpenError with full open timeout", err)
+	}
+}
+
+func TestBreakerWindowResetsCounts(t *testing.T) {
+	set, now := newTestSet(t, config.CircuitBreakerConfig{Enabled: true, MinRequests: 2, Window: time.Second})
+
+	call(t, set, Failure)
+	*now = now.Add(2 * time.Second)
+	call(t, set, Failure)
+
+	if status := set.Statuses()["/api"]; status.State != StateClosed || status.Requests != 1 {
+		t.Errorf("status = %+v, want closed with counts reset by the window", status)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	set, now := newTestSet(t, config.CircuitBreakerConfig{
+		Enabled:          true,
+		MinRequests:      1,
+		OpenTimeout:      10 * time.Second,
+		HalfOpenRequests: 2,
+	})
+
+	call(t, set, Failure)
+	*now = now.Add(10 * time.Second)
+
+	// Only half_open_requests probes are let through
+	probe1, err := set.Allow("/api")
+	if err != nil {
+		t.Fatalf("first probe rejected: %v", err)
+	}
+	probe2, err := set.Allow("/api")
+	if err != nil {
+		t.Fatalf("second probe rejected: %v", err)
+	}
+	if _, err := set.Allow("/api"); !errors.Is(err, ErrOpen) {
+		t.Fatalf("third call error = %v, want ErrOpen while probing", err)
+	}
+	if state := set.Statuses()["/api"].State; state != StateHalfOpen {
+		t.Fatalf("state = %s, want half_open", state)
+	}
+
+	probe1(Success)
+	probe2(Success)
+	if state := set.Statuses()["/api"].State; state != StateClosed {
+		t.Fatalf("state = %s after successful probes, want closed", state)
+	}
+}
+
+func TestBreakerHalfOpenFailureReopens(t *testing.T) {
+	set, now := newTestSet(t, config.CircuitBreakerConfig{Enabled: true, MinRequests: 1, OpenTimeout: time.Second})
+
+	call(t, set, Failure)
+	*now = now.Add(time.Second)
+
+	probe, err := set.Allow("/api")
+	if err != nil {
+		t.Fatalf("probe rejected: %v", err)
+	}
+	probe(Ignored)
+
+	// An ignored probe frees its slot
+	if err := call(t, set, Failure); err != nil {
+		t.Fatalf("probe rejected after ignored probe: %v", err)
+	}
+	if status := set.Statuses()["/api"]; status.State != StateOpen || status.RetryAfter != 1 {
+		t.Errorf("status = %+v, want reopened for the full timeout", status)
+	}
+}
+
+func TestBreakerIgnoresStaleOutcomes(t *testing.T) {
+	set, _ := newTestSet(t, config.CircuitBreakerConfig{Enabled: true, MinRequests: 1})
+
+	slow, err := set.Allow("/api")
+	if err != nil {
+		t.Fatalf("call rejected: %v", err)
+	}
+	call(t, set, Failure)
+
+	// A call admitted before the breaker opened must not affect the open state
+	slow(Success)
+	if state := set.Statuses()["/api"].State; state != StateOpen {
+		t.Errorf("state = %s, want open", state)
+	}
+}
+
+func TestBreakerDisabled(t *testing.T) {
+	set, _ := newTestSet(t, config.CircuitBreakerConfig{MinRequests: 1})
+
+	for i := 0; i < 3; i++ {
+		if err := call(t, set, Failure); err != nil {
+			t.Fatalf("disabled breaker rejected call: %v", err)
+		}
+	}
+	if len(set.Statuses()) != 0 {
+		t.Error("disabled breaker should not track endpoints")
+	}
+}
@@ -2,11 +2,12 @@ package cache
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -16,11 +17,17 @@ import (
 
 	"github.com/singh-gur/api_cache/internal/config"
 	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/metrics"
 )
 
 type Client struct {
 	redis  *redis.Client
-	config *config.Config
+	config *config.Holder
+	// l1 is the optional in-process cache consulted before Valkey
+	l1     *lruCache
+	pubsub *redis.PubSub
+	// instanceID tags invalidation messages so a replica ignores its own
+	instanceID string
 }
 
 type CachedResponse struct {
@@ -28,10 +35,40 @@ type CachedResponse struct {
 	Headers    map[string][]string `json:"headers"`
 	Body       []byte              `json:"body"`
 	CachedAt   time.Time           `json:"cached_at"`
+	// FreshUntil is when the entry stops being fresh. Past it, the entry may
+	// still be served stale until ExpiresAt, when Valkey evicts it.
+	FreshUntil time.Time `json:"fresh_until,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at,omitempty"`
+	// EndpointID identifies the endpoint config the entry was cached under
+	EndpointID string `json:"endpoint_id,omitempty"`
+	// Encoding is the content coding applied to Body at rest by the cache
+	// (gzip or zstd), or empty if Body is stored as received from upstream
+	Encoding string `json:"encoding,omitempty"`
+	// GeneratedETag is set when the entry's ETag was derived from the body by
+	// the cache rather than sent by the upstream, which never saw it
+	GeneratedETag bool `json:"generated_etag,omitempty"`
 }
 
-// NewClient creates a new cache client
-func NewClient(cfg *config.Config) (*Client, error) {
+// IsFresh reports whether the entry is still fresh at the given time.
+// Entries written before freshness tracking are always considered fresh.
+func (r *CachedResponse) IsFresh(now time.Time) bool {
+	return r.FreshUntil.IsZero() || now.Before(r.FreshUntil)
+}
+
+// DueForRefresh reports whether the entry has been cached for at least
+// fraction of its freshness lifetime and should be renewed ahead of expiry
+func (r *CachedResponse) DueForRefresh(now time.Time, fraction float64) bool {
+	if fraction <= 0 || r.FreshUntil.IsZero() {
+		return false
+	}
+	lifetime := r.FreshUntil.Sub(r.CachedAt)
+	return !now.Before(r.CachedAt.Add(time.Duration(fraction * float64(lifetime))))
+}
+
+// NewClient creates a new cache client. Valkey connection and L1 settings are
+// read once; everything else follows configuration reloads.
+func NewClient(configs *config.Holder) (*Client, error) {
+	cfg := configs.Get()
 	rdb := redis.NewClient(&redis.Options{
 		Addr:         fmt.Sprintf("%s:%d", cfg.Valkey.Host, cfg.Valkey.Port),
 		Password:     cfg.Valkey.Password,
@@ -41,6 +78,8 @@ func NewClient(cfg *config.Config) (*Client, error) {
 		MinIdleConns: cfg.Valkey.MinIdleConns,
 	})
 
+	rdb.AddHook(metrics.ValkeyHook{})
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -50,16 +89,47 @@ func NewClient(cfg *config.Config) (*Client, error) {
 
 	logger.Log.Info("Successfully connected to Valkey")
 
-	return &Client{
+	c := &Client{
 		redis:  rdb,
-		config: cfg,
-	}, nil
+		config: configs,
+	}
+
+	if cfg.Cache.L1.Enabled {
+		c.l1 = newLRU(cfg.Cache.L1.MaxEntries, cfg.Cache.L1.MaxBytes)
+		if err := c.subscribeInvalidations(); err != nil {
+			rdb.Close()
+			return nil, err
+		}
+		logger.WithFields(map[string]interface{}{
+			"max_entries": cfg.Cache.L1.MaxEntries,
+			"max_bytes":   cfg.Cache.L1.MaxBytes,
+			"ttl":         cfg.Cache.L1.TTL.Seconds(),
+		}).Info("In-process L1 cache enabled")
+	}
+
+	return c, nil
+}
+
+// GenerateCacheKey creates a unique cache key based on request properties.
+// upstream is the name of the upstream the request is routed to; requests to
+// the default upstream keep the keys they had before routes existed.
+func (c *Client) GenerateCacheKey(r *http.Request, upstream string, endpointConfig *config.EndpointCacheConfig) string {
+	return hashKeyMaterial(keyMaterial(r, upstream, endpointConfig, nil))
 }
 
-// GenerateCacheKey creates a unique cache key based on request properties
-func (c *Client) GenerateCacheKey(r *http.Request, endpointConfig *config.EndpointCacheConfig) string {
+// keyMaterial returns the canonical string hashed into a request's cache key.
+// If redact is set, it is applied to every query parameter and header value.
+func keyMaterial(r *http.Request, upstream string, endpointConfig *config.EndpointCacheConfig, redact func(name, value string) string) string {
+	if redact == nil {
+		redact = func(_, value string) string { return value }
+	}
 	var keyParts []string
 
+	// Keep identical paths on different upstreams apart
+	if upstream != "" && upstream != config.DefaultUpstreamName {
+		keyParts = append(keyParts, "upstream="+upstream)
+	}
+
 	// Add method and path
 	keyParts = append(keyParts, r.Method, r.URL.Path)
 
@@ -69,7 +139,7 @@ func (c *Client) GenerateCacheKey(r *http.Request, endpointConfig *config.Endpoi
 		var queryParts []string
 		for _, param := range endpointConfig.CacheKeyQueryParams {
 			if val := query.Get(param); val != "" {
-				queryParts = append(queryParts, fmt.Sprintf("%s=%s", param, val))
+				queryParts = append(queryParts, fmt.Sprintf("%s=%s", param, redact(param, val)))
 			}
 		}
 		sort.Strings(queryParts)
@@ -83,7 +153,7 @@ func (c *Client) GenerateCacheKey(r *http.Request, endpointConfig *config.Endpoi
 		var headerParts []string
 		for _, header := range endpointConfig.CacheKeyHeaders {
 			if val := r.Header.Get(header); val != "" {
-				headerParts = append(headerParts, fmt.Sprintf("%s=%s", header, val))
+				headerParts = append(headerParts, fmt.Sprintf("%s=%s", header, redact(header, val)))
 			}
 		}
 		sort.Strings(headerParts)
@@ -92,18 +162,35 @@ func (c *Client) GenerateCacheKey(r *http.Request, endpointConfig *config.Endpoi
 		}
 	}
 
-	// Create hash of the key parts
-	keyString := strings.Join(keyParts, ":")
-	hash := sha256.Sum256([]byte(keyString))
-	return "cache:" + hex.EncodeToString(hash[:])
+	return strings.Join(keyParts, ":")
+}
+
+// keyPrefix namespaces every key the cache owns: entries and the indexes
+// pointing at them
+const keyPrefix = "cache:"
+
+// hashKeyMaterial returns the cache key for key material
+func hashKeyMaterial(material string) string {
+	hash := sha256.Sum256([]byte(material))
+	return keyPrefix + hex.EncodeToString(hash[:])
 }
 
-// Get retrieves a cached response
+// Get retrieves a cached response, consulting the in-process L1 cache first
+// when enabled
 func (c *Client) Get(ctx context.Context, key string) (*CachedResponse, error) {
-	data, err := c.redis.Get(ctx, key).Bytes()
+	if c.l1 != nil {
+		if cached, ok := c.l1.get(key, time.Now()); ok {
+			metrics.RecordL1Result("hit")
+			logger.WithField("cache_key", key).Debug("Cache hit (L1)")
+			return cached, nil
+		}
+		metrics.RecordL1Result("miss")
+	}
+
+	debug := logger.Log.IsLevelEnabled(logrus.DebugLevel)
+	data, remainingTTL, err := c.fetch(ctx, key, c.l1 != nil || debug)
 	if err != nil {
 		if err == redis.Nil {
-			// Check remaining TTL for debugging (key doesn't exist)
 			logger.WithFields(map[string]interface{}{
 				"cache_key": key,
 			}).Debug("Cache miss (key not found in store)")
@@ -112,78 +199,295 @@ func (c *Client) Get(ctx context.Context, key string) (*CachedResponse, error) {
 		return nil, fmt.Errorf("failed to get cache: %w", err)
 	}
 
-	var cached CachedResponse
-	if err := json.Unmarshal(data, &cached); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal cached response: %w", err)
+	cached, err := decodeResponse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cached response: %w", err)
 	}
 
-	logFields := map[string]interface{}{
-		"cache_key": key,
+	if debug {
+		logger.WithFields(map[string]interface{}{
+			"cache_key":     key,
+			"cache_age":     time.Since(cached.CachedAt).Seconds(),
+			"cached_at":     cached.CachedAt.Format(time.RFC3339),
+			"remaining_ttl": remainingTTL.Seconds(),
+		}).Debug("Cache hit")
 	}
-	// Only pay the extra Valkey RTT for remaining TTL when debug logging is active
-	if logger.Log.IsLevelEnabled(logrus.DebugLevel) {
-		logFields["cache_age"] = time.Since(cached.CachedAt).Seconds()
-		logFields["cached_at"] = cached.CachedAt.Format(time.RFC3339)
-		if remainingTTL, err := c.redis.TTL(ctx, key).Result(); err == nil {
-			logFields["remaining_ttl"] = remainingTTL.Seconds()
+
+	if c.l1 != nil {
+		c.addToL1(key, cached, remainingTTL)
+	}
+	return cached, nil
+}
+
+// fetch reads a key from Valkey. With withTTL it also returns the key's
+// remaining TTL in the same round trip, so L1 never outlives the Valkey entry.
+func (c *Client) fetch(ctx context.Context, key string, withTTL bool) ([]byte, time.Duration, error) {
+	if !withTTL {
+		data, err := c.redis.Get(ctx, key).Bytes()
+		return data, 0, err
+	}
+
+	pipe := c.redis.Pipeline()
+	getCmd := pipe.Get(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, 0, err
+	}
+	data, err := getCmd.Bytes()
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, ttlCmd.Val(), nil
+}
+
+// Peek retrieves a cached response straight from Valkey, neither consulting nor
+// filling L1, so inspecting an entry does not change what this replica serves
+func (c *Client) Peek(ctx context.Context, key string) (*CachedResponse, error) {
+	data, err := c.redis.Get(ctx, key).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
 		}
+		return nil, fmt.Errorf("failed to get cache: %w", err)
+	}
+
+	cached, err := decodeResponse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode cached response: %w", err)
+	}
+	return cached, nil
+}
+
+// addToL1 stores a response in the L1 cache for at most the configured L1 TTL,
+// the remaining Valkey TTL, and the entry's remaining freshness. Stale entries
+// are not stored so that background revalidations are picked up promptly.
+func (c *Client) addToL1(key string, cached *CachedResponse, remainingTTL time.Duration) {
+	now := time.Now()
+	if !cached.IsFresh(now) || remainingTTL <= 0 {
+		return
+	}
+
+	ttl := remainingTTL
+	if l1TTL := c.config.Get().Cache.L1.TTL; l1TTL > 0 && l1TTL < ttl {
+		ttl = l1TTL
+	}
+	if !cached.FreshUntil.IsZero() {
+		ttl = min(ttl, cached.FreshUntil.Sub(now))
 	}
-	logger.WithFields(logFields).Debug("Cache hit")
-	return &cached, nil
+	c.l1.add(key, cached, ttl, now)
 }
 
 // Set stores a response in cache
 func (c *Client) Set(ctx context.Context, key string, response *CachedResponse, ttl time.Duration) error {
-	data, err := json.Marshal(response)
+	stored := c.compressForStorage(response)
+	data, err := encodeResponse(stored, c.config.Get().Cache.StorageFormat)
 	if err != nil {
-		return fmt.Errorf("failed to marshal response: %w", err)
+		return fmt.Errorf("failed to encode response: %w", err)
 	}
 
 	if err := c.redis.Set(ctx, key, data, ttl).Err(); err != nil {
 		return fmt.Errorf("failed to set cache: %w", err)
 	}
 
+	// Drop older L1 copies here and on other replicas; the next Get
+	// repopulates L1 from Valkey
+	c.invalidateL1(ctx, invalidation{Keys: []string{key}})
+
 	logger.WithFields(map[string]interface{}{
 		"cache_key":  key,
 		"ttl":        ttl.Seconds(),
 		"expires_at": time.Now().Add(ttl).Format(time.RFC3339),
 		"body_size":  len(response.Body),
+		"data_size":  len(data),
+		"encoding":   stored.Encoding,
 	}).Debug("Cached response")
 
 	return nil
 }
 
-// Delete removes a cached response
+// Delete removes a cached response. With L1 enabled, the removal is also
+// broadcast so other replicas drop their local copies.
 func (c *Client) Delete(ctx context.Context, key string) error {
 	if err := c.redis.Del(ctx, key).Err(); err != nil {
 		return fmt.Errorf("failed to delete cache: %w", err)
 	}
+	c.invalidateL1(ctx, invalidation{Keys: []string{key}})
 	return nil
 }
 
-// DeletePattern removes all cached responses matching a pattern
-func (c *Client) DeletePattern(ctx context.Context, pattern string) error {
+// DeletePattern removes all cached responses matching a pattern and returns the
+// number of keys deleted
+func (c *Client) DeletePattern(ctx context.Context, pattern string) (int, error) {
+	deleted, err := c.deleteMatching(ctx, pattern)
+	if err != nil {
+		return deleted, err
+	}
+	c.invalidateL1(ctx, invalidation{Pattern: pattern})
+	return deleted, nil
+}
+
+// deleteMatching removes the Valkey keys matching a pattern without touching L1
+func (c *Client) deleteMatching(ctx context.Context, pattern string) (int, error) {
+	deleted := 0
 	iter := c.redis.Scan(ctx, 0, pattern, 0).Iterator()
 	for iter.Next(ctx) {
-		if err := c.redis.Del(ctx, iter.Val()).Err(); err != nil {
+		n, err := c.redis.Del(ctx, iter.Val()).Result()
+		if err != nil {
 			logger.WithField("key", iter.Val()).Error("Failed to delete cache key")
+			continue
 		}
+		deleted += int(n)
 	}
 	if err := iter.Err(); err != nil {
-		return fmt.Errorf("failed to scan cache: %w", err)
+		return deleted, fmt.Errorf("failed to scan cache: %w", err)
+	}
+	return deleted, nil
+}
+
+// DeleteAll removes every cache entry along with the tag and path indexes
+// pointing at them, and returns the number of keys deleted. Keys outside the
+// cache's namespace are left alone.
+func (c *Client) DeleteAll(ctx context.Context) (int, error) {
+	return c.DeletePattern(ctx, keyPrefix+"*")
+}
+
+// DeleteRequest removes the entry stored under a base cache key along with any
+// Vary variants and the recorded Vary header list, returning the number of keys deleted
+func (c *Client) DeleteRequest(ctx context.Context, baseKey string) (int, error) {
+	n, err := c.redis.Del(ctx, baseKey, baseKey+varySuffix).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete cache: %w", err)
+	}
+	c.invalidateL1(ctx, invalidation{Keys: []string{baseKey}})
+	variants, err := c.DeletePattern(ctx, baseKey+variantSeparator+"*")
+	return int(n) + variants, err
+}
+
+// DeleteEntries removes every cached response for which match returns true and
+// returns the number of entries deleted. It scans the whole keyspace, so it is
+// meant for administrative use rather than the request path. Entries are read
+// without filling L1 and the removal is broadcast once at the end.
+func (c *Client) DeleteEntries(ctx context.Context, match func(key string, response *CachedResponse) bool) (int, error) {
+	var matched []string
+	iter := c.redis.Scan(ctx, 0, keyPrefix+"*", 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		if !IsEntryKey(key) {
+			continue
+		}
+		cached, err := c.Peek(ctx, key)
+		if err != nil || cached == nil || !match(key, cached) {
+			continue
+		}
+		matched = append(matched, key)
+	}
+	scanErr := iter.Err()
+
+	deleted := 0
+	for batch := range slices.Chunk(matched, deleteBatchSize) {
+		n, err := c.redis.Del(ctx, batch...).Result()
+		if err != nil {
+			logger.WithFields(map[string]interface{}{
+				"error": err,
+				"keys":  len(batch),
+			}).Error("Failed to delete cache keys")
+			continue
+		}
+		deleted += int(n)
+	}
+	if len(matched) > 0 {
+		c.invalidateL1(ctx, invalidation{Keys: matched})
+	}
+
+	if scanErr != nil {
+		return deleted, fmt.Errorf("failed to scan cache: %w", scanErr)
+	}
+	return deleted, nil
+}
+
+// deleteBatchSize bounds the number of keys removed by a single DEL
+const deleteBatchSize = 500
+
+// IsEntryKey reports whether a key holds a cached response rather than
+// bookkeeping such as fetch locks, Vary header lists, or tag and path indexes
+func IsEntryKey(key string) bool {
+	return strings.HasPrefix(key, keyPrefix) && !isIndexKey(key) &&
+		!strings.HasSuffix(key, lockSuffix) && !strings.HasSuffix(key, varySuffix)
+}
+
+// RemainingTTL returns how long until Valkey evicts a key. It returns a negative
+// duration if the key does not exist or has no expiry.
+func (c *Client) RemainingTTL(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := c.redis.PTTL(ctx, key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get ttl: %w", err)
+	}
+	return ttl, nil
+}
+
+// lockSuffix is appended to a cache key to form the key of its fetch lock.
+const lockSuffix = ":lock"
+
+// releaseLockScript deletes a lock only if it is still held by the caller's token,
+// so a lock that expired and was re-acquired by another replica is left alone.
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// AcquireLock tries to take the short-lived fetch lock for a cache key.
+// It returns the lock token and true if the lock was acquired.
+func (c *Client) AcquireLock(ctx context.Context, key string, ttl time.Duration) (string, bool, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+
+	acquired, err := c.redis.SetNX(ctx, key+lockSuffix, token, ttl).Result()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	return token, acquired, nil
+}
+
+// ReleaseLock releases a fetch lock previously acquired with AcquireLock
+func (c *Client) ReleaseLock(ctx context.Context, key, token string) error {
+	if err := releaseLockScript.Run(ctx, c.redis, []string{key + lockSuffix}, token).Err(); err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to release lock: %w", err)
 	}
 	return nil
 }
 
+// IsLocked reports whether the fetch lock for a cache key is currently held
+func (c *Client) IsLocked(ctx context.Context, key string) (bool, error) {
+	n, err := c.redis.Exists(ctx, key+lockSuffix).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check lock: %w", err)
+	}
+	return n > 0, nil
+}
+
+// Ping checks that Valkey is reachable
+func (c *Client) Ping(ctx context.Context) error {
+	return c.redis.Ping(ctx).Err()
+}
+
 // Close closes the cache client connection
 func (c *Client) Close() error {
+	if c.pubsub != nil {
+		c.pubsub.Close()
+	}
 	return c.redis.Close()
 }
 
 // GetTTL returns the TTL for a specific endpoint or the default TTL
 func (c *Client) GetTTL(path, method string, queryParams map[string][]string) time.Duration {
-	if endpointConfig := c.config.GetEndpointCacheConfig(path, method, queryParams); endpointConfig != nil {
+	cfg := c.config.Get()
+	if endpointConfig := cfg.GetEndpointCacheConfig(path, method, queryParams); endpointConfig != nil {
 		return endpointConfig.TTL
 	}
-	return c.config.Cache.DefaultTTL
+	return cfg.Cache.DefaultTTL
 }
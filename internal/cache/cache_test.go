@@ -1,16 +1,64 @@
 package cache
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
 
 	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
 )
 
+// initLogger initializes logging once for every test in the package
+var initLogger sync.Once
+
+// newTestClient returns a client of the Valkey mr with the cache settings in
+// cfg. Several clients of one mr behave like replicas.
+func newTestClient(t *testing.T, mr *miniredis.Miniredis, cfg config.CacheConfig) *Client {
+	t.Helper()
+	initLogger.Do(func() { logger.Init(config.LoggingConfig{Level: "error"}) })
+
+	host, port, _ := net.SplitHostPort(mr.Addr())
+	full := &config.Config{Cache: cfg}
+	full.Valkey.Host = host
+	full.Valkey.Port, _ = strconv.Atoi(port)
+
+	c, err := NewClient(config.NewHolder(full))
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// l1Config enables L1 with a TTL long enough to outlast any test
+func l1Config() config.CacheConfig {
+	return config.CacheConfig{L1: config.L1CacheConfig{Enabled: true, MaxEntries: 100, TTL: time.Hour}}
+}
+
+// testResponse returns a fresh response with body and endpoint
+func testResponse(body, endpoint string) *CachedResponse {
+	now := time.Now()
+	return &CachedResponse{
+		StatusCode: http.StatusOK,
+		Body:       []byte(body),
+		CachedAt:   now,
+		FreshUntil: now.Add(time.Hour),
+		EndpointID: endpoint,
+	}
+}
+
 func TestGenerateCacheKey(t *testing.T) {
 	cfg := &config.Config{}
-	client := &Client{config: cfg}
+	client := &Client{config: config.NewHolder(cfg)}
 
 	endpointConfig := &config.EndpointCacheConfig{
 		Path:                "/api/users",
@@ -78,7 +126,7 @@ func TestGenerateCacheKey(t *testing.T) {
 			}
 
 			// Generate cache key
-			key := client.GenerateCacheKey(req, tt.endpointConfig)
+			key := client.GenerateCacheKey(req, config.DefaultUpstreamName, tt.endpointConfig)
 
 			if key == "" {
 				t.Error("cache key should not be empty")
@@ -101,7 +149,7 @@ func TestGenerateCacheKey(t *testing.T) {
 
 func TestGenerateCacheKeyWithEndpointConfig(t *testing.T) {
 	cfg := &config.Config{}
-	client := &Client{config: cfg}
+	client := &Client{config: config.NewHolder(cfg)}
 
 	endpointConfig := &config.EndpointCacheConfig{
 		Path:                "/api/users",
@@ -133,8 +181,8 @@ func TestGenerateCacheKeyWithEndpointConfig(t *testing.T) {
 		},
 	}
 
-	key1 := client.GenerateCacheKey(req1, endpointConfig)
-	key2 := client.GenerateCacheKey(req2, endpointConfig)
+	key1 := client.GenerateCacheKey(req1, config.DefaultUpstreamName, endpointConfig)
+	key2 := client.GenerateCacheKey(req2, config.DefaultUpstreamName, endpointConfig)
 
 	if key1 == key2 {
 		t.Error("different Authorization headers should generate different cache keys")
@@ -152,9 +200,152 @@ func TestGenerateCacheKeyWithEndpointConfig(t *testing.T) {
 		},
 	}
 
-	key3 := client.GenerateCacheKey(req3, endpointConfig)
+	key3 := client.GenerateCacheKey(req3, config.DefaultUpstreamName, endpointConfig)
 
 	if key1 != key3 {
 		t.Error("unconfigured query params should not affect cache key")
 	}
 }
+
+func TestGenerateCacheKeyUpstream(t *testing.T) {
+	client := &Client{config: config.NewHolder(&config.Config{})}
+	req := &http.Request{Method: "GET", URL: &url.URL{Path: "/v1/items"}, Header: make(http.Header)}
+
+	defaultKey := client.GenerateCacheKey(req, config.DefaultUpstreamName, nil)
+	if unnamed := client.GenerateCacheKey(req, "", nil); unnamed != defaultKey {
+		t.Error("empty upstream name should key like the default upstream")
+	}
+
+	billing := client.GenerateCacheKey(req, "billing", nil)
+	inventory := client.GenerateCacheKey(req, "inventory", nil)
+	if billing == defaultKey || billing == inventory {
+		t.Error("identical paths on different upstreams should generate different cache keys")
+	}
+}
+
+func TestCachedResponseIsFresh(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		response CachedResponse
+		want     bool
+	}{
+		{"legacy entry without freshness", CachedResponse{}, true},
+		{"fresh entry", CachedResponse{FreshUntil: now.Add(time.Minute)}, true},
+		{"stale entry", CachedResponse{FreshUntil: now.Add(-time.Minute)}, false},
+		{"entry expiring now", CachedResponse{FreshUntil: now}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.response.IsFresh(now); got != tt.want {
+				t.Errorf("IsFresh() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachedResponseDueForRefresh(t *testing.T) {
+	cachedAt := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	entry := CachedResponse{CachedAt: cachedAt, FreshUntil: cachedAt.Add(100 * time.Second)}
+
+	tests := []struct {
+		name     string
+		response CachedResponse
+		age      time.Duration
+		fraction float64
+		want     bool
+	}{
+		{"disabled", entry, 99 * time.Second, 0, false},
+		{"young entry", entry, 79 * time.Second, 0.8, false},
+		{"at the refresh point", entry, 80 * time.Second, 0.8, true},
+		{"past the refresh point", entry, 95 * time.Second, 0.8, true},
+		{"legacy entry without freshness", CachedResponse{CachedAt: cachedAt}, time.Hour, 0.8, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.response.DueForRefresh(cachedAt.Add(tt.age), tt.fraction); got != tt.want {
+				t.Errorf("DueForRefresh() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetInvalidatesPeerL1(t *testing.T) {
+	mr := miniredis.RunT(t)
+	writer := newTestClient(t, mr, l1Config())
+	reader := newTestClient(t, mr, l1Config())
+	ctx := context.Background()
+
+	if err := writer.Set(ctx, "cache:k", testResponse("v1", ""), time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if cached, _ := reader.Get(ctx, "cache:k"); cached == nil || string(cached.Body) != "v1" {
+		t.Fatalf("Get() = %v, want v1", cached)
+	}
+
+	if err := writer.Set(ctx, "cache:k", testResponse("v2", ""), time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cached, _ := reader.Get(ctx, "cache:k")
+		if cached != nil && string(cached.Body) == "v2" {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("peer still serves %q from L1 after Set", cached.Body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDeleteEntries(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestClient(t, mr, l1Config())
+	ctx := context.Background()
+
+	for key, endpoint := range map[string]string{"cache:a": "/users", "cache:b": "/users", "cache:c": "/orders"} {
+		if err := c.Set(ctx, key, testResponse("body", endpoint), time.Hour); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+
+	peer := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer peer.Close()
+	sub := peer.Subscribe(ctx, invalidationChannel)
+	defer sub.Close()
+	if _, err := sub.Receive(ctx); err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+
+	deleted, err := c.DeleteEntries(ctx, func(_ string, cached *CachedResponse) bool {
+		return cached.EndpointID == "/users"
+	})
+	if err != nil || deleted != 2 {
+		t.Fatalf("DeleteEntries() = %d, %v, want 2 deleted", deleted, err)
+	}
+	if mr.Exists("cache:a") || mr.Exists("cache:b") || !mr.Exists("cache:c") {
+		t.Errorf("keys left = %v, want only cache:c", mr.Keys())
+	}
+
+	// One invalidation covers the whole purge
+	select {
+	case <-sub.Channel():
+	case <-time.After(time.Second):
+		t.Fatal("no invalidation published")
+	}
+	select {
+	case msg := <-sub.Channel():
+		t.Errorf("unexpected second invalidation %s", msg.Payload)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Scanning must not have filled L1 with the entries it kept
+	mr.Del("cache:c")
+	if cached, _ := c.Get(ctx, "cache:c"); cached != nil {
+		t.Error("DeleteEntries filled L1 with a scanned entry")
+	}
+}
@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// UpstreamPolicy is the caching policy an upstream response asks for through its
+// Cache-Control, Expires, and Vary headers (RFC 9111).
+type UpstreamPolicy struct {
+	// NoStore is set when the response must not be stored by a shared cache
+	// (no-store, private, no-cache, or Vary: *).
+	NoStore bool
+	// TTL is the freshness lifetime requested by the upstream. It is only
+	// meaningful when HasTTL is set.
+	TTL    time.Duration
+	HasTTL bool
+	// Vary lists the canonicalized request headers the response varies on.
+	Vary []string
+}
+
+// ParseUpstreamPolicy derives the caching policy from upstream response headers.
+// Freshness comes from s-maxage, then max-age, then Expires relative to Date,
+// less any Age the response has already accumulated.
+func ParseUpstreamPolicy(header http.Header, now time.Time) UpstreamPolicy {
+	var policy UpstreamPolicy
+
+	directives := parseCacheControl(header.Values("Cache-Control"))
+	for _, d := range []string{"no-store", "private", "no-cache"} {
+		if _, ok := directives[d]; ok {
+			policy.NoStore = true
+		}
+	}
+
+	policy.Vary = ParseVary(header)
+	if slices.Contains(policy.Vary, "*") {
+		policy.NoStore = true
+	}
+
+	if v, ok := directives["s-maxage"]; ok {
+		policy.TTL, policy.HasTTL = parseSeconds(v)
+	}
+	if v, ok := directives["max-age"]; ok && !policy.HasTTL {
+		policy.TTL, policy.HasTTL = parseSeconds(v)
+	}
+	if expires := header.Get("Expires"); expires != "" && !policy.HasTTL {
+		policy.HasTTL = true
+		// An invalid Expires value means "already expired"
+		if exp, err := http.ParseTime(expires); err == nil {
+			base := now
+			if date, err := http.ParseTime(header.Get("Date")); err == nil {
+				base = date
+			}
+			policy.TTL = exp.Sub(base)
+		}
+	}
+
+	if policy.HasTTL {
+		if age, ok := parseSeconds(header.Get("Age")); ok {
+			policy.TTL -= age
+		}
+		if policy.TTL < 0 {
+			policy.TTL = 0
+		}
+	}
+
+	return policy
+}
+
+// parseCacheControl splits Cache-Control header values into lowercased directives
+func parseCacheControl(values []string) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// parseSeconds parses a non-negative delta-seconds value
+func parseSeconds(value string) (time.Duration, bool) {
+	n, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// ParseVary returns the sorted, canonicalized header names listed in Vary
+func ParseVary(header http.Header) []string {
+	var vary []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if name != "*" {
+				name = http.CanonicalHeaderKey(name)
+			}
+			if !slices.Contains(vary, name) {
+				vary = append(vary, name)
+			}
+		}
+	}
+	slices.Sort(vary)
+	return vary
+}
+
+// variantSeparator joins a base cache key and the hash of the request's values
+// for the headers the response varies on.
+const variantSeparator = ":v:"
+
+// varySuffix is appended to a base cache key to store the Vary header list
+// learned from the upstream.
+const varySuffix = ":vary"
+
+// VariantKey extends a base cache key with the request's values for the headers
+// listed in vary, so each variant of a response is cached separately.
+func VariantKey(baseKey string, header http.Header, vary []string) string {
+	if len(vary) == 0 {
+		return baseKey
+	}
+	var parts []string
+	for _, name := range vary {
+		parts = append(parts, name+"="+strings.Join(header.Values(name), ","))
+	}
+	hash := sha256.Sum256([]byte(strings.Join(parts, "|")))
+	return baseKey + variantSeparator + hex.EncodeToString(hash[:16])
+}
+
+// BaseKey strips any variant suffix added by VariantKey
+func BaseKey(key string) string {
+	base, _, _ := strings.Cut(key, variantSeparator)
+	return base
+}
+
+// GetVary returns the Vary header list recorded for a base cache key, if any
+func (c *Client) GetVary(ctx context.Context, baseKey string) ([]string, error) {
+	value, err := c.redis.Get(ctx, baseKey+varySuffix).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get vary: %w", err)
+	}
+	if value == "" {
+		return nil, nil
+	}
+	return strings.Split(value, ","), nil
+}
+
+// SetVary records the Vary header list for a base cache key
+func (c *Client) SetVary(ctx context.Context, baseKey string, vary []string, ttl time.Duration) error {
+	if err := c.redis.Set(ctx, baseKey+varySuffix, strings.Join(vary, ","), ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set vary: %w", err)
+	}
+	return nil
+}
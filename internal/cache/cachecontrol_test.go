@@ -0,0 +1,122 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseUpstreamPolicy(t *testing.T) {
+	now := time.Date(2026, 1, 23, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		header      http.Header
+		wantNoStore bool
+		wantHasTTL  bool
+		wantTTL     time.Duration
+		wantVary    []string
+	}{
+		{
+			name:   "no caching headers",
+			header: http.Header{},
+		},
+		{
+			name:        "no-store",
+			header:      http.Header{"Cache-Control": {"no-store"}},
+			wantNoStore: true,
+		},
+		{
+			name:        "private",
+			header:      http.Header{"Cache-Control": {"private, max-age=60"}},
+			wantNoStore: true,
+			wantHasTTL:  true,
+			wantTTL:     60 * time.Second,
+		},
+		{
+			name:       "max-age",
+			header:     http.Header{"Cache-Control": {"public, max-age=5"}},
+			wantHasTTL: true,
+			wantTTL:    5 * time.Second,
+		},
+		{
+			name:       "s-maxage takes precedence over max-age",
+			header:     http.Header{"Cache-Control": {"max-age=5, s-maxage=120"}},
+			wantHasTTL: true,
+			wantTTL:    120 * time.Second,
+		},
+		{
+			name:       "age is subtracted",
+			header:     http.Header{"Cache-Control": {"max-age=60"}, "Age": {"20"}},
+			wantHasTTL: true,
+			wantTTL:    40 * time.Second,
+		},
+		{
+			name: "expires relative to date",
+			header: http.Header{
+				"Date":    {now.Format(http.TimeFormat)},
+				"Expires": {now.Add(10 * time.Minute).Format(http.TimeFormat)},
+			},
+			wantHasTTL: true,
+			wantTTL:    10 * time.Minute,
+		},
+		{
+			name:       "invalid expires means already expired",
+			header:     http.Header{"Expires": {"0"}},
+			wantHasTTL: true,
+			wantTTL:    0,
+		},
+		{
+			name:     "vary is canonicalized and sorted",
+			header:   http.Header{"Vary": {"accept-language, Accept-Encoding"}},
+			wantVary: []string{"Accept-Encoding", "Accept-Language"},
+		},
+		{
+			name:        "vary star is not storable",
+			header:      http.Header{"Vary": {"*"}},
+			wantNoStore: true,
+			wantVary:    []string{"*"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := ParseUpstreamPolicy(tt.header, now)
+			if policy.NoStore != tt.wantNoStore {
+				t.Errorf("NoStore = %v, want %v", policy.NoStore, tt.wantNoStore)
+			}
+			if policy.HasTTL != tt.wantHasTTL {
+				t.Errorf("HasTTL = %v, want %v", policy.HasTTL, tt.wantHasTTL)
+			}
+			if policy.TTL != tt.wantTTL {
+				t.Errorf("TTL = %v, want %v", policy.TTL, tt.wantTTL)
+			}
+			if len(policy.Vary) != len(tt.wantVary) {
+				t.Fatalf("Vary = %v, want %v", policy.Vary, tt.wantVary)
+			}
+			for i := range policy.Vary {
+				if policy.Vary[i] != tt.wantVary[i] {
+					t.Errorf("Vary = %v, want %v", policy.Vary, tt.wantVary)
+				}
+			}
+		})
+	}
+}
+
+func TestVariantKey(t *testing.T) {
+	baseKey := "cache:abc"
+	vary := []string{"Accept-Language"}
+
+	en := VariantKey(baseKey, http.Header{"Accept-Language": {"en"}}, vary)
+	de := VariantKey(baseKey, http.Header{"Accept-Language": {"de"}}, vary)
+
+	if en == de {
+		t.Error("different Vary header values should generate different keys")
+	}
+	if BaseKey(en) != baseKey {
+		t.Errorf("BaseKey(%q) = %q, want %q", en, BaseKey(en), baseKey)
+	}
+	if got := VariantKey(baseKey, http.Header{}, nil); got != baseKey {
+		t.Errorf("VariantKey without Vary = %q, want %q", got, baseKey)
+	}
+}
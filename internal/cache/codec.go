@@ -0,0 +1,213 @@
+package cache
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Binary storage format for CachedResponse:
+//
+//	magic (1 byte) | version (1 byte) | fields... | fieldEnd | raw body
+//
+// Each field is a tag byte, a uvarint payload length, and the payload, so
+// decoders skip tags they do not know. The body follows the terminator as-is,
+// avoiding the base64 inflation of the JSON encoding.
+const (
+	binaryMagic   byte = 0xAC
+	binaryVersion byte = 1
+)
+
+// Field tags for the binary format. Tags must never be reused.
+const (
+	fieldEnd        byte = 0
+	fieldStatusCode byte = 1
+	fieldCachedAt   byte = 2
+	fieldFreshUntil byte = 3
+	fieldExpiresAt  byte = 4
+	fieldEndpointID byte = 5
+	fieldHeader     byte = 6
+	fieldEncoding   byte = 7
+	// fieldGeneratedETag has an empty payload; its presence sets the flag
+	fieldGeneratedETag byte = 8
+)
+
+// Storage formats selectable with cache.storage_format
+const (
+	FormatBinary = "binary"
+	FormatJSON   = "json"
+)
+
+var errTruncated = errors.New("truncated cache entry")
+
+// encodeResponse serializes a response in the given storage format
+func encodeResponse(r *CachedResponse, format string) ([]byte, error) {
+	if format == FormatJSON {
+		return json.Marshal(r)
+	}
+	return encodeBinary(r), nil
+}
+
+// decodeResponse deserializes a response written in either storage format,
+// so entries written before the binary format remain readable
+func decodeResponse(data []byte) (*CachedResponse, error) {
+	if len(data) == 0 {
+		return nil, errTruncated
+	}
+
+	var r CachedResponse
+	switch data[0] {
+	case '{':
+		if err := json.Unmarshal(data, &r); err != nil {
+			return nil, err
+		}
+		return &r, nil
+	case binaryMagic:
+		if err := decodeBinary(data, &r); err != nil {
+			return nil, err
+		}
+		return &r, nil
+	default:
+		return nil, fmt.Errorf("unknown cache entry format (leading byte 0x%02x)", data[0])
+	}
+}
+
+func encodeBinary(r *CachedResponse) []byte {
+	buf := make([]byte, 0, 256+len(r.Body))
+	buf = append(buf, binaryMagic, binaryVersion)
+
+	buf = appendField(buf, fieldStatusCode, binary.AppendUvarint(nil, uint64(r.StatusCode)))
+	buf = appendField(buf, fieldCachedAt, appendTime(nil, r.CachedAt))
+	if !r.FreshUntil.IsZero() {
+		buf = appendField(buf, fieldFreshUntil, appendTime(nil, r.FreshUntil))
+	}
+	if !r.ExpiresAt.IsZero() {
+		buf = appendField(buf, fieldExpiresAt, appendTime(nil, r.ExpiresAt))
+	}
+	if r.EndpointID != "" {
+		buf = appendField(buf, fieldEndpointID, []byte(r.EndpointID))
+	}
+	if r.Encoding != "" {
+		buf = appendField(buf, fieldEncoding, []byte(r.Encoding))
+	}
+	if r.GeneratedETag {
+		buf = appendField(buf, fieldGeneratedETag, nil)
+	}
+	for name, values := range r.Headers {
+		payload := appendString(nil, name)
+		for _, v := range values {
+			payload = appendString(payload, v)
+		}
+		buf = appendField(buf, fieldHeader, payload)
+	}
+
+	buf = append(buf, fieldEnd)
+	return append(buf, r.Body...)
+}
+
+func decodeBinary(data []byte, r *CachedResponse) error {
+	if len(data) < 2 {
+		return errTruncated
+	}
+	if data[1] != binaryVersion {
+		return fmt.Errorf("unsupported cache entry format version %d", data[1])
+	}
+	data = data[2:]
+
+	for {
+		if len(data) == 0 {
+			return errTruncated
+		}
+		tag := data[0]
+		data = data[1:]
+		if tag == fieldEnd {
+			break
+		}
+
+		length, n := binary.Uvarint(data)
+		if n <= 0 || uint64(len(data)-n) < length {
+			return errTruncated
+		}
+		payload := data[n : n+int(length)]
+		data = data[n+int(length):]
+
+		switch tag {
+		case fieldStatusCode:
+			v, n := binary.Uvarint(payload)
+			if n <= 0 {
+				return errTruncated
+			}
+			r.StatusCode = int(v)
+		case fieldCachedAt:
+			r.CachedAt = decodeTime(payload)
+		case fieldFreshUntil:
+			r.FreshUntil = decodeTime(payload)
+		case fieldExpiresAt:
+			r.ExpiresAt = decodeTime(payload)
+		case fieldEndpointID:
+			r.EndpointID = string(payload)
+		case fieldEncoding:
+			r.Encoding = string(payload)
+		case fieldGeneratedETag:
+			r.GeneratedETag = true
+		case fieldHeader:
+			name, rest, ok := readString(payload)
+			if !ok {
+				return errTruncated
+			}
+			if r.Headers == nil {
+				r.Headers = make(map[string][]string)
+			}
+			values := []string{}
+			for len(rest) > 0 {
+				var v string
+				v, rest, ok = readString(rest)
+				if !ok {
+					return errTruncated
+				}
+				values = append(values, v)
+			}
+			r.Headers[name] = values
+		}
+	}
+
+	// Copy the body so the entry does not pin the whole encoded buffer
+	r.Body = append([]byte(nil), data...)
+	return nil
+}
+
+func appendField(buf []byte, tag byte, payload []byte) []byte {
+	buf = append(buf, tag)
+	buf = binary.AppendUvarint(buf, uint64(len(payload)))
+	return append(buf, payload...)
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readString(data []byte) (string, []byte, bool) {
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < length {
+		return "", nil, false
+	}
+	return string(data[n : n+int(length)]), data[n+int(length):], true
+}
+
+func appendTime(buf []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return binary.AppendVarint(buf, 0)
+	}
+	return binary.AppendVarint(buf, t.UnixNano())
+}
+
+func decodeTime(payload []byte) time.Time {
+	v, n := binary.Varint(payload)
+	if n <= 0 || v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v)
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sampleResponse(bodySize int) *CachedResponse {
+	now := time.Now()
+	return &CachedResponse{
+		StatusCode: 200,
+		Headers: map[string][]string{
+			"Content-Type": {"application/json"},
+			"Set-Cookie":   {"a=1", "b=2"},
+		},
+		Body:       []byte(strings.Repeat(`{"symbol":"IBM","price":123.45},`, bodySize/32+1)[:bodySize]),
+		CachedAt:   now,
+		FreshUntil: now.Add(time.Minute),
+		ExpiresAt:  now.Add(time.Hour),
+		EndpointID: "/query",
+		Encoding:   EncodingGzip,
+		// Flags must survive the round trip too
+		GeneratedETag: true,
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	original := sampleResponse(1024)
+
+	data, err := encodeResponse(original, FormatBinary)
+	if err != nil {
+		t.Fatalf("encode failed: %v", err)
+	}
+	decoded, err := decodeResponse(data)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+
+	if decoded.StatusCode != original.StatusCode || decoded.EndpointID != original.EndpointID || decoded.Encoding != original.Encoding || !decoded.GeneratedETag {
+		t.Errorf("metadata mismatch: got %+v", decoded)
+	}
+	if !bytes.Equal(decoded.Body, original.Body) {
+		t.Error("body mismatch")
+	}
+	if !reflect.DeepEqual(decoded.Headers, original.Headers) {
+		t.Errorf("headers = %v, want %v", decoded.Headers, original.Headers)
+	}
+	for name, pair := range map[string][2]time.Time{
+		"CachedAt":   {decoded.CachedAt, original.CachedAt},
+		"FreshUntil": {decoded.FreshUntil, original.FreshUntil},
+		"ExpiresAt":  {decoded.ExpiresAt, original.ExpiresAt},
+	} {
+		if !pair[0].Equal(pair[1]) {
+			t.Errorf("%s = %v, want %v", name, pair[0], pair[1])
+		}
+	}
+}
+
+func TestBinaryZeroTimesAndEmptyBody(t *testing.T) {
+	data, _ := encodeResponse(&CachedResponse{StatusCode: 204}, FormatBinary)
+	decoded, err := decodeResponse(data)
+	if err != nil {
+		t.Fatalf("decode failed: %v", err)
+	}
+	if !decoded.FreshUntil.IsZero() || !decoded.ExpiresAt.IsZero() || len(decoded.Body) != 0 {
+		t.Errorf("unexpected decoded response: %+v", decoded)
+	}
+}
+
+func TestDecodeLegacyJSON(t *testing.T) {
+	original := sampleResponse(64)
+	data, err := json.Marshal(original)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeResponse(data)
+	if err != nil {
+		t.Fatalf("decode of JSON entry failed: %v", err)
+	}
+	if !bytes.Equal(decoded.Body, original.Body) || decoded.StatusCode != original.StatusCode {
+		t.Error("JSON entry did not round trip")
+	}
+}
+
+func TestDecodeRejectsBadInput(t *testing.T) {
+	good, _ := encodeResponse(sampleResponse(16), FormatBinary)
+
+	tests := map[string][]byte{
+		"empty":           {},
+		"unknown format":  {0x01, 0x02},
+		"unknown version": {binaryMagic, 99, fieldEnd},
+		"truncated":       good[:10],
+	}
+	for name, data := range tests {
+		if _, err := decodeResponse(data); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
+
+func TestBinaryIsSmallerThanJSON(t *testing.T) {
+	r := sampleResponse(64 * 1024)
+	jsonData, _ := encodeResponse(r, FormatJSON)
+	binData, _ := encodeResponse(r, FormatBinary)
+
+	if len(binData) >= len(jsonData) {
+		t.Errorf("binary encoding (%d bytes) should be smaller than JSON (%d bytes)", len(binData), len(jsonData))
+	}
+}
+
+func benchmarkEncode(b *testing.B, format string, bodySize int) {
+	r := sampleResponse(bodySize)
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := encodeResponse(r, format); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkDecode(b *testing.B, format string, bodySize int) {
+	data, _ := encodeResponse(sampleResponse(bodySize), format)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := decodeResponse(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncodeJSON_1KB(b *testing.B)     { benchmarkEncode(b, FormatJSON, 1024) }
+func BenchmarkEncodeBinary_1KB(b *testing.B)   { benchmarkEncode(b, FormatBinary, 1024) }
+func BenchmarkEncodeJSON_256KB(b *testing.B)   { benchmarkEncode(b, FormatJSON, 256*1024) }
+func BenchmarkEncodeBinary_256KB(b *testing.B) { benchmarkEncode(b, FormatBinary, 256*1024) }
+func BenchmarkDecodeJSON_1KB(b *testing.B)     { benchmarkDecode(b, FormatJSON, 1024) }
+func BenchmarkDecodeBinary_1KB(b *testing.B)   { benchmarkDecode(b, FormatBinary, 1024) }
+func BenchmarkDecodeJSON_256KB(b *testing.B)   { benchmarkDecode(b, FormatJSON, 256*1024) }
+func BenchmarkDecodeBinary_256KB(b *testing.B) { benchmarkDecode(b, FormatBinary, 256*1024) }
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings used to compress bodies at rest
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// zstdCodecs returns the shared zstd encoder and decoder. Both are safe for
+// concurrent use through EncodeAll and DecodeAll.
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil)
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+// compressBody compresses a body with the given content coding
+func compressBody(body []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(body); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case EncodingZstd:
+		enc, _ := zstdCodecs()
+		return enc.EncodeAll(body, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", encoding)
+	}
+}
+
+// decompressBody reverses compressBody
+func decompressBody(body []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return body, nil
+	case EncodingGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case EncodingZstd:
+		_, dec := zstdCodecs()
+		return dec.DecodeAll(body, nil)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", encoding)
+	}
+}
+
+// DecodedBody returns the response body with any at-rest compression removed
+func (r *CachedResponse) DecodedBody() ([]byte, error) {
+	body, err := decompressBody(r.Body, r.Encoding)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress cached body: %w", err)
+	}
+	return body, nil
+}
+
+// compressForStorage returns a copy of the response with its body compressed
+// per cache.compression, or the response unchanged if compression is disabled,
+// the body is below the size threshold, the upstream already encoded it, or
+// compressing would not make it smaller.
+func (c *Client) compressForStorage(r *CachedResponse) *CachedResponse {
+	cfg := c.config.Get().Cache.Compression
+	if !cfg.Enabled || r.Encoding != "" || len(r.Body) < cfg.MinSize {
+		return r
+	}
+	if ce := http.Header(r.Headers).Get("Content-Encoding"); ce != "" && ce != "identity" {
+		return r
+	}
+
+	compressed, err := compressBody(r.Body, cfg.Algorithm)
+	if err != nil || len(compressed) >= len(r.Body) {
+		return r
+	}
+
+	stored := *r
+	stored.Body = compressed
+	stored.Encoding = cfg.Algorithm
+	return &stored
+}
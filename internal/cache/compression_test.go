@@ -0,0 +1,66 @@
+package cache
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+func TestCompressForStorage(t *testing.T) {
+	body := []byte(strings.Repeat(`{"symbol":"IBM","price":123.45}`, 100))
+
+	for _, algorithm := range []string{EncodingGzip, EncodingZstd} {
+		t.Run(algorithm, func(t *testing.T) {
+			client := &Client{config: config.NewHolder(&config.Config{Cache: config.CacheConfig{
+				Compression: config.CompressionConfig{Enabled: true, Algorithm: algorithm, MinSize: 256},
+			}})}
+
+			original := &CachedResponse{StatusCode: 200, Body: body}
+			stored := client.compressForStorage(original)
+
+			if stored.Encoding != algorithm {
+				t.Fatalf("Encoding = %q, want %q", stored.Encoding, algorithm)
+			}
+			if len(stored.Body) >= len(body) {
+				t.Errorf("compressed body (%d bytes) not smaller than original (%d bytes)", len(stored.Body), len(body))
+			}
+			if original.Encoding != "" || !bytes.Equal(original.Body, body) {
+				t.Error("compressForStorage must not modify the original response")
+			}
+
+			decoded, err := stored.DecodedBody()
+			if err != nil {
+				t.Fatalf("DecodedBody failed: %v", err)
+			}
+			if !bytes.Equal(decoded, body) {
+				t.Error("decoded body does not match original")
+			}
+		})
+	}
+}
+
+func TestCompressForStorageSkips(t *testing.T) {
+	client := &Client{config: config.NewHolder(&config.Config{Cache: config.CacheConfig{
+		Compression: config.CompressionConfig{Enabled: true, Algorithm: EncodingGzip, MinSize: 256},
+	}})}
+	large := []byte(strings.Repeat("a", 1024))
+
+	tests := []struct {
+		name     string
+		response *CachedResponse
+	}{
+		{"below min size", &CachedResponse{Body: []byte("small")}},
+		{"already encoded by upstream", &CachedResponse{Body: large, Headers: map[string][]string{"Content-Encoding": {"br"}}}},
+		{"incompressible", &CachedResponse{Body: bytes.Repeat([]byte{0x9f, 0x12, 0xe3, 0x41}, 4)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if stored := client.compressForStorage(tt.response); stored.Encoding != "" {
+				t.Errorf("expected body to be stored uncompressed, got encoding %q", stored.Encoding)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// GenerateETag returns a strong entity tag derived from a response body, used when
+// the upstream does not provide one
+func GenerateETag(body []byte) string {
+	hash := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(hash[:16]) + `"`
+}
+
+// IsNotModified evaluates a request's If-None-Match and If-Modified-Since
+// preconditions (RFC 9110 §13.2.2) against the validators of the response that
+// would be served. It reports true when the client's copy is current.
+// If-Modified-Since is ignored when If-None-Match is present.
+func IsNotModified(request, response http.Header) bool {
+	if values := request.Values("If-None-Match"); len(values) > 0 {
+		return etagMatches(values, response.Get("Etag"))
+	}
+
+	since, err := http.ParseTime(request.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(response.Get("Last-Modified"))
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// etagMatches reports whether any entity tag in an If-None-Match list matches etag
+// using the weak comparison function
+func etagMatches(values []string, etag string) bool {
+	for _, value := range values {
+		for _, candidate := range strings.Split(value, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" {
+				return true
+			}
+			if etag != "" && strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UpdateHeaders returns a copy of a stored response's headers updated with the
+// fields of a 304 Not Modified response that revalidated it (RFC 9111 §4.3.4).
+// Content-Length is kept from the stored response since the body is unchanged.
+func UpdateHeaders(stored map[string][]string, notModified http.Header) http.Header {
+	updated := http.Header(stored).Clone()
+	if updated == nil {
+		updated = make(http.Header)
+	}
+	for name, values := range notModified {
+		if name == "Content-Length" {
+			continue
+		}
+		updated[name] = append([]string(nil), values...)
+	}
+	return updated
+}
@@ -0,0 +1,86 @@
+package cache
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestGenerateETag(t *testing.T) {
+	a := GenerateETag([]byte("hello"))
+	if a != GenerateETag([]byte("hello")) {
+		t.Error("GenerateETag is not deterministic")
+	}
+	if a == GenerateETag([]byte("world")) {
+		t.Error("different bodies produced the same ETag")
+	}
+	if len(a) < 2 || a[0] != '"' || a[len(a)-1] != '"' {
+		t.Errorf("GenerateETag() = %s, want a quoted strong entity tag", a)
+	}
+}
+
+func TestIsNotModified(t *testing.T) {
+	response := http.Header{
+		"Etag":          {`"abc"`},
+		"Last-Modified": {"Wed, 21 Oct 2015 07:28:00 GMT"},
+	}
+
+	tests := []struct {
+		name    string
+		request http.Header
+		want    bool
+	}{
+		{"no preconditions", http.Header{}, false},
+		{"matching etag", http.Header{"If-None-Match": {`"abc"`}}, true},
+		{"etag in list", http.Header{"If-None-Match": {`"xyz", "abc"`}}, true},
+		{"weak comparison", http.Header{"If-None-Match": {`W/"abc"`}}, true},
+		{"wildcard", http.Header{"If-None-Match": {"*"}}, true},
+		{"different etag", http.Header{"If-None-Match": {`"xyz"`}}, false},
+		{"not modified since", http.Header{"If-Modified-Since": {"Wed, 21 Oct 2015 07:28:00 GMT"}}, true},
+		{"modified since", http.Header{"If-Modified-Since": {"Tue, 20 Oct 2015 07:28:00 GMT"}}, false},
+		{"invalid date", http.Header{"If-Modified-Since": {"yesterday"}}, false},
+		{
+			name: "if-none-match takes precedence",
+			request: http.Header{
+				"If-None-Match":     {`"xyz"`},
+				"If-Modified-Since": {"Wed, 21 Oct 2015 07:28:00 GMT"},
+			},
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsNotModified(tt.request, response); got != tt.want {
+				t.Errorf("IsNotModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpdateHeaders(t *testing.T) {
+	stored := map[string][]string{
+		"Content-Type":   {"application/json"},
+		"Content-Length": {"42"},
+		"Cache-Control":  {"max-age=60"},
+	}
+	notModified := http.Header{
+		"Cache-Control":  {"max-age=300"},
+		"Content-Length": {"0"},
+		"Date":           {"Wed, 21 Oct 2015 07:28:00 GMT"},
+	}
+
+	updated := UpdateHeaders(stored, notModified)
+
+	if got := updated.Get("Cache-Control"); got != "max-age=300" {
+		t.Errorf("Cache-Control = %q, want max-age=300", got)
+	}
+	if got := updated.Get("Content-Length"); got != "42" {
+		t.Errorf("Content-Length = %q, want 42", got)
+	}
+	if got := updated.Get("Date"); got == "" {
+		t.Error("Date not copied from 304 response")
+	}
+	if stored["Cache-Control"][0] != "max-age=60" {
+		t.Error("UpdateHeaders modified the stored headers")
+	}
+}
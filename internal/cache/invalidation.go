@@ -0,0 +1,94 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// invalidationChannel is the Valkey pub/sub channel replicas use to tell each
+// other to drop L1 entries
+const invalidationChannel = "cache:invalidations"
+
+// invalidation is a message asking replicas to drop L1 entries by key or by
+// Valkey-style glob pattern
+type invalidation struct {
+	Origin  string   `json:"origin"`
+	Keys    []string `json:"keys,omitempty"`
+	Pattern string   `json:"pattern,omitempty"`
+}
+
+// subscribeInvalidations starts listening for invalidations published by other replicas
+func (c *Client) subscribeInvalidations() error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate instance id: %w", err)
+	}
+	c.instanceID = hex.EncodeToString(b)
+
+	ctx := context.Background()
+	c.pubsub = c.redis.Subscribe(ctx, invalidationChannel)
+	// Wait for the subscription to be confirmed so no invalidations are missed
+	if _, err := c.pubsub.Receive(ctx); err != nil {
+		c.pubsub.Close()
+		return fmt.Errorf("failed to subscribe to invalidations: %w", err)
+	}
+
+	go func() {
+		for msg := range c.pubsub.Channel() {
+			var inv invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				logger.WithField("error", err).Warn("Ignoring malformed cache invalidation message")
+				continue
+			}
+			if inv.Origin == c.instanceID {
+				continue
+			}
+			c.applyInvalidation(inv)
+			logger.WithFields(map[string]interface{}{
+				"origin":  inv.Origin,
+				"keys":    inv.Keys,
+				"pattern": inv.Pattern,
+			}).Debug("Applied L1 invalidation from peer")
+		}
+	}()
+
+	return nil
+}
+
+// invalidateL1 drops entries from the local L1 cache and broadcasts the
+// invalidation to other replicas. It is a no-op when L1 is disabled.
+func (c *Client) invalidateL1(ctx context.Context, inv invalidation) {
+	if c.l1 == nil {
+		return
+	}
+	c.applyInvalidation(inv)
+
+	inv.Origin = c.instanceID
+	payload, err := json.Marshal(inv)
+	if err != nil {
+		return
+	}
+	if err := c.redis.Publish(ctx, invalidationChannel, payload).Err(); err != nil {
+		logger.WithFields(map[string]interface{}{
+			"error":   err,
+			"keys":    inv.Keys,
+			"pattern": inv.Pattern,
+		}).Error("Failed to publish cache invalidation")
+	}
+}
+
+// applyInvalidation drops matching entries from the local L1 cache
+func (c *Client) applyInvalidation(inv invalidation) {
+	for _, key := range inv.Keys {
+		// Deleting a base key also removes its Vary variants
+		c.l1.remove(key)
+	}
+	if inv.Pattern != "" {
+		c.l1.removeMatching(inv.Pattern)
+	}
+}
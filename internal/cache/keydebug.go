@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"net/http"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+// KeyDescription shows how a request's cache key was derived. Values of query
+// parameters and headers listed in logging.redact_query_params are redacted,
+// so Material may not hash to CacheKey when any were present.
+type KeyDescription struct {
+	CacheKey string `json:"cache_key"`
+	// VariantKey is the Vary variant the response is stored under, if any
+	VariantKey string `json:"variant_key,omitempty"`
+	// Material is the canonical string hashed into CacheKey
+	Material    string         `json:"material"`
+	Upstream    string         `json:"upstream"`
+	EndpointID  string         `json:"endpoint_id,omitempty"`
+	MatchType   string         `json:"match_type"`
+	QueryParams []KeyComponent `json:"query_params,omitempty"`
+	Headers     []KeyComponent `json:"headers,omitempty"`
+}
+
+// KeyComponent is a configured key query parameter or header and the value
+// the request carried for it, if any
+type KeyComponent struct {
+	Name    string `json:"name"`
+	Present bool   `json:"present"`
+	Value   string `json:"value,omitempty"`
+}
+
+// DescribeCacheKey explains the cache key GenerateCacheKey returns for a
+// request resolved to match
+func (c *Client) DescribeCacheKey(r *http.Request, upstream string, match config.EndpointMatch) KeyDescription {
+	cfg := c.config.Get()
+	desc := KeyDescription{
+		CacheKey:  c.GenerateCacheKey(r, upstream, match.Config),
+		Material:  keyMaterial(r, upstream, match.Config, cfg.RedactValue),
+		Upstream:  upstream,
+		MatchType: string(match.MatchType),
+	}
+	if match.Config == nil {
+		return desc
+	}
+
+	desc.EndpointID = match.Config.EndpointIdentifier()
+	query := r.URL.Query()
+	for _, param := range match.Config.CacheKeyQueryParams {
+		desc.QueryParams = append(desc.QueryParams, keyComponent(cfg, param, query.Get(param)))
+	}
+	for _, header := range match.Config.CacheKeyHeaders {
+		desc.Headers = append(desc.Headers, keyComponent(cfg, header, r.Header.Get(header)))
+	}
+	return desc
+}
+
+// keyComponent describes one configured key component. Empty values are left
+// out of the key, so they count as absent.
+func keyComponent(cfg *config.Config, name, value string) KeyComponent {
+	if value == "" {
+		return KeyComponent{Name: name}
+	}
+	return KeyComponent{Name: name, Present: true, Value: cfg.RedactValue(name, value)}
+}
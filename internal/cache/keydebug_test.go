@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+func TestDescribeCacheKey(t *testing.T) {
+	cfg := &config.Config{Logging: config.LoggingConfig{RedactQueryParams: []string{"apikey", "authorization"}}}
+	client := &Client{config: config.NewHolder(cfg)}
+	match := config.EndpointMatch{
+		Config: &config.EndpointCacheConfig{
+			Path:                "/api/quotes",
+			CacheKeyQueryParams: []string{"symbol", "apikey", "interval"},
+			CacheKeyHeaders:     []string{"Authorization"},
+		},
+		MatchType: config.MatchTypeExact,
+	}
+
+	r := httptest.NewRequest("GET", "/api/quotes?symbol=AAPL&apikey=secret&other=1", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	desc := client.DescribeCacheKey(r, "billing", match)
+
+	if desc.CacheKey != client.GenerateCacheKey(r, "billing", match.Config) {
+		t.Errorf("CacheKey = %s, want the key GenerateCacheKey returns", desc.CacheKey)
+	}
+	wantMaterial := "upstream=billing:GET:/api/quotes:apikey=[REDACTED]&symbol=AAPL:Authorization=[REDACTED]"
+	if desc.Material != wantMaterial {
+		t.Errorf("Material = %s, want %s", desc.Material, wantMaterial)
+	}
+	if desc.EndpointID != "/api/quotes" || desc.MatchType != "exact" || desc.Upstream != "billing" {
+		t.Errorf("desc = %+v, want the exact /api/quotes match on billing", desc)
+	}
+
+	wantParams := []KeyComponent{
+		{Name: "symbol", Present: true, Value: "AAPL"},
+		{Name: "apikey", Present: true, Value: "[REDACTED]"},
+		{Name: "interval"},
+	}
+	if len(desc.QueryParams) != len(wantParams) {
+		t.Fatalf("QueryParams = %+v, want %+v", desc.QueryParams, wantParams)
+	}
+	for i, want := range wantParams {
+		if desc.QueryParams[i] != want {
+			t.Errorf("QueryParams[%d] = %+v, want %+v", i, desc.QueryParams[i], want)
+		}
+	}
+	if len(desc.Headers) != 1 || desc.Headers[0] != (KeyComponent{Name: "Authorization", Present: true, Value: "[REDACTED]"}) {
+		t.Errorf("Headers = %+v, want Authorization redacted", desc.Headers)
+	}
+
+	// Without redaction, the material hashes to the key
+	cfg.Logging.RedactQueryParams = nil
+	if desc := client.DescribeCacheKey(r, "billing", match); hashKeyMaterial(desc.Material) != desc.CacheKey {
+		t.Errorf("Material %s does not hash to %s", desc.Material, desc.CacheKey)
+	}
+}
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"container/list"
+	"path"
+	"sync"
+	"time"
+)
+
+// lruCache is an in-process LRU of cached responses bounded by entry count and
+// total body/header bytes. Entries also carry their own expiry.
+type lruCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxBytes   int64
+	bytes      int64
+	ll         *list.List
+	items      map[string]*list.Element
+	// variants lists the Vary variant keys held for each base key, so they
+	// can be dropped with it without scanning every entry
+	variants map[string]map[string]struct{}
+}
+
+type lruEntry struct {
+	key       string
+	value     *CachedResponse
+	size      int64
+	expiresAt time.Time
+}
+
+func newLRU(maxEntries int, maxBytes int64) *lruCache {
+	return &lruCache{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		variants:   make(map[string]map[string]struct{}),
+	}
+}
+
+// get returns the entry for key if present and not expired
+func (l *lruCache) get(key string, now time.Time) (*CachedResponse, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*lruEntry)
+	if !now.Before(entry.expiresAt) {
+		l.removeElement(elem)
+		return nil, false
+	}
+	l.ll.MoveToFront(elem)
+	return entry.value, true
+}
+
+// add stores an entry for ttl, evicting least recently used entries to stay
+// within bounds. Entries larger than the byte bound are not stored.
+func (l *lruCache) add(key string, value *CachedResponse, ttl time.Duration, now time.Time) {
+	size := responseSize(value)
+	if ttl <= 0 || (l.maxBytes > 0 && size > l.maxBytes) {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.items[key]; ok {
+		l.removeElement(elem)
+	}
+
+	entry := &lruEntry{key: key, value: value, size: size, expiresAt: now.Add(ttl)}
+	l.items[key] = l.ll.PushFront(entry)
+	l.bytes += size
+	if base := BaseKey(key); base != key {
+		if l.variants[base] == nil {
+			l.variants[base] = make(map[string]struct{})
+		}
+		l.variants[base][key] = struct{}{}
+	}
+
+	for (l.maxEntries > 0 && l.ll.Len() > l.maxEntries) || (l.maxBytes > 0 && l.bytes > l.maxBytes) {
+		l.removeElement(l.ll.Back())
+	}
+}
+
+// remove drops the entry for key, if any, along with its Vary variants
+func (l *lruCache) remove(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if elem, ok := l.items[key]; ok {
+		l.removeElement(elem)
+	}
+	for variant := range l.variants[key] {
+		l.removeElement(l.items[variant])
+	}
+}
+
+// removeMatching drops every entry whose key matches a Valkey-style glob pattern
+func (l *lruCache) removeMatching(pattern string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, elem := range l.items {
+		if matched, _ := path.Match(pattern, key); matched {
+			l.removeElement(elem)
+		}
+	}
+}
+
+// len returns the number of entries held
+func (l *lruCache) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.ll.Len()
+}
+
+func (l *lruCache) removeElement(elem *list.Element) {
+	entry := elem.Value.(*lruEntry)
+	l.ll.Remove(elem)
+	delete(l.items, entry.key)
+	l.bytes -= entry.size
+	if base := BaseKey(entry.key); base != entry.key {
+		delete(l.variants[base], entry.key)
+		if len(l.variants[base]) == 0 {
+			delete(l.variants, base)
+		}
+	}
+}
+
+// responseSize approximates the memory held by a cached response
+func responseSize(r *CachedResponse) int64 {
+	size := int64(len(r.Body))
+	for k, values := range r.Headers {
+		size += int64(len(k))
+		for _, v := range values {
+			size += int64(len(v))
+		}
+	}
+	return size
+}
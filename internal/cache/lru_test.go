@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	l := newLRU(2, 0)
+	now := time.Now()
+
+	l.add("cache:a", &CachedResponse{Body: []byte("a")}, time.Minute, now)
+	l.add("cache:b", &CachedResponse{Body: []byte("b")}, time.Minute, now)
+
+	// Touch a so b becomes least recently used
+	if _, ok := l.get("cache:a", now); !ok {
+		t.Fatal("expected cache:a to be present")
+	}
+	l.add("cache:c", &CachedResponse{Body: []byte("c")}, time.Minute, now)
+
+	if _, ok := l.get("cache:b", now); ok {
+		t.Error("expected cache:b to be evicted")
+	}
+	if _, ok := l.get("cache:a", now); !ok {
+		t.Error("expected cache:a to survive eviction")
+	}
+	if l.len() != 2 {
+		t.Errorf("len() = %d, want 2", l.len())
+	}
+}
+
+func TestLRUByteBound(t *testing.T) {
+	l := newLRU(0, 10)
+	now := time.Now()
+
+	l.add("cache:a", &CachedResponse{Body: make([]byte, 6)}, time.Minute, now)
+	l.add("cache:b", &CachedResponse{Body: make([]byte, 6)}, time.Minute, now)
+
+	if _, ok := l.get("cache:a", now); ok {
+		t.Error("expected cache:a to be evicted to stay within max bytes")
+	}
+
+	l.add("cache:big", &CachedResponse{Body: make([]byte, 11)}, time.Minute, now)
+	if _, ok := l.get("cache:big", now); ok {
+		t.Error("entries larger than max bytes should not be stored")
+	}
+}
+
+func TestLRUExpiry(t *testing.T) {
+	l := newLRU(10, 0)
+	now := time.Now()
+
+	l.add("cache:a", &CachedResponse{}, time.Second, now)
+	if _, ok := l.get("cache:a", now.Add(500*time.Millisecond)); !ok {
+		t.Error("expected entry before expiry")
+	}
+	if _, ok := l.get("cache:a", now.Add(time.Second)); ok {
+		t.Error("expected entry to expire")
+	}
+	if l.len() != 0 {
+		t.Errorf("expired entry should be removed, len() = %d", l.len())
+	}
+}
+
+func TestLRURemoveMatching(t *testing.T) {
+	l := newLRU(10, 0)
+	now := time.Now()
+
+	l.add("cache:a", &CachedResponse{}, time.Minute, now)
+	l.add("cache:a:v:123", &CachedResponse{}, time.Minute, now)
+	l.add("cache:b", &CachedResponse{}, time.Minute, now)
+
+	l.removeMatching("cache:a*")
+	if l.len() != 1 {
+		t.Errorf("len() = %d, want 1", l.len())
+	}
+	if _, ok := l.get("cache:b", now); !ok {
+		t.Error("expected cache:b to remain")
+	}
+}
+
+func TestLRURemoveDropsVariants(t *testing.T) {
+	l := newLRU(10, 0)
+	now := time.Now()
+
+	l.add("cache:a", &CachedResponse{}, time.Minute, now)
+	l.add("cache:a:v:1", &CachedResponse{}, time.Minute, now)
+	l.add("cache:a:v:2", &CachedResponse{}, time.Minute, now)
+	l.add("cache:ab:v:1", &CachedResponse{}, time.Minute, now)
+
+	l.remove("cache:a")
+	if l.len() != 1 {
+		t.Errorf("len() = %d, want 1", l.len())
+	}
+	if _, ok := l.get("cache:ab:v:1", now); !ok {
+		t.Error("expected the variant of another key to remain")
+	}
+	if len(l.variants) != 1 {
+		t.Errorf("variant index = %v, want only cache:ab", l.variants)
+	}
+
+	// Variants evicted on their own leave the index too
+	l.remove("cache:ab:v:1")
+	if len(l.variants) != 0 {
+		t.Errorf("variant index = %v, want empty", l.variants)
+	}
+}
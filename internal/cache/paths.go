@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// pathPrefix namespaces the index sets listing the entries cached for a
+// request path, which are kept like those for tags
+const pathPrefix = keyPrefix + "path:"
+
+// RequestPath identifies the cached GET responses for a path on an upstream.
+// A Path ending in "*" stands for every path starting with the rest.
+type RequestPath struct {
+	Upstream string
+	Path     string
+}
+
+// indexKey returns the set listing the entries cached for the path
+func (p RequestPath) indexKey() string {
+	return pathPrefix + p.Upstream + ":" + p.Path
+}
+
+// DeletePaths removes every entry cached for any of paths, whatever its query
+// parameters, key headers, or Vary variant, and returns the number deleted.
+// Only entries stored while invalidation was enabled are indexed by path.
+func (c *Client) DeletePaths(ctx context.Context, paths []RequestPath) (int, error) {
+	var sets []string
+	for _, p := range paths {
+		prefix, ok := strings.CutSuffix(p.Path, "*")
+		if !ok {
+			sets = append(sets, p.indexKey())
+			continue
+		}
+		pattern := RequestPath{Upstream: escapeGlob(p.Upstream), Path: escapeGlob(prefix) + "*"}.indexKey()
+		iter := c.redis.Scan(ctx, 0, pattern, 0).Iterator()
+		for iter.Next(ctx) {
+			sets = append(sets, iter.Val())
+		}
+		if err := iter.Err(); err != nil {
+			return 0, fmt.Errorf("failed to scan cached paths: %w", err)
+		}
+	}
+	if len(sets) == 0 {
+		return 0, nil
+	}
+	return c.deleteIndexed(ctx, sets)
+}
+
+// escapeGlob escapes the Valkey glob metacharacters in s
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`\*?[]`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+func TestEscapeGlob(t *testing.T) {
+	if got, want := escapeGlob(`/files/[draft]*?\x`), `/files/\[draft\]\*\?\\x`; got != want {
+		t.Errorf("escapeGlob() = %s, want %s", got, want)
+	}
+}
+
+func TestDeletePaths(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestClient(t, mr, config.CacheConfig{})
+	ctx := context.Background()
+
+	paths := map[string]RequestPath{
+		"cache:user":        {Upstream: "default", Path: "/users/7"},
+		"cache:user-fields": {Upstream: "default", Path: "/users/7"},
+		"cache:order":       {Upstream: "default", Path: "/users/7/orders/1"},
+		"cache:other-order": {Upstream: "default", Path: "/users/8/orders/1"},
+		"cache:billing":     {Upstream: "billing", Path: "/users/7/orders/1"},
+	}
+	for key, path := range paths {
+		if err := c.Set(ctx, key, testResponse("body", ""), time.Hour); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		if err := c.IndexEntry(ctx, key, EntryIndex{Path: &path}, time.Hour); err != nil {
+			t.Fatalf("IndexEntry() error = %v", err)
+		}
+	}
+
+	// An exact path purges every entry for it; a trailing * purges the paths
+	// under the prefix on that upstream only, with glob characters taken
+	// literally
+	deleted, err := c.DeletePaths(ctx, []RequestPath{
+		{Upstream: "default", Path: "/users/7"},
+		{Upstream: "default", Path: "/users/7/orders/*"},
+		{Upstream: "default", Path: "/users/?/orders/*"},
+	})
+	if err != nil || deleted != 3 {
+		t.Fatalf("DeletePaths() = %d, %v, want 3 deleted", deleted, err)
+	}
+	for key := range paths {
+		gone := key == "cache:user" || key == "cache:user-fields" || key == "cache:order"
+		if mr.Exists(key) == gone {
+			t.Errorf("%s exists = %v, want %v", key, !gone, !gone)
+		}
+	}
+
+	if deleted, err := c.DeletePaths(ctx, []RequestPath{{Upstream: "default", Path: "/none/*"}}); err != nil || deleted != 0 {
+		t.Errorf("DeletePaths() with no matches = %d, %v, want 0", deleted, err)
+	}
+}
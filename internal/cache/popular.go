@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// popularKeyPrefix prefixes the sorted sets of request URIs scored by how
+// often they were requested, one per popularBucket, shared by the cache
+// warmers of every replica
+const popularKeyPrefix = "warmer:popular:"
+
+// popularTopKey holds the combined counts while TopRequests reads them
+const popularTopKey = popularKeyPrefix + "top"
+
+// popularBucket is how long each set collects counts. Counting in buckets
+// lets old traffic weigh less and then drop out, so a traffic shift is
+// reflected in TopRequests.
+const popularBucket = time.Hour
+
+// popularBuckets is how many of the latest buckets TopRequests combines; older
+// ones expire
+const popularBuckets = 24
+
+// popularHalfLife is how many buckets it takes for a request to count half as
+// much
+const popularHalfLife = 6
+
+// maxPopular bounds how many URIs each bucket keeps
+const maxPopular = 10000
+
+// popularBucketKey returns the key of the bucket containing t
+func popularBucketKey(t time.Time) string {
+	return popularKeyPrefix + strconv.FormatInt(t.Unix()/int64(popularBucket/time.Second), 10)
+}
+
+// AddRequestCounts adds to the shared request counts, keeping only the most
+// requested URIs
+func (c *Client) AddRequestCounts(ctx context.Context, counts map[string]int) error {
+	return c.addRequestCounts(ctx, counts, time.Now())
+}
+
+func (c *Client) addRequestCounts(ctx context.Context, counts map[string]int, now time.Time) error {
+	if len(counts) == 0 {
+		return nil
+	}
+
+	key := popularBucketKey(now)
+	pipe := c.redis.TxPipeline()
+	for uri, n := range counts {
+		pipe.ZIncrBy(ctx, key, float64(n), uri)
+	}
+	pipe.ZRemRangeByRank(ctx, key, 0, -maxPopular-1)
+	pipe.Expire(ctx, key, popularBuckets*popularBucket)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record request counts: %w", err)
+	}
+	return nil
+}
+
+// TopRequests returns the n most requested URIs across replicas, with recent
+// requests weighing more than old ones
+func (c *Client) TopRequests(ctx context.Context, n int) ([]string, error) {
+	return c.topRequests(ctx, n, time.Now())
+}
+
+func (c *Client) topRequests(ctx context.Context, n int, now time.Time) ([]string, error) {
+	store := &redis.ZStore{
+		Keys:    make([]string, popularBuckets),
+		Weights: make([]float64, popularBuckets),
+	}
+	for i := range popularBuckets {
+		store.Keys[i] = popularBucketKey(now.Add(-time.Duration(i) * popularBucket))
+		store.Weights[i] = math.Exp2(-float64(i) / popularHalfLife)
+	}
+
+	// Replicas share popularTopKey; the transaction keeps their reads apart
+	pipe := c.redis.TxPipeline()
+	pipe.ZUnionStore(ctx, popularTopKey, store)
+	top := pipe.ZRevRange(ctx, popularTopKey, 0, int64(n-1))
+	pipe.Del(ctx, popularTopKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to read request counts: %w", err)
+	}
+	return top.Val(), nil
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+func TestTopRequestsFavoursRecentTraffic(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestClient(t, mr, config.CacheConfig{})
+	ctx := context.Background()
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	top := func(now time.Time) []string {
+		t.Helper()
+		uris, err := c.topRequests(ctx, 2, now)
+		if err != nil {
+			t.Fatalf("topRequests() error = %v", err)
+		}
+		return uris
+	}
+
+	if err := c.addRequestCounts(ctx, map[string]int{"/old": 100, "/rare": 1}, start); err != nil {
+		t.Fatalf("addRequestCounts() error = %v", err)
+	}
+	if got := top(start); !slices.Equal(got, []string{"/old", "/rare"}) {
+		t.Errorf("top requests = %v, want [/old /rare]", got)
+	}
+
+	// Half a day later, recent traffic outweighs the larger old counts
+	later := start.Add(13 * time.Hour)
+	if err := c.addRequestCounts(ctx, map[string]int{"/new": 30}, later); err != nil {
+		t.Fatalf("addRequestCounts() error = %v", err)
+	}
+	if got := top(later); !slices.Equal(got, []string{"/new", "/old"}) {
+		t.Errorf("top requests after traffic shift = %v, want [/new /old]", got)
+	}
+
+	// Buckets past the window are no longer counted, and expire
+	if got := top(start.Add(popularBuckets * popularBucket)); !slices.Equal(got, []string{"/new"}) {
+		t.Errorf("top requests after a day = %v, want [/new]", got)
+	}
+	if ttl := mr.TTL(popularBucketKey(start)); ttl != popularBuckets*popularBucket {
+		t.Errorf("bucket TTL = %v, want %v", ttl, popularBuckets*popularBucket)
+	}
+	if mr.Exists(popularTopKey) {
+		t.Error("combined counts left behind")
+	}
+}
@@ -0,0 +1,63 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// quotaPrefix namespaces shared upstream quota counters
+const quotaPrefix = "quota:"
+
+// quotaScript records one call in every window in KEYS if all are below their
+// limits. ARGV holds the limits followed by the window TTLs in milliseconds.
+// It returns the admission flag followed by the window counts.
+var quotaScript = redis.NewScript(`
+local n = #KEYS
+local result = {1}
+for i = 1, n do
+	local count = tonumber(redis.call("GET", KEYS[i]) or "0")
+	if count >= tonumber(ARGV[i]) then
+		result[1] = 0
+	end
+	result[i + 1] = count
+end
+
+if result[1] == 1 then
+	for i = 1, n do
+		result[i + 1] = redis.call("INCR", KEYS[i])
+		redis.call("PEXPIRE", KEYS[i], ARGV[n + i])
+	end
+end
+return result
+`)
+
+// ReserveQuota records one upstream call in every quota window if all are below
+// their limits. It returns the window counts and whether the call was recorded.
+func (c *Client) ReserveQuota(ctx context.Context, keys []string, limits []int, ttls []time.Duration) ([]int, bool, error) {
+	redisKeys := make([]string, len(keys))
+	args := make([]interface{}, 0, 2*len(keys))
+	for i, key := range keys {
+		redisKeys[i] = quotaPrefix + key
+		args = append(args, limits[i])
+	}
+	for _, ttl := range ttls {
+		args = append(args, ttl.Milliseconds())
+	}
+
+	values, err := quotaScript.Run(ctx, c.redis, redisKeys, args...).Int64Slice()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to reserve upstream quota: %w", err)
+	}
+	if len(values) != len(keys)+1 {
+		return nil, false, fmt.Errorf("failed to reserve upstream quota: unexpected reply length %d", len(values))
+	}
+
+	counts := make([]int, len(keys))
+	for i := range counts {
+		counts[i] = int(values[i+1])
+	}
+	return counts, values[0] == 1, nil
+}
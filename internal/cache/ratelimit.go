@@ -0,0 +1,130 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// rateLimitPrefix namespaces shared rate limit state, keeping it out of reach
+// of cache purges
+const rateLimitPrefix = "ratelimit:"
+
+// maxEmissionInterval stands in for an infinite interval when the rate is zero,
+// so effectively only the burst is admitted
+const maxEmissionInterval = 24 * time.Hour
+
+// gcraScript implements the generic cell rate algorithm. KEYS[1] holds the
+// theoretical arrival time (TAT) in microseconds; ARGV[1] is the emission
+// interval, ARGV[2] the burst tolerance, and ARGV[3] how long an admitted
+// request may wait for its slot, all in microseconds. The server clock is used
+// so replicas with skewed clocks share one timeline.
+// It returns {admitted, remaining, wait, reset} where wait is the delay before
+// an admitted request may proceed, or until a rejected one would be admitted.
+var gcraScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local max_wait = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call("GET", KEYS[1]) or now)
+if tat < now then
+	tat = now
+end
+
+local new_tat = tat + interval
+local wait = new_tat - tolerance - now
+if wait > max_wait then
+	return {0, 0, wait, tat - now}
+end
+if wait < 0 then
+	wait = 0
+end
+
+redis.call("SET", KEYS[1], new_tat, "PX", math.ceil((new_tat - now) / 1000))
+local remaining = math.floor((now + tolerance - new_tat) / interval)
+if remaining < 0 then
+	remaining = 0
+end
+return {1, remaining, wait, new_tat - now}
+`)
+
+// gcraRefundScript hands back one emission interval ARGV[1], in microseconds,
+// of the budget in KEYS[1]. The TAT never moves behind the server clock; a TAT
+// at or before it is the same as no state at all.
+var gcraRefundScript = redis.NewScript(`
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if not tat then
+	return 0
+end
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local new_tat = tat - tonumber(ARGV[1])
+if new_tat <= now then
+	redis.call("DEL", KEYS[1])
+else
+	redis.call("SET", KEYS[1], new_tat, "PX", math.ceil((new_tat - now) / 1000))
+end
+return 1
+`)
+
+// RateLimitResult is the outcome of a shared rate limit check
+type RateLimitResult struct {
+	Allowed bool
+	// Remaining is how many more requests the budget admits right now
+	Remaining int
+	// Wait is how long an admitted request must wait for its slot, or how long
+	// until a rejected request would be admitted
+	Wait time.Duration
+	// Reset is how long until the budget is fully replenished
+	Reset time.Duration
+}
+
+// AllowRequest admits or rejects one request against a token bucket shared by
+// every replica, refilled at rps and holding up to burst requests. A request
+// that would have to wait no longer than maxWait for its slot is admitted with
+// that wait.
+func (c *Client) AllowRequest(ctx context.Context, key string, rps float64, burst int, maxWait time.Duration) (RateLimitResult, error) {
+	redisKey, interval := rateLimitState(key, rps)
+	tolerance := interval * time.Duration(burst)
+
+	values, err := gcraScript.Run(ctx, c.redis, []string{redisKey}, interval.Microseconds(), tolerance.Microseconds(), maxWait.Microseconds()).Int64Slice()
+	if err != nil {
+		return RateLimitResult{}, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+	if len(values) != 4 {
+		return RateLimitResult{}, fmt.Errorf("failed to check rate limit: unexpected reply length %d", len(values))
+	}
+	return RateLimitResult{
+		Allowed:   values[0] == 1,
+		Remaining: int(values[1]),
+		Wait:      time.Duration(values[2]) * time.Microsecond,
+		Reset:     time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
+
+// RefundRequest hands back the slot of a request admitted by AllowRequest that
+// gave up before its wait was over, so a later request can take it
+func (c *Client) RefundRequest(ctx context.Context, key string, rps float64) error {
+	redisKey, interval := rateLimitState(key, rps)
+	if err := gcraRefundScript.Run(ctx, c.redis, []string{redisKey}, interval.Microseconds()).Err(); err != nil {
+		return fmt.Errorf("failed to refund rate limit: %w", err)
+	}
+	return nil
+}
+
+// rateLimitState returns the Valkey key holding a budget's state and the
+// interval at which it admits requests
+func rateLimitState(key string, rps float64) (string, time.Duration) {
+	interval := maxEmissionInterval
+	if rps > 0 {
+		interval = min(max(time.Duration(float64(time.Second)/rps), time.Microsecond), maxEmissionInterval)
+	}
+	hash := sha256.Sum256([]byte(key))
+	return rateLimitPrefix + hex.EncodeToString(hash[:16]), interval
+}
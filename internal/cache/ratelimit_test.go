@@ -0,0 +1,147 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+// advance moves the Valkey clock and expiries forward by d
+func advance(mr *miniredis.Miniredis, now *time.Time, d time.Duration) {
+	*now = now.Add(d)
+	mr.SetTime(*now)
+	mr.FastForward(d)
+}
+
+func TestAllowRequestAdmitsBurstThenRefills(t *testing.T) {
+	mr := miniredis.RunT(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	c := newTestClient(t, mr, config.CacheConfig{})
+	ctx := context.Background()
+
+	const rps, burst = 10, 5
+	allow := func() RateLimitResult {
+		t.Helper()
+		result, err := c.AllowRequest(ctx, "/items#ip=192.0.2.1", rps, burst, 0)
+		if err != nil {
+			t.Fatalf("AllowRequest() error = %v", err)
+		}
+		return result
+	}
+
+	for i := range burst {
+		result := allow()
+		if !result.Allowed || result.Remaining != burst-1-i || result.Wait != 0 {
+			t.Fatalf("request %d = %+v, want admitted with %d remaining", i+1, result, burst-1-i)
+		}
+	}
+	result := allow()
+	if result.Allowed || result.Wait != 100*time.Millisecond {
+		t.Fatalf("request over burst = %+v, want rejected for 100ms", result)
+	}
+	if result.Reset != burst*100*time.Millisecond {
+		t.Errorf("reset = %v, want %v", result.Reset, burst*100*time.Millisecond)
+	}
+
+	// One request's worth of budget returns every 1/rps
+	advance(mr, &now, 100*time.Millisecond)
+	if result := allow(); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("after one interval = %+v, want one admitted", result)
+	}
+	if result := allow(); result.Allowed {
+		t.Errorf("second request after one interval = %+v, want rejected", result)
+	}
+
+	// The state expires once the bucket is full, and the whole burst is back
+	advance(mr, &now, time.Second)
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("rate limit state left after refilling: %v", keys)
+	}
+	for i := range burst {
+		if result := allow(); !result.Allowed {
+			t.Fatalf("request %d after refill = %+v, want admitted", i+1, result)
+		}
+	}
+}
+
+func TestAllowRequestWaitsUpToMaxWait(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := newTestClient(t, mr, config.CacheConfig{})
+	ctx := context.Background()
+
+	waits := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond}
+	for i, want := range waits {
+		result, err := c.AllowRequest(ctx, "/items", 10, 1, 250*time.Millisecond)
+		if err != nil {
+			t.Fatalf("AllowRequest() error = %v", err)
+		}
+		if !result.Allowed || result.Wait != want {
+			t.Errorf("request %d = %+v, want admitted after %v", i+1, result, want)
+		}
+	}
+
+	result, err := c.AllowRequest(ctx, "/items", 10, 1, 250*time.Millisecond)
+	if err != nil {
+		t.Fatalf("AllowRequest() error = %v", err)
+	}
+	if result.Allowed || result.Wait != 300*time.Millisecond {
+		t.Errorf("request beyond max wait = %+v, want rejected for 300ms", result)
+	}
+}
+
+func TestAllowRequestKeysAreIndependent(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestClient(t, mr, config.CacheConfig{})
+	ctx := context.Background()
+
+	if result, _ := c.AllowRequest(ctx, "client-a", 1, 1, 0); !result.Allowed {
+		t.Fatal("first request for client-a rejected")
+	}
+	if result, _ := c.AllowRequest(ctx, "client-b", 1, 1, 0); !result.Allowed {
+		t.Error("client-b shares client-a's budget")
+	}
+}
+
+func TestRefundRequestHandsBackSlot(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := newTestClient(t, mr, config.CacheConfig{})
+	ctx := context.Background()
+
+	for range 3 {
+		if _, err := c.AllowRequest(ctx, "/items", 10, 1, time.Second); err != nil {
+			t.Fatalf("AllowRequest() error = %v", err)
+		}
+	}
+
+	// The last waiter gives up, so the next request takes its 200ms slot
+	if err := c.RefundRequest(ctx, "/items", 10); err != nil {
+		t.Fatalf("RefundRequest() error = %v", err)
+	}
+	result, err := c.AllowRequest(ctx, "/items", 10, 1, time.Second)
+	if err != nil {
+		t.Fatalf("AllowRequest() error = %v", err)
+	}
+	if !result.Allowed || result.Wait != 200*time.Millisecond {
+		t.Errorf("request after refund = %+v, want admitted after 200ms", result)
+	}
+
+	// Refunding past the current time clears the state rather than banking budget
+	for range 5 {
+		if err := c.RefundRequest(ctx, "/items", 10); err != nil {
+			t.Fatalf("RefundRequest() error = %v", err)
+		}
+	}
+	if keys := mr.Keys(); len(keys) != 0 {
+		t.Errorf("rate limit state left after refunds: %v", keys)
+	}
+	if err := c.RefundRequest(ctx, "/unknown", 10); err != nil {
+		t.Errorf("RefundRequest() on missing state error = %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// Entries are indexed in sorted sets of cache keys, scored by when each entry
+// expires in Unix milliseconds. tagPrefix namespaces the sets for a tag.
+const tagPrefix = keyPrefix + "tag:"
+
+// purgePrefix namespaces index sets taken over by a purge in progress
+const purgePrefix = keyPrefix + "purge:"
+
+// isIndexKey reports whether key is an index set rather than a cache entry
+func isIndexKey(key string) bool {
+	return strings.HasPrefix(key, tagPrefix) || strings.HasPrefix(key, pathPrefix) || strings.HasPrefix(key, purgePrefix)
+}
+
+// SurrogateKeyHeader is the upstream response header listing extra tags
+const SurrogateKeyHeader = "Surrogate-Key"
+
+// indexScript adds the cache key ARGV[1] to every index set in KEYS, scored by
+// its expiry ARGV[2] milliseconds from now. Members whose entries have expired
+// are pruned, and each set lives as long as its longest-lived member.
+var indexScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local expires = now + tonumber(ARGV[2])
+for i = 1, #KEYS do
+	redis.call("ZREMRANGEBYSCORE", KEYS[i], "-inf", now)
+	redis.call("ZADD", KEYS[i], expires, ARGV[1])
+	local last = redis.call("ZRANGE", KEYS[i], -1, -1, "WITHSCORES")
+	redis.call("PEXPIRE", KEYS[i], tonumber(last[2]) - now)
+end
+return #KEYS
+`)
+
+// claimIndexScript renames the index set KEYS[1] to KEYS[2] and returns its
+// members. Entries indexed after the rename go to a new set under the old
+// name, which the purge of the renamed set leaves alone.
+var claimIndexScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return {}
+end
+redis.call("RENAME", KEYS[1], KEYS[2])
+return redis.call("ZRANGE", KEYS[2], 0, -1)
+`)
+
+// restoreIndexScript merges the claimed set KEYS[2] back into KEYS[1] after a
+// purge failed, so it can be retried. The set is pruned and its expiry set as
+// in indexScript.
+var restoreIndexScript = redis.NewScript(`
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+redis.call("ZUNIONSTORE", KEYS[1], 2, KEYS[1], KEYS[2], "AGGREGATE", "MAX")
+redis.call("DEL", KEYS[2])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+local last = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+if last[2] then
+	redis.call("PEXPIRE", KEYS[1], tonumber(last[2]) - now)
+end
+return 1
+`)
+
+// SurrogateKeys returns the space-separated tags in a Surrogate-Key header
+func SurrogateKeys(header http.Header) []string {
+	var keys []string
+	for _, v := range header.Values(SurrogateKeyHeader) {
+		for _, key := range strings.Fields(v) {
+			if !slices.Contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// EntryIndex lists what a cache entry can be purged by
+type EntryIndex struct {
+	Tags []string
+	// Path, if set, lets the entry be purged with the rest of its path
+	Path *RequestPath
+}
+
+// IndexEntry records the tags and path of the entry stored under key for
+// ttl, so purging any of them removes it
+func (c *Client) IndexEntry(ctx context.Context, key string, index EntryIndex, ttl time.Duration) error {
+	sets := make([]string, 0, len(index.Tags)+1)
+	for _, tag := range index.Tags {
+		sets = append(sets, tagPrefix+tag)
+	}
+	if index.Path != nil {
+		sets = append(sets, index.Path.indexKey())
+	}
+	if len(sets) == 0 {
+		return nil
+	}
+	if err := indexScript.Run(ctx, c.redis, sets, key, ttl.Milliseconds()).Err(); err != nil {
+		return fmt.Errorf("failed to index cache entry: %w", err)
+	}
+	return nil
+}
+
+// DeleteTags removes every entry carrying any of tags, along with the tags
+// themselves, and returns the number of entries deleted
+func (c *Client) DeleteTags(ctx context.Context, tags []string) (int, error) {
+	sets := make([]string, len(tags))
+	for i, tag := range tags {
+		sets[i] = tagPrefix + tag
+	}
+	return c.deleteIndexed(ctx, sets)
+}
+
+// deleteIndexed removes every entry listed in the index sets, then the sets,
+// and drops the entries from L1 on every replica. Each set is first renamed
+// to a key of its own, so an entry indexed while the purge runs is kept in a
+// new set rather than dropped from the index with the old one. The entries are
+// deleted here rather than in a script, which may only touch the keys it is
+// passed; the multi-key DELs still assume a single Valkey node rather than a
+// cluster. If any entry could not be deleted the sets are merged back so the
+// purge can be retried.
+func (c *Client) deleteIndexed(ctx context.Context, sets []string) (int, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return 0, fmt.Errorf("failed to generate purge token: %w", err)
+	}
+	token := hex.EncodeToString(b)
+
+	pipe := c.redis.Pipeline()
+	claimed := make([]string, len(sets))
+	reads := make([]*redis.Cmd, len(sets))
+	for i, set := range sets {
+		claimed[i] = purgePrefix + token + ":" + strconv.Itoa(i)
+		reads[i] = claimIndexScript.Eval(ctx, pipe, []string{set, claimed[i]})
+	}
+	// Sets claimed before an error are merged back below
+	_, claimErr := pipe.Exec(ctx)
+
+	seen := make(map[string]bool)
+	var keys []string
+	for _, read := range reads {
+		members, err := read.StringSlice()
+		if err != nil {
+			continue
+		}
+		for _, key := range members {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	if claimErr != nil {
+		c.restoreIndexed(ctx, sets, claimed)
+		return 0, fmt.Errorf("failed to read index sets: %w", claimErr)
+	}
+
+	deleted := 0
+	var delErr error
+	for batch := range slices.Chunk(keys, deleteBatchSize) {
+		n, err := c.redis.Del(ctx, batch...).Result()
+		if err != nil {
+			delErr = err
+			continue
+		}
+		deleted += int(n)
+	}
+	if len(keys) > 0 {
+		c.invalidateL1(ctx, invalidation{Keys: keys})
+	}
+	if delErr != nil {
+		c.restoreIndexed(ctx, sets, claimed)
+		return deleted, fmt.Errorf("failed to delete indexed entries: %w", delErr)
+	}
+
+	if err := c.redis.Del(ctx, claimed...).Err(); err != nil {
+		// Left behind, the claimed sets expire with their last entry
+		return deleted, fmt.Errorf("failed to delete index sets: %w", err)
+	}
+	return deleted, nil
+}
+
+// restoreIndexed merges sets claimed by a failed purge back into the index
+func (c *Client) restoreIndexed(ctx context.Context, sets, claimed []string) {
+	pipe := c.redis.Pipeline()
+	for i, set := range sets {
+		restoreIndexScript.Eval(ctx, pipe, []string{set, claimed[i]})
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		logger.WithFields(map[string]interface{}{
+			"error": err,
+			"sets":  len(sets),
+		}).Error("Failed to restore index sets after a failed purge")
+	}
+}
+
+// TaggedKeys returns the keys of the unexpired entries carrying tag
+func (c *Client) TaggedKeys(ctx context.Context, tag string) ([]string, error) {
+	keys, err := c.redis.ZRangeByScore(ctx, tagPrefix+tag, &redis.ZRangeBy{
+		Min: "(" + strconv.FormatInt(time.Now().UnixMilli(), 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tag: %w", err)
+	}
+	return keys, nil
+}
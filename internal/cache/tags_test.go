@@ -0,0 +1,186 @@
+package cache
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+func TestSurrogateKeys(t *testing.T) {
+	header := http.Header{}
+	header.Add(SurrogateKeyHeader, "product:7  products")
+	header.Add(SurrogateKeyHeader, "products category:shoes")
+
+	want := []string{"product:7", "products", "category:shoes"}
+	if got := SurrogateKeys(header); !slices.Equal(got, want) {
+		t.Errorf("SurrogateKeys() = %v, want %v", got, want)
+	}
+	if got := SurrogateKeys(http.Header{}); got != nil {
+		t.Errorf("SurrogateKeys() without header = %v, want nil", got)
+	}
+}
+
+func TestIndexEntryPrunesAndExpires(t *testing.T) {
+	mr := miniredis.RunT(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	mr.SetTime(now)
+	c := newTestClient(t, mr, config.CacheConfig{})
+	ctx := context.Background()
+
+	index := func(key string, ttl time.Duration) {
+		t.Helper()
+		if err := c.IndexEntry(ctx, key, EntryIndex{Tags: []string{"users"}}, ttl); err != nil {
+			t.Fatalf("IndexEntry() error = %v", err)
+		}
+	}
+
+	index("cache:a", time.Minute)
+	if score, _ := mr.ZScore("cache:tag:users", "cache:a"); score != float64(now.Add(time.Minute).UnixMilli()) {
+		t.Errorf("score = %v, want the entry's expiry in milliseconds", score)
+	}
+	if ttl := mr.TTL("cache:tag:users"); ttl != time.Minute {
+		t.Errorf("tag TTL = %v, want %v", ttl, time.Minute)
+	}
+
+	// The set lives as long as its longest-lived member
+	index("cache:b", 2*time.Minute)
+	if ttl := mr.TTL("cache:tag:users"); ttl != 2*time.Minute {
+		t.Errorf("tag TTL = %v, want %v", ttl, 2*time.Minute)
+	}
+
+	// Expired members are pruned as new ones are added
+	advance(mr, &now, 90*time.Second)
+	index("cache:c", 10*time.Second)
+	if members, _ := mr.ZMembers("cache:tag:users"); !slices.Equal(members, []string{"cache:c", "cache:b"}) {
+		t.Errorf("members = %v, want cache:a pruned", members)
+	}
+	if ttl := mr.TTL("cache:tag:users"); ttl != 30*time.Second {
+		t.Errorf("tag TTL = %v, want %v", ttl, 30*time.Second)
+	}
+}
+
+func TestDeleteTags(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestClient(t, mr, l1Config())
+	ctx := context.Background()
+
+	tags := map[string][]string{
+		"cache:a": {"users", "user:1"},
+		"cache:b": {"users"},
+		"cache:c": {"orders"},
+	}
+	for key, entryTags := range tags {
+		if err := c.Set(ctx, key, testResponse("body", ""), time.Hour); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+		if err := c.IndexEntry(ctx, key, EntryIndex{Tags: entryTags}, time.Hour); err != nil {
+			t.Fatalf("IndexEntry() error = %v", err)
+		}
+	}
+	// Fill L1, which the purge must also clear
+	if cached, _ := c.Get(ctx, "cache:a"); cached == nil {
+		t.Fatal("Get() = nil, want the stored entry")
+	}
+	// A listed entry that is already gone is not counted
+	mr.Del("cache:b")
+
+	deleted, err := c.DeleteTags(ctx, []string{"users", "user:1"})
+	if err != nil || deleted != 1 {
+		t.Fatalf("DeleteTags() = %d, %v, want 1 deleted", deleted, err)
+	}
+	if mr.Exists("cache:a") || !mr.Exists("cache:c") {
+		t.Errorf("keys left = %v, want cache:c kept", mr.Keys())
+	}
+	if mr.Exists("cache:tag:users") || mr.Exists("cache:tag:user:1") || !mr.Exists("cache:tag:orders") {
+		t.Errorf("keys left = %v, want only the purged tags removed", mr.Keys())
+	}
+	for _, key := range mr.Keys() {
+		if strings.HasPrefix(key, purgePrefix) {
+			t.Errorf("claimed set %s left after the purge", key)
+		}
+	}
+	if cached, _ := c.Get(ctx, "cache:a"); cached != nil {
+		t.Error("purged entry is still served from L1")
+	}
+
+	if deleted, err := c.DeleteTags(ctx, []string{"unknown"}); err != nil || deleted != 0 {
+		t.Errorf("DeleteTags() of an unknown tag = %d, %v, want 0", deleted, err)
+	}
+}
+
+func TestPurgeKeepsEntriesIndexedMeanwhile(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	c := newTestClient(t, mr, config.CacheConfig{})
+	ctx := context.Background()
+
+	index := func(key string) {
+		t.Helper()
+		if err := c.IndexEntry(ctx, key, EntryIndex{Tags: []string{"users"}}, time.Hour); err != nil {
+			t.Fatalf("IndexEntry() error = %v", err)
+		}
+	}
+	index("cache:a")
+
+	// A purge claims the set, then an entry is indexed before it finishes
+	claimed := purgePrefix + "test"
+	members, err := claimIndexScript.Run(ctx, c.redis, []string{tagPrefix + "users", claimed}).StringSlice()
+	if err != nil || !slices.Equal(members, []string{"cache:a"}) {
+		t.Fatalf("claim = %v, %v, want [cache:a]", members, err)
+	}
+	index("cache:b")
+	if got, _ := mr.ZMembers(tagPrefix + "users"); !slices.Equal(got, []string{"cache:b"}) {
+		t.Errorf("tag members during purge = %v, want [cache:b]", got)
+	}
+	if ttl := mr.TTL(claimed); ttl != time.Hour {
+		t.Errorf("claimed set TTL = %v, want it kept from the tag", ttl)
+	}
+
+	// A failed purge merges the claimed set back
+	if err := restoreIndexScript.Run(ctx, c.redis, []string{tagPrefix + "users", claimed}).Err(); err != nil {
+		t.Fatalf("restore error = %v", err)
+	}
+	if got, _ := mr.ZMembers(tagPrefix + "users"); !slices.Equal(got, []string{"cache:a", "cache:b"}) {
+		t.Errorf("tag members after restore = %v, want both entries", got)
+	}
+	if mr.Exists(claimed) || mr.TTL(tagPrefix+"users") != time.Hour {
+		t.Errorf("keys = %v, want the claimed set merged into the tag with its TTL", mr.Keys())
+	}
+
+	// Claiming a missing set is a no-op
+	if members, err := claimIndexScript.Run(ctx, c.redis, []string{tagPrefix + "unknown", claimed}).StringSlice(); err != nil || len(members) != 0 {
+		t.Errorf("claim of a missing set = %v, %v, want none", members, err)
+	}
+}
+
+func TestDeleteAllStaysInNamespace(t *testing.T) {
+	mr := miniredis.RunT(t)
+	c := newTestClient(t, mr, config.CacheConfig{})
+	ctx := context.Background()
+
+	if err := c.Set(ctx, "cache:a", testResponse("body", ""), time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	index := EntryIndex{Tags: []string{"users"}, Path: &RequestPath{Upstream: "default", Path: "/users"}}
+	if err := c.IndexEntry(ctx, "cache:a", index, time.Hour); err != nil {
+		t.Fatalf("IndexEntry() error = %v", err)
+	}
+	// Another tenant's keys sharing the Valkey instance
+	mr.Set("tag:users", "theirs")
+	mr.Set("path:/users", "theirs")
+
+	deleted, err := c.DeleteAll(ctx)
+	if err != nil || deleted != 3 {
+		t.Errorf("DeleteAll() = %d, %v, want the entry and its two indexes deleted", deleted, err)
+	}
+	if keys := mr.Keys(); !slices.Equal(keys, []string{"path:/users", "tag:users"}) {
+		t.Errorf("keys left = %v, want only the other tenant's", keys)
+	}
+}
@@ -1,11 +1,14 @@
 package config
 
 import (
+	"cmp"
 	"fmt"
+	"net/netip"
 	"net/url"
 	"os"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,6 +23,17 @@ type Config struct {
 	Retry     RetryConfig     `yaml:"retry"`
 	Upstream  UpstreamConfig  `yaml:"upstream"`
 	Logging   LoggingConfig   `yaml:"logging"`
+	Admin     AdminConfig     `yaml:"admin"`
+	Metrics   MetricsConfig   `yaml:"metrics"`
+
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	Health         HealthConfig         `yaml:"health"`
+	Warmer         WarmerConfig         `yaml:"warmer"`
+
+	// Upstreams are additional upstreams that Routes send requests to;
+	// requests matching no route go to Upstream
+	Upstreams []NamedUpstreamConfig `yaml:"upstreams"`
+	Routes    []RouteConfig         `yaml:"routes"`
 }
 
 type ServerConfig struct {
@@ -41,9 +55,91 @@ type ValkeyConfig struct {
 }
 
 type CacheConfig struct {
-	DefaultTTL time.Duration         `yaml:"default_ttl"`
-	MaxTTL     time.Duration         `yaml:"max_ttl"`
-	Endpoints  []EndpointCacheConfig `yaml:"endpoints"`
+	DefaultTTL time.Duration `yaml:"default_ttl"`
+	MaxTTL     time.Duration `yaml:"max_ttl"`
+	// StorageFormat selects how entries are encoded in Valkey: "binary" (default)
+	// or "json". Both formats are always readable.
+	StorageFormat string `yaml:"storage_format"`
+	// RespectUpstreamCacheHeaders derives TTLs from the upstream's Cache-Control
+	// and Expires headers (clamped to MaxTTL), skips responses marked no-store or
+	// private, and caches a separate variant per Vary header value.
+	RespectUpstreamCacheHeaders bool                  `yaml:"respect_upstream_cache_headers"`
+	Coalescing                  CoalescingConfig      `yaml:"coalescing"`
+	L1                          L1CacheConfig         `yaml:"l1"`
+	Compression                 CompressionConfig     `yaml:"compression"`
+	Endpoints                   []EndpointCacheConfig `yaml:"endpoints"`
+
+	// SurrogateKeys also tags entries with the space-separated keys in the
+	// upstream's Surrogate-Key header, which is not passed on to clients
+	SurrogateKeys bool `yaml:"surrogate_keys"`
+	// DebugKeyHeader adds an X-Cache-Key-Debug header to cacheable responses
+	// showing how their cache key was derived
+	DebugKeyHeader bool `yaml:"debug_key_header"`
+	// Invalidation purges cached GET responses when an unsafe request to a
+	// related path succeeds
+	Invalidation InvalidationConfig `yaml:"invalidation"`
+}
+
+// DefaultInvalidationMethods are the methods that invalidate cached responses
+// when none are configured
+var DefaultInvalidationMethods = []string{"POST", "PUT", "PATCH", "DELETE"}
+
+// InvalidationConfig controls write-through purging. A successful (2xx)
+// request with one of Methods purges the cached GET responses for its own
+// path, in every cache key variant, plus whatever the matching rules list.
+type InvalidationConfig struct {
+	Enabled bool     `yaml:"enabled"`
+	Methods []string `yaml:"methods"`
+	// PurgeParent also purges the parent collection of the request path,
+	// e.g. /api/v1/users for /api/v1/users/123
+	PurgeParent bool                     `yaml:"purge_parent"`
+	Rules       []InvalidationRuleConfig `yaml:"rules"`
+}
+
+// InvalidationRuleConfig lists more paths to purge when a request matching
+// one of Path, PathPrefix, or PathRegex succeeds. Purge paths may use the
+// PathRegex capture groups as $1 or ${name}, and a trailing "*" purges every
+// path starting with the rest.
+type InvalidationRuleConfig struct {
+	Path       string `yaml:"path"`
+	PathPrefix string `yaml:"path_prefix"`
+	PathRegex  string `yaml:"path_regex"`
+	// Methods narrows the rule to some of the invalidating methods
+	Methods []string `yaml:"methods"`
+	Purge   []string `yaml:"purge"`
+
+	// Compiled regex pattern (not serialized)
+	compiledRegex *regexp.Regexp `yaml:"-"`
+}
+
+// L1CacheConfig configures the optional in-process LRU consulted before Valkey.
+// Entries never outlive their remaining Valkey TTL; TTL caps it further.
+type L1CacheConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	MaxEntries int           `yaml:"max_entries"`
+	MaxBytes   int64         `yaml:"max_bytes"`
+	TTL        time.Duration `yaml:"ttl"`
+}
+
+// CompressionConfig controls compression of cached bodies at rest. Bodies smaller
+// than MinSize, or already encoded by the upstream, are stored as-is.
+type CompressionConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Algorithm string `yaml:"algorithm"`
+	MinSize   int    `yaml:"min_size"`
+}
+
+// CoalescingConfig controls how concurrent cache misses for the same cache key
+// are collapsed into a single upstream fetch.
+type CoalescingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// WaitTimeout bounds how long a waiting request blocks on another
+	// request's upstream fetch before fetching on its own.
+	WaitTimeout time.Duration `yaml:"wait_timeout"`
+	// Distributed extends coalescing across replicas using a short-lived
+	// Valkey lock key stored next to the cache entry.
+	Distributed bool          `yaml:"distributed"`
+	LockTTL     time.Duration `yaml:"lock_ttl"`
 }
 
 type EndpointCacheConfig struct {
@@ -51,11 +147,21 @@ type EndpointCacheConfig struct {
 	PathRegex             string              `yaml:"path_regex"`
 	Methods               []string            `yaml:"methods"`
 	TTL                   time.Duration       `yaml:"ttl"`
+	StaleWhileRevalidate  time.Duration       `yaml:"stale_while_revalidate"`
+	StaleIfError          time.Duration       `yaml:"stale_if_error"`
 	CacheKeyHeaders       []string            `yaml:"cache_key_headers"`
 	CacheKeyQueryParams   []string            `yaml:"cache_key_query_params"`
 	MatchQueryParams      map[string][]string `yaml:"match_query_params"`
 	MatchQueryParamsRegex map[string][]string `yaml:"match_query_params_regex"`
 
+	// RefreshAhead is the fraction of the TTL after which a hit renews the
+	// entry in the background, so hot entries never expire (0 disables)
+	RefreshAhead float64 `yaml:"refresh_ahead"`
+	// Tags label stored entries so they can be purged together. Each tag is
+	// literal text with optional {path.N}, {path.name}, and {query.name}
+	// placeholders; a tag whose placeholders resolve empty is skipped.
+	Tags []string `yaml:"tags"`
+
 	// Compiled regex pattern (not serialized)
 	compiledRegex           *regexp.Regexp              `yaml:"-"`
 	compiledQueryParamRegex map[string][]*regexp.Regexp `yaml:"-"`
@@ -66,6 +172,96 @@ type RateLimitConfig struct {
 	RequestsPerSecond float64                   `yaml:"requests_per_second"`
 	Burst             int                       `yaml:"burst"`
 	Endpoints         []EndpointRateLimitConfig `yaml:"endpoints"`
+	// KeyBy identifies clients for endpoints without their own key_by.
+	// Empty means one limiter per path shared by all clients.
+	KeyBy []string `yaml:"key_by"`
+	// TrustedProxies lists the addresses or CIDRs whose X-Forwarded-For
+	// header is believed when keying by client IP
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// IdleTimeout is how long an unused limiter is kept (default 10m)
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+	// Distributed shares budgets across replicas through Valkey, falling back
+	// to per-replica limiters while Valkey is unreachable
+	Distributed bool `yaml:"distributed"`
+	// Mode is "reject" (default) to answer 429 as soon as the budget is spent,
+	// or "delay" to hold requests until a token frees up
+	Mode string `yaml:"mode"`
+	// MaxDelay is the longest a request is held in delay mode before it is
+	// rejected (default 1s)
+	MaxDelay time.Duration `yaml:"max_delay"`
+
+	// Parsed trusted proxy prefixes (not serialized)
+	trustedProxies []netip.Prefix `yaml:"-"`
+}
+
+// Rate limit key sources for key_by. Header and query sources take the name
+// after the colon, e.g. "header:X-API-Key" or "query:apikey".
+const (
+	RateLimitKeyIP     = "ip"
+	RateLimitKeyHeader = "header:"
+	RateLimitKeyQuery  = "query:"
+)
+
+// Rate limit modes
+const (
+	RateLimitModeReject = "reject"
+	RateLimitModeDelay  = "delay"
+)
+
+// DefaultRateLimitMaxDelay is how long delay mode holds a request when
+// rate_limit.max_delay is not set
+const DefaultRateLimitMaxDelay = time.Second
+
+// MaxWait returns how long a request over budget may be held before it is
+// rejected; zero in reject mode
+func (r *RateLimitConfig) MaxWait() time.Duration {
+	if r.Mode != RateLimitModeDelay {
+		return 0
+	}
+	if r.MaxDelay > 0 {
+		return r.MaxDelay
+	}
+	return DefaultRateLimitMaxDelay
+}
+
+// DefaultRateLimitIdleTimeout is how long an unused limiter is kept when
+// rate_limit.idle_timeout is not set
+const DefaultRateLimitIdleTimeout = 10 * time.Minute
+
+// LimiterIdleTimeout returns how long an unused limiter is kept
+func (r *RateLimitConfig) LimiterIdleTimeout() time.Duration {
+	if r.IdleTimeout > 0 {
+		return r.IdleTimeout
+	}
+	return DefaultRateLimitIdleTimeout
+}
+
+// IsTrustedProxy reports whether addr is one of the configured trusted proxies
+func (r *RateLimitConfig) IsTrustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range r.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses trusted proxy addresses and CIDRs
+func (r *RateLimitConfig) parseTrustedProxies() error {
+	r.trustedProxies = nil
+	for _, value := range r.TrustedProxies {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(value)
+			if addrErr != nil {
+				return fmt.Errorf("invalid rate_limit trusted proxy %q: %w", value, err)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		r.trustedProxies = append(r.trustedProxies, prefix.Masked())
+	}
+	return nil
 }
 
 type EndpointRateLimitConfig struct {
@@ -73,6 +269,8 @@ type EndpointRateLimitConfig struct {
 	PathRegex         string  `yaml:"path_regex"`
 	RequestsPerSecond float64 `yaml:"requests_per_second"`
 	Burst             int     `yaml:"burst"`
+	// KeyBy overrides rate_limit.key_by for this endpoint
+	KeyBy []string `yaml:"key_by"`
 
 	// Compiled regex pattern (not serialized)
 	compiledRegex *regexp.Regexp `yaml:"-"`
@@ -85,13 +283,309 @@ type RetryConfig struct {
 	MaxBackoff           time.Duration `yaml:"max_backoff"`
 	BackoffMultiplier    float64       `yaml:"backoff_multiplier"`
 	RetryableStatusCodes []int         `yaml:"retryable_status_codes"`
+	// Jitter randomizes backoff so clients retrying the same failure do not
+	// hit the upstream in lockstep: "full" (default), "decorrelated", or "none"
+	Jitter string `yaml:"jitter"`
+	// Budget caps the total time spent on one request across all attempts and
+	// waits; no further retry is started once it would be exceeded (0 = no cap)
+	Budget time.Duration `yaml:"budget"`
+	// MaxRetryAfter is the longest upstream Retry-After (on 429 and 503) that
+	// is waited out before retrying; longer waits return the response as is
+	// (default 10s)
+	MaxRetryAfter time.Duration `yaml:"max_retry_after"`
+	// MaxBodyBytes is the largest request body buffered so it can be replayed
+	// on retry; requests with larger bodies are sent once (default 1 MiB)
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// RetryWithIdempotencyKey also retries non-idempotent methods (POST,
+	// PATCH) when the request carries an Idempotency-Key header
+	RetryWithIdempotencyKey bool `yaml:"retry_with_idempotency_key"`
 }
 
+// Retry jitter strategies
+const (
+	RetryJitterFull         = "full"
+	RetryJitterDecorrelated = "decorrelated"
+	RetryJitterNone         = "none"
+)
+
+// DefaultRetryMaxRetryAfter is the longest upstream Retry-After honored when
+// retry.max_retry_after is not set
+const DefaultRetryMaxRetryAfter = 10 * time.Second
+
+// DefaultRetryMaxBodyBytes is the largest request body buffered for retries
+// when retry.max_body_bytes is not set
+const DefaultRetryMaxBodyBytes = 1 << 20
+
+// BodyBufferLimit returns the largest request body buffered for retries
+func (r *RetryConfig) BodyBufferLimit() int64 {
+	if r.MaxBodyBytes > 0 {
+		return r.MaxBodyBytes
+	}
+	return DefaultRetryMaxBodyBytes
+}
+
+// RetryAfterLimit returns the longest upstream Retry-After to wait out
+func (r *RetryConfig) RetryAfterLimit() time.Duration {
+	if r.MaxRetryAfter > 0 {
+		return r.MaxRetryAfter
+	}
+	return DefaultRetryMaxRetryAfter
+}
+
+// CircuitBreakerConfig stops calling an upstream endpoint that keeps failing.
+// Each cache endpoint config has its own breaker; unmatched paths share one.
+type CircuitBreakerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// FailureRatio is the share of failed calls within a window that opens
+	// the breaker (default 0.5)
+	FailureRatio float64 `yaml:"failure_ratio"`
+	// MinRequests is how many calls a window needs before the ratio is
+	// considered (default 10)
+	MinRequests int `yaml:"min_requests"`
+	// Window is how long calls are counted before the counts reset (default 30s)
+	Window time.Duration `yaml:"window"`
+	// OpenTimeout is how long the breaker stays open before probing (default 30s)
+	OpenTimeout time.Duration `yaml:"open_timeout"`
+	// HalfOpenRequests is how many probe calls are let through while half-open,
+	// all of which must succeed to close the breaker (default 1)
+	HalfOpenRequests int `yaml:"half_open_requests"`
+}
+
+// Circuit breaker defaults for unset fields
+const (
+	DefaultBreakerFailureRatio     = 0.5
+	DefaultBreakerMinRequests      = 10
+	DefaultBreakerWindow           = 30 * time.Second
+	DefaultBreakerOpenTimeout      = 30 * time.Second
+	DefaultBreakerHalfOpenRequests = 1
+)
+
+// WithDefaults returns a copy with unset fields filled in
+func (b CircuitBreakerConfig) WithDefaults() CircuitBreakerConfig {
+	if b.FailureRatio <= 0 {
+		b.FailureRatio = DefaultBreakerFailureRatio
+	}
+	if b.MinRequests <= 0 {
+		b.MinRequests = DefaultBreakerMinRequests
+	}
+	if b.Window <= 0 {
+		b.Window = DefaultBreakerWindow
+	}
+	if b.OpenTimeout <= 0 {
+		b.OpenTimeout = DefaultBreakerOpenTimeout
+	}
+	if b.HalfOpenRequests <= 0 {
+		b.HalfOpenRequests = DefaultBreakerHalfOpenRequests
+	}
+	return b
+}
+
+// UpstreamConfig is the default upstream, which serves requests that match no
+// route. Named upstreams embed it for their connection settings.
 type UpstreamConfig struct {
 	BaseURL         string        `yaml:"base_url"`
 	Timeout         time.Duration `yaml:"timeout"`
 	MaxIdleConns    int           `yaml:"max_idle_conns"`
 	MaxConnsPerHost int           `yaml:"max_conns_per_host"`
+	Quota           QuotaConfig   `yaml:"quota"`
+
+	// Targets are the base URLs of replicas that requests are balanced
+	// across; base_url is the only target when empty
+	Targets []string `yaml:"targets"`
+	// Balance picks the target for each call: "round_robin" (default),
+	// "least_in_flight", or "consistent_hash"
+	Balance string `yaml:"balance"`
+	// HashBy is what consistent_hash hashes: "path" (default, path and
+	// query), "header:<Name>", or "query:<name>"
+	HashBy      string            `yaml:"hash_by"`
+	HealthCheck HealthCheckConfig `yaml:"health_check"`
+	Ejection    EjectionConfig    `yaml:"ejection"`
+}
+
+// DefaultUpstreamName identifies the upstream configured under upstream, which
+// serves requests that match no route
+const DefaultUpstreamName = "default"
+
+// NamedUpstreamConfig is an additional upstream selected by routes
+type NamedUpstreamConfig struct {
+	Name           string `yaml:"name"`
+	UpstreamConfig `yaml:",inline"`
+	// Retry replaces the global retry settings for this upstream when set
+	Retry *RetryConfig `yaml:"retry"`
+}
+
+// Upstream balancing strategies
+const (
+	BalanceRoundRobin     = "round_robin"
+	BalanceLeastInFlight  = "least_in_flight"
+	BalanceConsistentHash = "consistent_hash"
+)
+
+// Sources consistent_hash balancing hashes to pick a target
+const (
+	// HashByPath hashes the request path and query
+	HashByPath = "path"
+	// HashByHeader prefixes the name of a request header to hash
+	HashByHeader = "header:"
+	// HashByQuery prefixes the name of a query parameter to hash
+	HashByQuery = "query:"
+)
+
+// TargetURLs returns the base URLs requests to the upstream are balanced across
+func (u *UpstreamConfig) TargetURLs() []string {
+	if len(u.Targets) > 0 {
+		return u.Targets
+	}
+	return []string{u.BaseURL}
+}
+
+// validateBalancing checks the upstream's targets and balancing settings
+func (u *UpstreamConfig) validateBalancing() error {
+	if u.BaseURL == "" && len(u.Targets) == 0 {
+		return fmt.Errorf("base_url or targets is required")
+	}
+	for _, target := range u.TargetURLs() {
+		parsed, err := url.Parse(target)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return fmt.Errorf("invalid target %q: must be an absolute URL with scheme and host", target)
+		}
+	}
+	switch u.Balance {
+	case "", BalanceRoundRobin, BalanceLeastInFlight, BalanceConsistentHash:
+	default:
+		return fmt.Errorf("unknown balance %q: must be %q, %q, or %q", u.Balance, BalanceRoundRobin, BalanceLeastInFlight, BalanceConsistentHash)
+	}
+	switch {
+	case u.HashBy == "", u.HashBy == HashByPath:
+	case strings.HasPrefix(u.HashBy, HashByHeader) && len(u.HashBy) > len(HashByHeader):
+	case strings.HasPrefix(u.HashBy, HashByQuery) && len(u.HashBy) > len(HashByQuery):
+	default:
+		return fmt.Errorf("unknown hash_by %q (expected path, header:<name>, or query:<name>)", u.HashBy)
+	}
+	if hc := u.HealthCheck; hc.Enabled {
+		if hc.Path == "" {
+			return fmt.Errorf("health_check path is required when enabled")
+		}
+		if hc.Interval < 0 || hc.Timeout < 0 || hc.HealthyThreshold < 0 || hc.UnhealthyThreshold < 0 {
+			return fmt.Errorf("health_check interval, timeout, and thresholds must not be negative")
+		}
+	}
+	if e := u.Ejection; e.Enabled && (e.ConsecutiveFailures < 0 || e.Duration < 0) {
+		return fmt.Errorf("ejection consecutive_failures and duration must not be negative")
+	}
+	return nil
+}
+
+// HealthCheckConfig actively probes each target of an upstream. Targets that
+// fail UnhealthyThreshold probes in a row get no traffic until they pass
+// HealthyThreshold probes in a row.
+type HealthCheckConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Path is requested with GET on each target; any 2xx passes
+	Path string `yaml:"path"`
+	// Interval is the time between probes of a target (default 10s)
+	Interval time.Duration `yaml:"interval"`
+	// Timeout bounds each probe (default 2s)
+	Timeout            time.Duration `yaml:"timeout"`
+	HealthyThreshold   int           `yaml:"healthy_threshold"`
+	UnhealthyThreshold int           `yaml:"unhealthy_threshold"`
+}
+
+// Health check defaults for unset fields
+const (
+	DefaultHealthCheckInterval           = 10 * time.Second
+	DefaultHealthCheckTimeout            = 2 * time.Second
+	DefaultHealthCheckHealthyThreshold   = 2
+	DefaultHealthCheckUnhealthyThreshold = 2
+)
+
+// WithDefaults returns a copy with unset fields filled in
+func (h HealthCheckConfig) WithDefaults() HealthCheckConfig {
+	if h.Interval <= 0 {
+		h.Interval = DefaultHealthCheckInterval
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = DefaultHealthCheckTimeout
+	}
+	if h.HealthyThreshold <= 0 {
+		h.HealthyThreshold = DefaultHealthCheckHealthyThreshold
+	}
+	if h.UnhealthyThreshold <= 0 {
+		h.UnhealthyThreshold = DefaultHealthCheckUnhealthyThreshold
+	}
+	return h
+}
+
+// EjectionConfig takes a target out of rotation after consecutive failed
+// calls (transport errors and 5xx), without waiting for a health probe
+type EjectionConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// ConsecutiveFailures ejects a target (default 5)
+	ConsecutiveFailures int `yaml:"consecutive_failures"`
+	// Duration is how long an ejected target gets no traffic (default 30s)
+	Duration time.Duration `yaml:"duration"`
+}
+
+// Ejection defaults for unset fields
+const (
+	DefaultEjectionConsecutiveFailures = 5
+	DefaultEjectionDuration            = 30 * time.Second
+)
+
+// WithDefaults returns a copy with unset fields filled in
+func (e EjectionConfig) WithDefaults() EjectionConfig {
+	if e.ConsecutiveFailures <= 0 {
+		e.ConsecutiveFailures = DefaultEjectionConsecutiveFailures
+	}
+	if e.Duration <= 0 {
+		e.Duration = DefaultEjectionDuration
+	}
+	return e
+}
+
+// RouteConfig sends requests whose path matches to a named upstream. Path is an
+// exact match, PathPrefix matches the prefix on a segment boundary, and
+// PathRegex matches like the endpoint configs. Routes are tried in order.
+type RouteConfig struct {
+	Path       string `yaml:"path"`
+	PathPrefix string `yaml:"path_prefix"`
+	PathRegex  string `yaml:"path_regex"`
+	Upstream   string `yaml:"upstream"`
+	// StripPrefix is removed from the front of the path before it is sent
+	// upstream, and replaced with RewritePrefix if set
+	StripPrefix   string `yaml:"strip_prefix"`
+	RewritePrefix string `yaml:"rewrite_prefix"`
+
+	// Compiled regex pattern (not serialized)
+	compiledRegex *regexp.Regexp `yaml:"-"`
+}
+
+// QuotaConfig limits calls made to the upstream so it stays within its own
+// per-minute and per-day quotas. Only real upstream calls count; cache hits
+// do not.
+type QuotaConfig struct {
+	Enabled   bool `yaml:"enabled"`
+	PerMinute int  `yaml:"per_minute"`
+	PerDay    int  `yaml:"per_day"`
+	// MaxWait is how long a call may wait for a used-up window to reset
+	// before it fails
+	MaxWait time.Duration `yaml:"max_wait"`
+	// Distributed counts calls across replicas in Valkey
+	Distributed bool `yaml:"distributed"`
+}
+
+// validate checks the quota windows when the quota is enabled
+func (q *QuotaConfig) validate() error {
+	if !q.Enabled {
+		return nil
+	}
+	if q.PerMinute < 0 || q.PerDay < 0 || q.MaxWait < 0 {
+		return fmt.Errorf("per_minute, per_day, and max_wait must not be negative")
+	}
+	if q.PerMinute == 0 && q.PerDay == 0 {
+		return fmt.Errorf("per_minute or per_day is required when enabled")
+	}
+	return nil
 }
 
 type LoggingConfig struct {
@@ -102,12 +596,183 @@ type LoggingConfig struct {
 	RedactQueryParams []string `yaml:"redact_query_params"`
 }
 
+// MetricsConfig configures the Prometheus scrape endpoint. It is served on its
+// own listener so it neither needs admin tokens nor shadows upstream paths on
+// the main listener.
+type MetricsConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Host    string `yaml:"host"`
+	Port    int    `yaml:"port"`
+	Path    string `yaml:"path"`
+}
+
+// ScrapePath returns the metrics endpoint path, defaulting to /metrics
+func (m *MetricsConfig) ScrapePath() string {
+	if m.Path == "" {
+		return "/metrics"
+	}
+	return m.Path
+}
+
+// HealthConfig configures the readiness check. Valkey is always checked; the
+// upstreams are only probed when ProbeUpstreams is set.
+type HealthConfig struct {
+	// Timeout bounds each dependency check (default 2s)
+	Timeout time.Duration `yaml:"timeout"`
+	// ProbeUpstreams makes readiness require every upstream to answer
+	ProbeUpstreams bool `yaml:"probe_upstreams"`
+	// ProbePath is requested from each upstream target (default: the
+	// upstream's health_check path, or /). Any response other than a 5xx
+	// counts as reachable.
+	ProbePath string `yaml:"probe_path"`
+}
+
+// DefaultHealthTimeout bounds dependency checks when health.timeout is unset
+const DefaultHealthTimeout = 2 * time.Second
+
+// CheckTimeout returns the per-dependency check timeout
+func (h *HealthConfig) CheckTimeout() time.Duration {
+	if h.Timeout <= 0 {
+		return DefaultHealthTimeout
+	}
+	return h.Timeout
+}
+
+// UpstreamProbePath returns the path readiness requests from up's targets
+func (h *HealthConfig) UpstreamProbePath(up NamedUpstreamConfig) string {
+	switch {
+	case h.ProbePath != "":
+		return h.ProbePath
+	case up.HealthCheck.Path != "":
+		return up.HealthCheck.Path
+	default:
+		return "/"
+	}
+}
+
+// WarmerConfig fills the cache ahead of client requests, when the proxy starts
+// and then every Interval. Requests are the configured Requests followed by
+// the TopRequests most requested URLs seen by this replica.
+type WarmerConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Interval between warming runs; 0 warms only on startup
+	Interval time.Duration `yaml:"interval"`
+	// Concurrency is how many warming requests run at once (default 2)
+	Concurrency int `yaml:"concurrency"`
+	// RequestsPerSecond paces warming requests; 0 leaves them unpaced
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// TopRequests is how many of the most requested URLs to warm
+	TopRequests int `yaml:"top_requests"`
+	// Headers are sent with every warming request
+	Headers  map[string]string   `yaml:"headers"`
+	Requests []WarmRequestConfig `yaml:"requests"`
+}
+
+// Warmer defaults and limits
+const (
+	DefaultWarmerConcurrency = 2
+	// MaxWarmRequestExpansion bounds how many URLs one templated warm request
+	// may expand to
+	MaxWarmRequestExpansion = 10000
+)
+
+// WorkerCount returns how many warming requests run at once
+func (w *WarmerConfig) WorkerCount() int {
+	if w.Concurrency <= 0 {
+		return DefaultWarmerConcurrency
+	}
+	return w.Concurrency
+}
+
+// WarmRequestConfig is a GET request the warmer issues. Path may include a
+// query string and {name} placeholders, which are replaced by every
+// combination of the values listed in Params.
+type WarmRequestConfig struct {
+	Path    string              `yaml:"path"`
+	Params  map[string][]string `yaml:"params"`
+	Headers map[string]string   `yaml:"headers"`
+}
+
+var warmPlaceholderRegex = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// Expand returns the request URIs the warm request stands for. Placeholder
+// values are escaped for the part of the URL they appear in.
+func (w *WarmRequestConfig) Expand() ([]string, error) {
+	if !strings.HasPrefix(w.Path, "/") {
+		return nil, fmt.Errorf("path %q must start with /", w.Path)
+	}
+
+	var names []string
+	for _, m := range warmPlaceholderRegex.FindAllStringSubmatch(w.Path, -1) {
+		if !slices.Contains(names, m[1]) {
+			names = append(names, m[1])
+		}
+	}
+
+	uris := []string{w.Path}
+	for _, name := range names {
+		values := w.Params[name]
+		if len(values) == 0 {
+			return nil, fmt.Errorf("path %q has no params for {%s}", w.Path, name)
+		}
+		if len(uris)*len(values) > MaxWarmRequestExpansion {
+			return nil, fmt.Errorf("path %q expands to more than %d requests", w.Path, MaxWarmRequestExpansion)
+		}
+
+		placeholder := "{" + name + "}"
+		expanded := make([]string, 0, len(uris)*len(values))
+		for _, uri := range uris {
+			for _, value := range values {
+				expanded = append(expanded, replaceWarmPlaceholder(uri, placeholder, value))
+			}
+		}
+		uris = expanded
+	}
+	return uris, nil
+}
+
+// replaceWarmPlaceholder substitutes value for placeholder in uri, escaping it
+// as a path segment before the query string and as a query value after it
+func replaceWarmPlaceholder(uri, placeholder, value string) string {
+	path, query, hasQuery := strings.Cut(uri, "?")
+	path = strings.ReplaceAll(path, placeholder, url.PathEscape(value))
+	if !hasQuery {
+		return path
+	}
+	return path + "?" + strings.ReplaceAll(query, placeholder, url.QueryEscape(value))
+}
+
+// AdminConfig configures the authenticated admin API, which listens on its own
+// address so it can be kept off the public network.
+type AdminConfig struct {
+	Enabled    bool               `yaml:"enabled"`
+	Host       string             `yaml:"host"`
+	Port       int                `yaml:"port"`
+	PathPrefix string             `yaml:"path_prefix"`
+	Tokens     []AdminTokenConfig `yaml:"tokens"`
+}
+
+// AdminTokenConfig is a bearer token accepted by the admin API. Name identifies
+// the caller in the audit log.
+type AdminTokenConfig struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+}
+
+// BasePath returns the admin API path prefix, defaulting to /admin
+func (a *AdminConfig) BasePath() string {
+	if a.PathPrefix == "" {
+		return "/admin"
+	}
+	return "/" + strings.Trim(a.PathPrefix, "/")
+}
+
 const redactedValue = "[REDACTED]"
 
 // SanitizeQuery returns a query string with sensitive parameter values replaced
 // by [REDACTED]. The list of parameters to redact is configured via
-// logging.redact_query_params. If the list is empty, the raw query is returned
-// unchanged.
+// logging.redact_query_params, and names match case-insensitively. If the list
+// is empty, the raw query is returned unchanged.
 func (c *Config) SanitizeQuery(rawQuery string) string {
 	if len(c.Logging.RedactQueryParams) == 0 || rawQuery == "" {
 		return rawQuery
@@ -118,9 +783,9 @@ func (c *Config) SanitizeQuery(rawQuery string) string {
 		return rawQuery
 	}
 
-	for _, param := range c.Logging.RedactQueryParams {
-		if _, exists := parsed[param]; exists {
-			parsed.Set(param, redactedValue)
+	for name := range parsed {
+		if c.redacts(name) {
+			parsed.Set(name, redactedValue)
 		}
 	}
 
@@ -148,6 +813,27 @@ func (c *Config) SanitizeQuery(rawQuery string) string {
 	return strings.Join(parts, "&")
 }
 
+// RedactValue returns value, or [REDACTED] if name is listed in
+// logging.redact_query_params. Names match case-insensitively, so the list
+// also covers headers such as Authorization.
+func (c *Config) RedactValue(name, value string) string {
+	if c.redacts(name) {
+		return redactedValue
+	}
+	return value
+}
+
+// redacts reports whether name is listed in logging.redact_query_params,
+// ignoring case
+func (c *Config) redacts(name string) bool {
+	for _, param := range c.Logging.RedactQueryParams {
+		if strings.EqualFold(param, name) {
+			return true
+		}
+	}
+	return false
+}
+
 // Load reads and parses the configuration file
 func Load(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
@@ -169,6 +855,10 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("failed to compile regex patterns: %w", err)
 	}
 
+	if err := cfg.RateLimit.parseTrustedProxies(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	return &cfg, nil
 }
 
@@ -182,10 +872,223 @@ func (c *Config) validate() error {
 		return fmt.Errorf("invalid valkey port: %d", c.Valkey.Port)
 	}
 
-	if c.Upstream.BaseURL == "" {
-		return fmt.Errorf("upstream base_url is required")
+	defaultUpstream := c.defaultUpstream()
+	if err := defaultUpstream.validateBalancing(); err != nil {
+		return fmt.Errorf("invalid upstream: %w", err)
 	}
 
+	upstreams := map[string]bool{DefaultUpstreamName: true}
+	for _, up := range c.Upstreams {
+		if up.Name == "" {
+			return fmt.Errorf("upstreams require a name")
+		}
+		if upstreams[up.Name] {
+			return fmt.Errorf("duplicate upstream name %q", up.Name)
+		}
+		upstreams[up.Name] = true
+		if err := up.validateBalancing(); err != nil {
+			return fmt.Errorf("invalid upstream %q: %w", up.Name, err)
+		}
+		if up.Retry != nil {
+			if err := up.Retry.validate(); err != nil {
+				return fmt.Errorf("invalid retry for upstream %q: %w", up.Name, err)
+			}
+		}
+		if err := up.Quota.validate(); err != nil {
+			return fmt.Errorf("invalid quota for upstream %q: %w", up.Name, err)
+		}
+	}
+	for _, route := range c.Routes {
+		matchers := 0
+		for _, m := range []string{route.Path, route.PathPrefix, route.PathRegex} {
+			if m != "" {
+				matchers++
+			}
+		}
+		if matchers != 1 {
+			return fmt.Errorf("route %q requires exactly one of path, path_prefix, or path_regex", route.EndpointIdentifier())
+		}
+		if !upstreams[route.Upstream] {
+			return fmt.Errorf("route %q references unknown upstream %q", route.EndpointIdentifier(), route.Upstream)
+		}
+		if route.RewritePrefix != "" && route.StripPrefix == "" {
+			return fmt.Errorf("route %q rewrite_prefix requires strip_prefix", route.EndpointIdentifier())
+		}
+	}
+
+	for _, rule := range c.Cache.Invalidation.Rules {
+		matchers := 0
+		for _, m := range []string{rule.Path, rule.PathPrefix, rule.PathRegex} {
+			if m != "" {
+				matchers++
+			}
+		}
+		if matchers != 1 {
+			return fmt.Errorf("invalidation rule %q requires exactly one of path, path_prefix, or path_regex", rule.EndpointIdentifier())
+		}
+		if len(rule.Purge) == 0 {
+			return fmt.Errorf("invalidation rule %q requires at least one purge path", rule.EndpointIdentifier())
+		}
+		for _, target := range rule.Purge {
+			if !strings.HasPrefix(target, "/") {
+				return fmt.Errorf("invalidation rule %q purge path %q must start with /", rule.EndpointIdentifier(), target)
+			}
+		}
+	}
+
+	if c.Cache.Coalescing.Enabled {
+		if c.Cache.Coalescing.WaitTimeout <= 0 {
+			return fmt.Errorf("cache coalescing wait_timeout must be positive when coalescing is enabled")
+		}
+		if c.Cache.Coalescing.Distributed && c.Cache.Coalescing.LockTTL <= 0 {
+			return fmt.Errorf("cache coalescing lock_ttl must be positive when distributed coalescing is enabled")
+		}
+	}
+
+	switch c.Cache.StorageFormat {
+	case "", "binary", "json":
+	default:
+		return fmt.Errorf("invalid cache storage_format %q (expected binary or json)", c.Cache.StorageFormat)
+	}
+
+	if c.Cache.Compression.Enabled {
+		if c.Cache.Compression.Algorithm != "gzip" && c.Cache.Compression.Algorithm != "zstd" {
+			return fmt.Errorf("invalid cache compression algorithm %q (expected gzip or zstd)", c.Cache.Compression.Algorithm)
+		}
+		if c.Cache.Compression.MinSize < 0 {
+			return fmt.Errorf("cache compression min_size must not be negative")
+		}
+	}
+
+	if c.Cache.L1.Enabled {
+		if c.Cache.L1.MaxEntries <= 0 && c.Cache.L1.MaxBytes <= 0 {
+			return fmt.Errorf("cache l1 requires max_entries or max_bytes when enabled")
+		}
+		if c.Cache.L1.MaxEntries < 0 || c.Cache.L1.MaxBytes < 0 || c.Cache.L1.TTL < 0 {
+			return fmt.Errorf("cache l1 max_entries, max_bytes, and ttl must not be negative")
+		}
+	}
+
+	if c.Admin.Enabled {
+		if c.Admin.Port <= 0 || c.Admin.Port > 65535 {
+			return fmt.Errorf("invalid admin port: %d", c.Admin.Port)
+		}
+		if c.Admin.Port == c.Server.Port {
+			return fmt.Errorf("admin port must differ from server port")
+		}
+		if len(c.Admin.Tokens) == 0 {
+			return fmt.Errorf("admin API requires at least one token")
+		}
+		for _, t := range c.Admin.Tokens {
+			if t.Name == "" || t.Token == "" {
+				return fmt.Errorf("admin tokens require both name and token")
+			}
+		}
+	}
+
+	if c.Metrics.Enabled || c.Metrics.Port != 0 {
+		if c.Metrics.Port <= 0 || c.Metrics.Port > 65535 {
+			return fmt.Errorf("invalid metrics port: %d", c.Metrics.Port)
+		}
+		if c.Metrics.Port == c.Server.Port || (c.Admin.Enabled && c.Metrics.Port == c.Admin.Port) {
+			return fmt.Errorf("metrics port must differ from server and admin ports")
+		}
+	}
+
+	for _, ep := range c.Cache.Endpoints {
+		if ep.StaleWhileRevalidate < 0 || ep.StaleIfError < 0 {
+			return fmt.Errorf("stale_while_revalidate and stale_if_error must not be negative for endpoint %q", ep.EndpointIdentifier())
+		}
+		if ep.RefreshAhead < 0 || ep.RefreshAhead >= 1 {
+			return fmt.Errorf("refresh_ahead must be at least 0 and below 1 for endpoint %q", ep.EndpointIdentifier())
+		}
+	}
+
+	if err := c.Upstream.Quota.validate(); err != nil {
+		return fmt.Errorf("invalid upstream quota: %w", err)
+	}
+
+	if err := c.Retry.validate(); err != nil {
+		return fmt.Errorf("invalid retry: %w", err)
+	}
+
+	if b := c.CircuitBreaker; b.Enabled {
+		if b.FailureRatio < 0 || b.FailureRatio > 1 {
+			return fmt.Errorf("circuit_breaker failure_ratio must be between 0 and 1")
+		}
+		if b.MinRequests < 0 || b.Window < 0 || b.OpenTimeout < 0 || b.HalfOpenRequests < 0 {
+			return fmt.Errorf("circuit_breaker min_requests, window, open_timeout, and half_open_requests must not be negative")
+		}
+	}
+
+	if c.Health.Timeout < 0 {
+		return fmt.Errorf("health timeout must not be negative")
+	}
+	if p := c.Health.ProbePath; p != "" && !strings.HasPrefix(p, "/") {
+		return fmt.Errorf("health probe_path must start with /")
+	}
+
+	if w := c.Warmer; w.Enabled {
+		if w.Interval < 0 || w.Concurrency < 0 || w.RequestsPerSecond < 0 || w.TopRequests < 0 {
+			return fmt.Errorf("warmer interval, concurrency, requests_per_second, and top_requests must not be negative")
+		}
+		if len(w.Requests) == 0 && w.TopRequests == 0 {
+			return fmt.Errorf("warmer requires requests or top_requests when enabled")
+		}
+		for i := range w.Requests {
+			if _, err := w.Requests[i].Expand(); err != nil {
+				return fmt.Errorf("invalid warmer request: %w", err)
+			}
+		}
+	}
+
+	if c.RateLimit.IdleTimeout < 0 {
+		return fmt.Errorf("rate_limit idle_timeout must not be negative")
+	}
+	switch c.RateLimit.Mode {
+	case "", RateLimitModeReject, RateLimitModeDelay:
+	default:
+		return fmt.Errorf("invalid rate_limit mode %q: must be %q or %q", c.RateLimit.Mode, RateLimitModeReject, RateLimitModeDelay)
+	}
+	if c.RateLimit.MaxDelay < 0 {
+		return fmt.Errorf("rate_limit max_delay must not be negative")
+	}
+	if err := validateRateLimitKeyBy(c.RateLimit.KeyBy); err != nil {
+		return fmt.Errorf("invalid rate_limit key_by: %w", err)
+	}
+	for _, ep := range c.RateLimit.Endpoints {
+		if err := validateRateLimitKeyBy(ep.KeyBy); err != nil {
+			return fmt.Errorf("invalid rate_limit key_by for endpoint %q: %w", ep.EndpointIdentifier(), err)
+		}
+	}
+
+	return nil
+}
+
+// validate checks the retry settings
+func (r *RetryConfig) validate() error {
+	switch r.Jitter {
+	case "", RetryJitterFull, RetryJitterDecorrelated, RetryJitterNone:
+	default:
+		return fmt.Errorf("unknown jitter %q: must be %q, %q, or %q", r.Jitter, RetryJitterFull, RetryJitterDecorrelated, RetryJitterNone)
+	}
+	if r.Budget < 0 || r.MaxRetryAfter < 0 || r.MaxBodyBytes < 0 {
+		return fmt.Errorf("budget, max_retry_after, and max_body_bytes must not be negative")
+	}
+	return nil
+}
+
+// validateRateLimitKeyBy checks that every key_by entry names a known source
+func validateRateLimitKeyBy(keyBy []string) error {
+	for _, source := range keyBy {
+		switch {
+		case source == RateLimitKeyIP:
+		case strings.HasPrefix(source, RateLimitKeyHeader) && len(source) > len(RateLimitKeyHeader):
+		case strings.HasPrefix(source, RateLimitKeyQuery) && len(source) > len(RateLimitKeyQuery):
+		default:
+			return fmt.Errorf("unknown key source %q (expected ip, header:<name>, or query:<name>)", source)
+		}
+	}
 	return nil
 }
 
@@ -201,6 +1104,9 @@ func (c *Config) compileRegexPatterns() error {
 			}
 			ep.compiledRegex = regex
 		}
+		if err := ep.checkTags(); err != nil {
+			return err
+		}
 
 		// Compile query param regex patterns
 		if len(ep.MatchQueryParamsRegex) > 0 {
@@ -217,6 +1123,30 @@ func (c *Config) compileRegexPatterns() error {
 		}
 	}
 
+	// Compile route patterns
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		if route.PathRegex != "" {
+			regex, err := regexp.Compile(route.PathRegex)
+			if err != nil {
+				return fmt.Errorf("invalid route regex pattern %q: %w", route.PathRegex, err)
+			}
+			route.compiledRegex = regex
+		}
+	}
+
+	// Compile invalidation rule patterns
+	for i := range c.Cache.Invalidation.Rules {
+		rule := &c.Cache.Invalidation.Rules[i]
+		if rule.PathRegex != "" {
+			regex, err := regexp.Compile(rule.PathRegex)
+			if err != nil {
+				return fmt.Errorf("invalid invalidation rule regex pattern %q: %w", rule.PathRegex, err)
+			}
+			rule.compiledRegex = regex
+		}
+	}
+
 	// Compile rate limit endpoint patterns
 	for i := range c.RateLimit.Endpoints {
 		ep := &c.RateLimit.Endpoints[i]
@@ -244,6 +1174,89 @@ func (ep *EndpointCacheConfig) EndpointIdentifier() string {
 	return "<unknown>"
 }
 
+// StaleWindow returns how long entries for this endpoint are kept past freshness
+// so they can be served stale.
+func (ep *EndpointCacheConfig) StaleWindow() time.Duration {
+	return max(ep.StaleWhileRevalidate, ep.StaleIfError)
+}
+
+// tagPlaceholderRegex matches the placeholders in a tag template
+var tagPlaceholderRegex = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// checkTags rejects tag templates with unknown placeholders or path
+// placeholders the endpoint's path regex does not capture
+func (ep *EndpointCacheConfig) checkTags() error {
+	for _, tag := range ep.Tags {
+		if tag == "" || strings.ContainsAny(tag, " \t\r\n") {
+			return fmt.Errorf("tags must be non-empty and contain no whitespace for endpoint %q", ep.EndpointIdentifier())
+		}
+		for _, m := range tagPlaceholderRegex.FindAllStringSubmatch(tag, -1) {
+			source, name, _ := strings.Cut(m[1], ".")
+			switch {
+			case name == "" || (source != "path" && source != "query"):
+				return fmt.Errorf("invalid tag placeholder %q for endpoint %q: use {path.N}, {path.name}, or {query.name}", m[0], ep.EndpointIdentifier())
+			case source == "path" && ep.compiledRegex == nil:
+				return fmt.Errorf("tag placeholder %q requires path_regex for endpoint %q", m[0], ep.EndpointIdentifier())
+			case source == "path" && ep.pathGroup(name) < 0:
+				return fmt.Errorf("tag placeholder %q names no capture group of %q", m[0], ep.PathRegex)
+			}
+		}
+	}
+	return nil
+}
+
+// pathGroup returns the index of a path regex capture group given by number or
+// name, or -1 if there is none
+func (ep *EndpointCacheConfig) pathGroup(name string) int {
+	if ep.compiledRegex == nil {
+		return -1
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		if n < 0 || n > ep.compiledRegex.NumSubexp() {
+			return -1
+		}
+		return n
+	}
+	return ep.compiledRegex.SubexpIndex(name)
+}
+
+// ResolveTags returns the tags for an entry stored for a request path and
+// query, in configured order and without duplicates
+func (ep *EndpointCacheConfig) ResolveTags(path string, query url.Values) []string {
+	if len(ep.Tags) == 0 {
+		return nil
+	}
+	var groups []string
+	if ep.compiledRegex != nil {
+		groups = ep.compiledRegex.FindStringSubmatch(path)
+	}
+
+	tags := make([]string, 0, len(ep.Tags))
+	for _, tmpl := range ep.Tags {
+		complete := true
+		tag := tagPlaceholderRegex.ReplaceAllStringFunc(tmpl, func(placeholder string) string {
+			source, name, _ := strings.Cut(placeholder[1:len(placeholder)-1], ".")
+			var value string
+			switch source {
+			case "path":
+				if i := ep.pathGroup(name); i >= 0 && i < len(groups) {
+					value = groups[i]
+				}
+			case "query":
+				value = query.Get(name)
+			}
+			if value == "" {
+				complete = false
+			}
+			return value
+		})
+		if complete && !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
 // MatchType describes how a request path matched this endpoint config.
 type MatchType string
 
@@ -371,6 +1384,27 @@ func (c *Config) GetEndpointCacheConfigMatch(path, method string, queryParams ma
 	return EndpointMatch{Config: nil, MatchType: MatchTypeDefault}
 }
 
+// EndpointIdentifier returns a human-readable string identifying the rate limit
+// endpoint config.
+func (ep *EndpointRateLimitConfig) EndpointIdentifier() string {
+	if ep.Path != "" {
+		return ep.Path
+	}
+	if ep.PathRegex != "" {
+		return "regex:" + ep.PathRegex
+	}
+	return "<unknown>"
+}
+
+// RateLimitKeyBy returns the client key sources for an endpoint, falling back to
+// the global rate_limit.key_by when the endpoint has none
+func (c *Config) RateLimitKeyBy(ep *EndpointRateLimitConfig) []string {
+	if ep != nil && len(ep.KeyBy) > 0 {
+		return ep.KeyBy
+	}
+	return c.RateLimit.KeyBy
+}
+
 // GetEndpointRateLimitConfig returns the rate limit configuration for a specific endpoint
 // Supports both exact path matching and regex patterns
 func (c *Config) GetEndpointRateLimitConfig(path string) *EndpointRateLimitConfig {
@@ -389,3 +1423,175 @@ func (c *Config) GetEndpointRateLimitConfig(path string) *EndpointRateLimitConfi
 	}
 	return nil
 }
+
+// EndpointIdentifier returns a human-readable string identifying the route
+func (route *RouteConfig) EndpointIdentifier() string {
+	switch {
+	case route.Path != "":
+		return route.Path
+	case route.PathPrefix != "":
+		return "prefix:" + route.PathPrefix
+	case route.PathRegex != "":
+		return "regex:" + route.PathRegex
+	}
+	return "<unknown>"
+}
+
+// matches reports whether the route applies to path. Prefixes match whole
+// path segments, so /billing matches /billing and /billing/x but not /billings.
+func (route *RouteConfig) matches(path string) bool {
+	switch {
+	case route.Path != "":
+		return route.Path == path
+	case route.PathPrefix != "":
+		prefix := strings.TrimSuffix(route.PathPrefix, "/")
+		return path == prefix || strings.HasPrefix(path, prefix+"/")
+	case route.compiledRegex != nil:
+		return route.compiledRegex.MatchString(path)
+	}
+	return false
+}
+
+// rewrite returns the path to send upstream for a request path
+func (route *RouteConfig) rewrite(path string) string {
+	if route.StripPrefix == "" || !strings.HasPrefix(path, route.StripPrefix) {
+		return path
+	}
+	path = route.RewritePrefix + strings.TrimPrefix(path, route.StripPrefix)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return path
+}
+
+// EndpointIdentifier returns a human-readable string identifying the rule
+func (rule *InvalidationRuleConfig) EndpointIdentifier() string {
+	switch {
+	case rule.Path != "":
+		return rule.Path
+	case rule.PathPrefix != "":
+		return "prefix:" + rule.PathPrefix
+	case rule.PathRegex != "":
+		return "regex:" + rule.PathRegex
+	}
+	return "<unknown>"
+}
+
+// targets returns the rule's purge paths for a request path, with capture
+// groups substituted, or nil if the rule does not match it
+func (rule *InvalidationRuleConfig) targets(path string) []string {
+	switch {
+	case rule.Path != "":
+		if rule.Path != path {
+			return nil
+		}
+	case rule.PathPrefix != "":
+		prefix := strings.TrimSuffix(rule.PathPrefix, "/")
+		if path != prefix && !strings.HasPrefix(path, prefix+"/") {
+			return nil
+		}
+	case rule.compiledRegex != nil:
+		match := rule.compiledRegex.FindStringSubmatchIndex(path)
+		if match == nil {
+			return nil
+		}
+		targets := make([]string, 0, len(rule.Purge))
+		for _, tmpl := range rule.Purge {
+			targets = append(targets, string(rule.compiledRegex.ExpandString(nil, tmpl, path, match)))
+		}
+		return targets
+	default:
+		return nil
+	}
+	return rule.Purge
+}
+
+// InvalidationTargets returns the paths whose cached GET responses are purged
+// when a request with method to path succeeds, or nil if it invalidates
+// nothing. A path ending in "*" stands for every path starting with the rest.
+func (c *Config) InvalidationTargets(method, path string) []string {
+	inv := c.Cache.Invalidation
+	methods := inv.Methods
+	if len(methods) == 0 {
+		methods = DefaultInvalidationMethods
+	}
+	if !inv.Enabled || !slices.Contains(methods, method) {
+		return nil
+	}
+
+	targets := []string{path}
+	add := func(target string) {
+		if !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	if inv.PurgeParent {
+		trimmed := strings.TrimSuffix(path, "/")
+		if i := strings.LastIndex(trimmed, "/"); i >= 0 {
+			add(cmp.Or(trimmed[:i], "/"))
+		}
+	}
+	for i := range inv.Rules {
+		rule := &inv.Rules[i]
+		if len(rule.Methods) > 0 && !slices.Contains(rule.Methods, method) {
+			continue
+		}
+		for _, target := range rule.targets(path) {
+			add(target)
+		}
+	}
+	return targets
+}
+
+// UpstreamRoute is the upstream selected for a request path
+type UpstreamRoute struct {
+	// Upstream is the selected upstream; the default upstream is reported
+	// with the name "default"
+	Upstream NamedUpstreamConfig
+	// Retry is the retry settings that apply to the upstream
+	Retry RetryConfig
+	// Path is the path to request from the upstream
+	Path string
+	// Route is the matched route, or nil for the default upstream
+	Route *RouteConfig
+}
+
+// ResolveUpstream returns the upstream for a request path: the first matching
+// route's upstream, or the default upstream when no route matches
+func (c *Config) ResolveUpstream(path string) UpstreamRoute {
+	for i := range c.Routes {
+		route := &c.Routes[i]
+		if !route.matches(path) {
+			continue
+		}
+		if up := c.namedUpstream(route.Upstream); up != nil {
+			retry := c.Retry
+			if up.Retry != nil {
+				retry = *up.Retry
+			}
+			return UpstreamRoute{Upstream: *up, Retry: retry, Path: route.rewrite(path), Route: route}
+		}
+		return UpstreamRoute{Upstream: c.defaultUpstream(), Retry: c.Retry, Path: route.rewrite(path), Route: route}
+	}
+	return UpstreamRoute{Upstream: c.defaultUpstream(), Retry: c.Retry, Path: path}
+}
+
+// namedUpstream returns the upstream with the given name, or nil
+func (c *Config) namedUpstream(name string) *NamedUpstreamConfig {
+	for i := range c.Upstreams {
+		if c.Upstreams[i].Name == name {
+			return &c.Upstreams[i]
+		}
+	}
+	return nil
+}
+
+// AllUpstreams returns every configured upstream, the default one first
+func (c *Config) AllUpstreams() []NamedUpstreamConfig {
+	return append([]NamedUpstreamConfig{c.defaultUpstream()}, c.Upstreams...)
+}
+
+// defaultUpstream returns the upstream section as a named upstream
+func (c *Config) defaultUpstream() NamedUpstreamConfig {
+	return NamedUpstreamConfig{Name: DefaultUpstreamName, UpstreamConfig: c.Upstream}
+}
@@ -1,7 +1,10 @@
 package config
 
 import (
+	"net/netip"
+	"net/url"
 	"regexp"
+	"slices"
 	"testing"
 	"time"
 )
@@ -484,6 +487,12 @@ func TestSanitizeQuery(t *testing.T) {
 			rawQuery:          "symbol=IBM&apikey=secret&function=QUOTE",
 			expected:          "symbol=IBM&apikey=%5BREDACTED%5D&function=QUOTE",
 		},
+		{
+			name:              "matches names case-insensitively",
+			redactQueryParams: []string{"apiKey"},
+			rawQuery:          "APIKEY=one&symbol=IBM&apikey=two",
+			expected:          "APIKEY=%5BREDACTED%5D&symbol=IBM&apikey=%5BREDACTED%5D",
+		},
 	}
 
 	for _, tt := range tests {
@@ -501,3 +510,858 @@ func TestSanitizeQuery(t *testing.T) {
 		})
 	}
 }
+
+func TestRedactionIgnoresCase(t *testing.T) {
+	cfg := &Config{Logging: LoggingConfig{RedactQueryParams: []string{"ApiKey"}}}
+	for _, name := range []string{"apikey", "APIKEY", "apiKey"} {
+		if got := cfg.RedactValue(name, "secret"); got != redactedValue {
+			t.Errorf("RedactValue(%q) = %q, want it redacted", name, got)
+		}
+		if got := cfg.SanitizeQuery(name + "=secret"); got != name+"=%5BREDACTED%5D" {
+			t.Errorf("SanitizeQuery(%q) = %q, want it redacted", name+"=secret", got)
+		}
+	}
+	if got := cfg.RedactValue("symbol", "IBM"); got != "IBM" {
+		t.Errorf("RedactValue(symbol) = %q, want it unchanged", got)
+	}
+}
+
+func TestValidate_Coalescing(t *testing.T) {
+	tests := []struct {
+		name       string
+		coalescing CoalescingConfig
+		wantErr    bool
+	}{
+		{
+			name:       "disabled needs no settings",
+			coalescing: CoalescingConfig{},
+		},
+		{
+			name:       "enabled with wait timeout",
+			coalescing: CoalescingConfig{Enabled: true, WaitTimeout: 5 * time.Second},
+		},
+		{
+			name:       "enabled without wait timeout",
+			coalescing: CoalescingConfig{Enabled: true},
+			wantErr:    true,
+		},
+		{
+			name:       "distributed without lock ttl",
+			coalescing: CoalescingConfig{Enabled: true, WaitTimeout: 5 * time.Second, Distributed: true},
+			wantErr:    true,
+		},
+		{
+			name:       "distributed with lock ttl",
+			coalescing: CoalescingConfig{Enabled: true, WaitTimeout: 5 * time.Second, Distributed: true, LockTTL: 10 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Cache.Coalescing = tt.coalescing
+
+			err := cfg.validate()
+			if tt.wantErr && err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+// validTestConfig returns the smallest configuration that passes validate
+func validTestConfig() *Config {
+	return &Config{
+		Server:   ServerConfig{Port: 8080},
+		Valkey:   ValkeyConfig{Port: 6379},
+		Upstream: UpstreamConfig{BaseURL: "http://localhost:9000"},
+	}
+}
+
+func TestValidate_StaleWindows(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.Cache.Endpoints = []EndpointCacheConfig{
+		{Path: "/api/v1/products", Methods: []string{"GET"}, StaleWhileRevalidate: time.Minute, StaleIfError: time.Hour},
+	}
+	if err := cfg.validate(); err != nil {
+		t.Fatalf("Unexpected validation error: %v", err)
+	}
+	if got := cfg.Cache.Endpoints[0].StaleWindow(); got != time.Hour {
+		t.Errorf("StaleWindow() = %v, want %v", got, time.Hour)
+	}
+
+	cfg.Cache.Endpoints[0].StaleIfError = -time.Second
+	if err := cfg.validate(); err == nil {
+		t.Error("Expected error for negative stale_if_error, got nil")
+	}
+}
+
+func TestValidate_RefreshAhead(t *testing.T) {
+	for _, tt := range []struct {
+		refreshAhead float64
+		wantErr      bool
+	}{
+		{0, false},
+		{0.8, false},
+		{1, true},
+		{-0.1, true},
+	} {
+		cfg := validTestConfig()
+		cfg.Cache.Endpoints = []EndpointCacheConfig{{Path: "/api/v1/quotes", RefreshAhead: tt.refreshAhead}}
+		if err := cfg.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("refresh_ahead %v: validate() error = %v, wantErr %v", tt.refreshAhead, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckTags(t *testing.T) {
+	tests := []struct {
+		name    string
+		ep      EndpointCacheConfig
+		wantErr bool
+	}{
+		{name: "static", ep: EndpointCacheConfig{Path: "/products", Tags: []string{"products"}}},
+		{name: "query", ep: EndpointCacheConfig{Path: "/products", Tags: []string{"category:{query.category}"}}},
+		{name: "numbered group", ep: EndpointCacheConfig{PathRegex: `^/users/(\d+)`, Tags: []string{"user:{path.1}"}}},
+		{name: "named group", ep: EndpointCacheConfig{PathRegex: `^/users/(?P<id>\d+)`, Tags: []string{"user:{path.id}"}}},
+		{name: "path without regex", ep: EndpointCacheConfig{Path: "/users/1", Tags: []string{"user:{path.1}"}}, wantErr: true},
+		{name: "missing group", ep: EndpointCacheConfig{PathRegex: `^/users/(\d+)`, Tags: []string{"user:{path.2}"}}, wantErr: true},
+		{name: "unknown group name", ep: EndpointCacheConfig{PathRegex: `^/users/(?P<id>\d+)`, Tags: []string{"user:{path.user}"}}, wantErr: true},
+		{name: "unknown source", ep: EndpointCacheConfig{Path: "/users", Tags: []string{"{header.X-User}"}}, wantErr: true},
+		{name: "whitespace", ep: EndpointCacheConfig{Path: "/users", Tags: []string{"all users"}}, wantErr: true},
+		{name: "empty", ep: EndpointCacheConfig{Path: "/users", Tags: []string{""}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Cache.Endpoints = []EndpointCacheConfig{tt.ep}
+			err := cfg.compileRegexPatterns()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("compileRegexPatterns() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveTags(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.Cache.Endpoints = []EndpointCacheConfig{{
+		PathRegex: `^/users/(?P<id>\d+)/orders/(\d+)$`,
+		Tags:      []string{"orders", "user:{path.id}", "order:{path.2}", "status:{query.status}", "user:{path.1}"},
+	}}
+	if err := cfg.compileRegexPatterns(); err != nil {
+		t.Fatalf("compileRegexPatterns() error = %v", err)
+	}
+	ep := &cfg.Cache.Endpoints[0]
+
+	got := ep.ResolveTags("/users/42/orders/7", url.Values{"status": {"open"}})
+	want := []string{"orders", "user:42", "order:7", "status:open"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ResolveTags() = %v, want %v", got, want)
+	}
+
+	// Tags whose placeholders have no value are left out
+	got = ep.ResolveTags("/users/42/orders/7", url.Values{})
+	want = []string{"orders", "user:42", "order:7"}
+	if !slices.Equal(got, want) {
+		t.Errorf("ResolveTags() without query = %v, want %v", got, want)
+	}
+
+	if got := (&EndpointCacheConfig{Path: "/users"}).ResolveTags("/users", nil); got != nil {
+		t.Errorf("ResolveTags() without tags = %v, want nil", got)
+	}
+}
+
+func TestValidate_Invalidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    InvalidationRuleConfig
+		wantErr bool
+	}{
+		{name: "path", rule: InvalidationRuleConfig{Path: "/users", Purge: []string{"/search"}}},
+		{name: "regex", rule: InvalidationRuleConfig{PathRegex: `^/users/(\d+)`, Purge: []string{"/users/$1/*"}}},
+		{name: "no matcher", rule: InvalidationRuleConfig{Purge: []string{"/search"}}, wantErr: true},
+		{name: "two matchers", rule: InvalidationRuleConfig{Path: "/users", PathPrefix: "/users", Purge: []string{"/search"}}, wantErr: true},
+		{name: "nothing to purge", rule: InvalidationRuleConfig{Path: "/users"}, wantErr: true},
+		{name: "relative purge path", rule: InvalidationRuleConfig{Path: "/users", Purge: []string{"search"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Cache.Invalidation = InvalidationConfig{Enabled: true, Rules: []InvalidationRuleConfig{tt.rule}}
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInvalidationTargets(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.Cache.Invalidation = InvalidationConfig{
+		Enabled:     true,
+		PurgeParent: true,
+		Rules: []InvalidationRuleConfig{
+			{PathRegex: `^/users/(?P<id>\d+)/orders/\d+$`, Purge: []string{"/users/${id}", "/users/$id/orders/*"}},
+			{PathPrefix: "/users", Methods: []string{"DELETE"}, Purge: []string{"/search"}},
+			{Path: "/other", Purge: []string{"/never"}},
+		},
+	}
+	if err := cfg.compileRegexPatterns(); err != nil {
+		t.Fatalf("compileRegexPatterns() error = %v", err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		want   []string
+	}{
+		{"GET", "/users/42", nil},
+		{"PUT", "/users/42", []string{"/users/42", "/users"}},
+		{"DELETE", "/users/42/", []string{"/users/42/", "/users", "/search"}},
+		{"POST", "/users", []string{"/users", "/"}},
+		{"PATCH", "/users/42/orders/7", []string{"/users/42/orders/7", "/users/42/orders", "/users/42", "/users/42/orders/*"}},
+	}
+	for _, tt := range tests {
+		if got := cfg.InvalidationTargets(tt.method, tt.path); !slices.Equal(got, tt.want) {
+			t.Errorf("InvalidationTargets(%s %s) = %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	cfg.Cache.Invalidation.Methods = []string{"DELETE"}
+	if got := cfg.InvalidationTargets("PUT", "/users/42"); got != nil {
+		t.Errorf("InvalidationTargets(PUT) = %v with only DELETE configured, want nil", got)
+	}
+	cfg.Cache.Invalidation.Enabled = false
+	if got := cfg.InvalidationTargets("DELETE", "/users/42"); got != nil {
+		t.Errorf("InvalidationTargets() = %v while disabled, want nil", got)
+	}
+}
+
+func TestValidate_Compression(t *testing.T) {
+	tests := []struct {
+		name        string
+		compression CompressionConfig
+		wantErr     bool
+	}{
+		{name: "disabled", compression: CompressionConfig{}},
+		{name: "gzip", compression: CompressionConfig{Enabled: true, Algorithm: "gzip", MinSize: 1024}},
+		{name: "zstd", compression: CompressionConfig{Enabled: true, Algorithm: "zstd"}},
+		{name: "unknown algorithm", compression: CompressionConfig{Enabled: true, Algorithm: "brotli"}, wantErr: true},
+		{name: "negative min size", compression: CompressionConfig{Enabled: true, Algorithm: "gzip", MinSize: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Cache.Compression = tt.compression
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Admin(t *testing.T) {
+	tests := []struct {
+		name    string
+		admin   AdminConfig
+		wantErr bool
+	}{
+		{
+			name:  "disabled",
+			admin: AdminConfig{},
+		},
+		{
+			name:  "valid",
+			admin: AdminConfig{Enabled: true, Port: 8081, Tokens: []AdminTokenConfig{{Name: "ops", Token: "secret"}}},
+		},
+		{
+			name:    "same port as server",
+			admin:   AdminConfig{Enabled: true, Port: 8080, Tokens: []AdminTokenConfig{{Name: "ops", Token: "secret"}}},
+			wantErr: true,
+		},
+		{
+			name:    "no tokens",
+			admin:   AdminConfig{Enabled: true, Port: 8081},
+			wantErr: true,
+		},
+		{
+			name:    "token without name",
+			admin:   AdminConfig{Enabled: true, Port: 8081, Tokens: []AdminTokenConfig{{Token: "secret"}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Admin = tt.admin
+
+			err := cfg.validate()
+			if tt.wantErr && err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidate_Metrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		metrics MetricsConfig
+		wantErr bool
+	}{
+		{
+			name:    "disabled",
+			metrics: MetricsConfig{},
+		},
+		{
+			name:    "valid",
+			metrics: MetricsConfig{Enabled: true, Port: 9091},
+		},
+		{
+			name:    "listener for a later reload",
+			metrics: MetricsConfig{Port: 9091},
+		},
+		{
+			name:    "enabled without port",
+			metrics: MetricsConfig{Enabled: true},
+			wantErr: true,
+		},
+		{
+			name:    "same port as server",
+			metrics: MetricsConfig{Enabled: true, Port: 8080},
+			wantErr: true,
+		},
+		{
+			name:    "same port as admin",
+			metrics: MetricsConfig{Enabled: true, Port: 8081},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Admin = AdminConfig{Enabled: true, Port: 8081, Tokens: []AdminTokenConfig{{Name: "ops", Token: "secret"}}}
+			cfg.Metrics = tt.metrics
+
+			err := cfg.validate()
+			if tt.wantErr && err == nil {
+				t.Error("Expected validation error, got nil")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("Unexpected validation error: %v", err)
+			}
+		})
+	}
+}
+
+func TestAdminBasePath(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   string
+	}{
+		{"", "/admin"},
+		{"/ops", "/ops"},
+		{"ops/", "/ops"},
+	}
+
+	for _, tt := range tests {
+		a := AdminConfig{PathPrefix: tt.prefix}
+		if got := a.BasePath(); got != tt.want {
+			t.Errorf("BasePath() with prefix %q = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate_RateLimitKeyBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyBy   []string
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "ip", keyBy: []string{"ip"}},
+		{name: "combination", keyBy: []string{"ip", "header:X-API-Key", "query:apikey"}},
+		{name: "unknown source", keyBy: []string{"cookie:session"}, wantErr: true},
+		{name: "header without name", keyBy: []string{"header:"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.RateLimit.Endpoints = []EndpointRateLimitConfig{{Path: "/api", KeyBy: tt.keyBy}}
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRateLimitKeyBy(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.RateLimit.KeyBy = []string{"ip"}
+	ep := &EndpointRateLimitConfig{Path: "/api", KeyBy: []string{"header:X-API-Key"}}
+
+	if got := cfg.RateLimitKeyBy(ep); len(got) != 1 || got[0] != "header:X-API-Key" {
+		t.Errorf("RateLimitKeyBy(endpoint) = %v, want endpoint key_by", got)
+	}
+	if got := cfg.RateLimitKeyBy(&EndpointRateLimitConfig{Path: "/other"}); len(got) != 1 || got[0] != "ip" {
+		t.Errorf("RateLimitKeyBy(endpoint without key_by) = %v, want global key_by", got)
+	}
+	if got := cfg.RateLimitKeyBy(nil); len(got) != 1 || got[0] != "ip" {
+		t.Errorf("RateLimitKeyBy(nil) = %v, want global key_by", got)
+	}
+}
+
+func TestValidate_CircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name    string
+		breaker CircuitBreakerConfig
+		wantErr bool
+	}{
+		{name: "disabled with bad values", breaker: CircuitBreakerConfig{FailureRatio: 2}},
+		{name: "defaults", breaker: CircuitBreakerConfig{Enabled: true}},
+		{name: "configured", breaker: CircuitBreakerConfig{Enabled: true, FailureRatio: 0.25, MinRequests: 5, Window: time.Minute, OpenTimeout: 10 * time.Second, HalfOpenRequests: 3}},
+		{name: "ratio above one", breaker: CircuitBreakerConfig{Enabled: true, FailureRatio: 1.5}, wantErr: true},
+		{name: "negative window", breaker: CircuitBreakerConfig{Enabled: true, Window: -time.Second}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.CircuitBreaker = tt.breaker
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerWithDefaults(t *testing.T) {
+	got := CircuitBreakerConfig{Enabled: true, MinRequests: 4}.WithDefaults()
+	want := CircuitBreakerConfig{
+		Enabled:          true,
+		FailureRatio:     DefaultBreakerFailureRatio,
+		MinRequests:      4,
+		Window:           DefaultBreakerWindow,
+		OpenTimeout:      DefaultBreakerOpenTimeout,
+		HalfOpenRequests: DefaultBreakerHalfOpenRequests,
+	}
+	if got != want {
+		t.Errorf("WithDefaults() = %+v, want %+v", got, want)
+	}
+}
+
+func TestValidate_Health(t *testing.T) {
+	tests := []struct {
+		name    string
+		health  HealthConfig
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "configured", health: HealthConfig{Timeout: time.Second, ProbeUpstreams: true, ProbePath: "/status"}},
+		{name: "negative timeout", health: HealthConfig{Timeout: -time.Second}, wantErr: true},
+		{name: "relative probe path", health: HealthConfig{ProbePath: "status"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Health = tt.health
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHealthUpstreamProbePath(t *testing.T) {
+	checked := NamedUpstreamConfig{UpstreamConfig: UpstreamConfig{HealthCheck: HealthCheckConfig{Path: "/healthz"}}}
+
+	tests := []struct {
+		name   string
+		health HealthConfig
+		up     NamedUpstreamConfig
+		want   string
+	}{
+		{name: "root by default", want: "/"},
+		{name: "upstream health check path", up: checked, want: "/healthz"},
+		{name: "probe path wins", health: HealthConfig{ProbePath: "/ready"}, up: checked, want: "/ready"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.health.UpstreamProbePath(tt.up); got != tt.want {
+				t.Errorf("UpstreamProbePath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate_Warmer(t *testing.T) {
+	users := WarmRequestConfig{Path: "/users/{id}", Params: map[string][]string{"id": {"1", "2"}}}
+
+	tests := []struct {
+		name    string
+		warmer  WarmerConfig
+		wantErr bool
+	}{
+		{name: "disabled with bad values", warmer: WarmerConfig{Concurrency: -1}},
+		{name: "requests", warmer: WarmerConfig{Enabled: true, Interval: time.Minute, Requests: []WarmRequestConfig{users}}},
+		{name: "top requests only", warmer: WarmerConfig{Enabled: true, TopRequests: 50}},
+		{name: "nothing to warm", warmer: WarmerConfig{Enabled: true}, wantErr: true},
+		{name: "negative interval", warmer: WarmerConfig{Enabled: true, TopRequests: 5, Interval: -time.Second}, wantErr: true},
+		{name: "missing params", warmer: WarmerConfig{Enabled: true, Requests: []WarmRequestConfig{{Path: "/users/{id}"}}}, wantErr: true},
+		{name: "relative path", warmer: WarmerConfig{Enabled: true, Requests: []WarmRequestConfig{{Path: "users"}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Warmer = tt.warmer
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWarmRequestExpand(t *testing.T) {
+	req := WarmRequestConfig{
+		Path: "/quotes/{symbol}?range={range}&symbol={symbol}",
+		Params: map[string][]string{
+			"symbol": {"AAPL", "BRK/B"},
+			"range":  {"1d", "1 y"},
+		},
+	}
+	got, err := req.Expand()
+	if err != nil {
+		t.Fatalf("Expand() error = %v", err)
+	}
+	want := []string{
+		"/quotes/AAPL?range=1d&symbol=AAPL",
+		"/quotes/AAPL?range=1+y&symbol=AAPL",
+		"/quotes/BRK%2FB?range=1d&symbol=BRK%2FB",
+		"/quotes/BRK%2FB?range=1+y&symbol=BRK%2FB",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Expand() = %v, want %v", got, want)
+	}
+
+	plain := WarmRequestConfig{Path: "/status"}
+	if got, err := plain.Expand(); err != nil || !slices.Equal(got, []string{"/status"}) {
+		t.Errorf("Expand() without placeholders = %v, %v", got, err)
+	}
+}
+
+func TestValidate_Retry(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   RetryConfig
+		wantErr bool
+	}{
+		{name: "defaults"},
+		{name: "decorrelated jitter", retry: RetryConfig{Jitter: "decorrelated", Budget: 5 * time.Second, MaxRetryAfter: 30 * time.Second}},
+		{name: "no jitter", retry: RetryConfig{Jitter: "none"}},
+		{name: "unknown jitter", retry: RetryConfig{Jitter: "equal"}, wantErr: true},
+		{name: "negative budget", retry: RetryConfig{Budget: -time.Second}, wantErr: true},
+		{name: "negative max retry after", retry: RetryConfig{MaxRetryAfter: -time.Second}, wantErr: true},
+		{name: "negative max body bytes", retry: RetryConfig{MaxBodyBytes: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Retry = tt.retry
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryAfterLimit(t *testing.T) {
+	if got := (&RetryConfig{}).RetryAfterLimit(); got != DefaultRetryMaxRetryAfter {
+		t.Errorf("RetryAfterLimit() = %v, want default %v", got, DefaultRetryMaxRetryAfter)
+	}
+	if got := (&RetryConfig{MaxRetryAfter: time.Minute}).RetryAfterLimit(); got != time.Minute {
+		t.Errorf("RetryAfterLimit() = %v, want %v", got, time.Minute)
+	}
+}
+
+func TestBodyBufferLimit(t *testing.T) {
+	if got := (&RetryConfig{}).BodyBufferLimit(); got != DefaultRetryMaxBodyBytes {
+		t.Errorf("BodyBufferLimit() = %d, want default %d", got, DefaultRetryMaxBodyBytes)
+	}
+	if got := (&RetryConfig{MaxBodyBytes: 4096}).BodyBufferLimit(); got != 4096 {
+		t.Errorf("BodyBufferLimit() = %d, want 4096", got)
+	}
+}
+
+func TestValidate_RateLimitMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		maxDelay time.Duration
+		wantErr  bool
+	}{
+		{name: "default"},
+		{name: "reject", mode: "reject"},
+		{name: "delay", mode: "delay", maxDelay: 500 * time.Millisecond},
+		{name: "unknown mode", mode: "queue", wantErr: true},
+		{name: "negative max delay", mode: "delay", maxDelay: -time.Second, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.RateLimit.Mode = tt.mode
+			cfg.RateLimit.MaxDelay = tt.maxDelay
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRateLimitMaxWait(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  RateLimitConfig
+		want time.Duration
+	}{
+		{name: "reject mode", cfg: RateLimitConfig{MaxDelay: time.Second}, want: 0},
+		{name: "delay default", cfg: RateLimitConfig{Mode: "delay"}, want: DefaultRateLimitMaxDelay},
+		{name: "delay configured", cfg: RateLimitConfig{Mode: "delay", MaxDelay: 250 * time.Millisecond}, want: 250 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.MaxWait(); got != tt.want {
+			t.Errorf("%s: MaxWait() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	rl := RateLimitConfig{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.5", "::1"}}
+	if err := rl.parseTrustedProxies(); err != nil {
+		t.Fatalf("parseTrustedProxies failed: %v", err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"::1", true},
+		{"::ffff:10.0.0.1", true},
+		{"203.0.113.7", false},
+	}
+	for _, tt := range tests {
+		if got := rl.IsTrustedProxy(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("IsTrustedProxy(%s) = %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	rl.TrustedProxies = []string{"not-an-ip"}
+	if err := rl.parseTrustedProxies(); err == nil {
+		t.Error("Expected error for invalid trusted proxy, got nil")
+	}
+}
+
+func TestValidate_UpstreamQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   QuotaConfig
+		wantErr bool
+	}{
+		{name: "disabled", quota: QuotaConfig{}},
+		{name: "per minute and day", quota: QuotaConfig{Enabled: true, PerMinute: 5, PerDay: 500, MaxWait: 10 * time.Second}},
+		{name: "no windows", quota: QuotaConfig{Enabled: true}, wantErr: true},
+		{name: "negative max wait", quota: QuotaConfig{Enabled: true, PerDay: 500, MaxWait: -time.Second}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Upstream.Quota = tt.quota
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+		t.Run(tt.name+" on named upstream", func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Upstreams = []NamedUpstreamConfig{{Name: "billing", UpstreamConfig: UpstreamConfig{BaseURL: "http://billing:9000", Quota: tt.quota}}}
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidate_Routes(t *testing.T) {
+	billing := NamedUpstreamConfig{Name: "billing", UpstreamConfig: UpstreamConfig{BaseURL: "http://billing:9000"}}
+
+	tests := []struct {
+		name      string
+		upstreams []NamedUpstreamConfig
+		routes    []RouteConfig
+		wantErr   bool
+	}{
+		{name: "no routes"},
+		{name: "prefix route", upstreams: []NamedUpstreamConfig{billing}, routes: []RouteConfig{{PathPrefix: "/billing", Upstream: "billing", StripPrefix: "/billing"}}},
+		{name: "route to default", routes: []RouteConfig{{Path: "/status", Upstream: DefaultUpstreamName}}},
+		{name: "upstream without base url", upstreams: []NamedUpstreamConfig{{Name: "billing"}}, wantErr: true},
+		{name: "upstream named default", upstreams: []NamedUpstreamConfig{{Name: DefaultUpstreamName, UpstreamConfig: UpstreamConfig{BaseURL: "http://x"}}}, wantErr: true},
+		{name: "duplicate upstream", upstreams: []NamedUpstreamConfig{billing, billing}, wantErr: true},
+		{name: "invalid upstream retry", upstreams: []NamedUpstreamConfig{{Name: "billing", UpstreamConfig: UpstreamConfig{BaseURL: "http://x"}, Retry: &RetryConfig{Jitter: "equal"}}}, wantErr: true},
+		{name: "unknown upstream", routes: []RouteConfig{{PathPrefix: "/billing", Upstream: "billing"}}, wantErr: true},
+		{name: "no matcher", upstreams: []NamedUpstreamConfig{billing}, routes: []RouteConfig{{Upstream: "billing"}}, wantErr: true},
+		{name: "two matchers", upstreams: []NamedUpstreamConfig{billing}, routes: []RouteConfig{{Path: "/a", PathPrefix: "/b", Upstream: "billing"}}, wantErr: true},
+		{name: "rewrite without strip", upstreams: []NamedUpstreamConfig{billing}, routes: []RouteConfig{{PathPrefix: "/b", Upstream: "billing", RewritePrefix: "/v2"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Upstreams = tt.upstreams
+			cfg.Routes = tt.routes
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveUpstream(t *testing.T) {
+	billingRetry := &RetryConfig{Enabled: true, MaxAttempts: 5}
+	cfg := validTestConfig()
+	cfg.Retry = RetryConfig{Enabled: true, MaxAttempts: 2}
+	cfg.Upstreams = []NamedUpstreamConfig{
+		{Name: "billing", UpstreamConfig: UpstreamConfig{BaseURL: "http://billing:9000"}, Retry: billingRetry},
+		{Name: "inventory", UpstreamConfig: UpstreamConfig{BaseURL: "http://inventory:9000"}},
+	}
+	cfg.Routes = []RouteConfig{
+		{PathPrefix: "/billing/", Upstream: "billing", StripPrefix: "/billing"},
+		{PathRegex: "^/legacy/v1/", Upstream: "inventory", StripPrefix: "/legacy/v1", RewritePrefix: "/v2"},
+		{Path: "/stock", Upstream: "inventory"},
+	}
+	if err := cfg.compileRegexPatterns(); err != nil {
+		t.Fatalf("compileRegexPatterns() error = %v", err)
+	}
+
+	tests := []struct {
+		path         string
+		wantUpstream string
+		wantPath     string
+		wantAttempts int
+	}{
+		{"/billing", "billing", "/", 5},
+		{"/billing/invoices/7", "billing", "/invoices/7", 5},
+		{"/billings", DefaultUpstreamName, "/billings", 2},
+		{"/legacy/v1/items", "inventory", "/v2/items", 2},
+		{"/stock", "inventory", "/stock", 2},
+		{"/stock/1", DefaultUpstreamName, "/stock/1", 2},
+		{"/api/users", DefaultUpstreamName, "/api/users", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got := cfg.ResolveUpstream(tt.path)
+			if got.Upstream.Name != tt.wantUpstream || got.Path != tt.wantPath || got.Retry.MaxAttempts != tt.wantAttempts {
+				t.Errorf("ResolveUpstream(%q) = upstream %q, path %q, max_attempts %d; want %q, %q, %d",
+					tt.path, got.Upstream.Name, got.Path, got.Retry.MaxAttempts, tt.wantUpstream, tt.wantPath, tt.wantAttempts)
+			}
+		})
+	}
+
+	if got := cfg.ResolveUpstream("/api").Upstream.BaseURL; got != cfg.Upstream.BaseURL {
+		t.Errorf("default upstream base_url = %q, want %q", got, cfg.Upstream.BaseURL)
+	}
+}
+
+func TestLoad_ExampleConfig(t *testing.T) {
+	cfg, err := Load("../../config.example.yaml")
+	if err != nil {
+		t.Fatalf("config.example.yaml should load: %v", err)
+	}
+	// Named upstreams read the shared upstream settings inline
+	if up := cfg.Upstreams[0]; up.BaseURL != "http://localhost:9100" || up.Timeout != 10*time.Second || up.Retry == nil {
+		t.Errorf("billing upstream = %+v, want base_url, timeout, and retry from the file", up)
+	}
+}
+
+func TestValidate_UpstreamBalancing(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream UpstreamConfig
+		wantErr  bool
+	}{
+		{name: "base url only", upstream: UpstreamConfig{BaseURL: "http://a"}},
+		{name: "targets only", upstream: UpstreamConfig{Targets: []string{"http://a", "http://b"}, Balance: "least_in_flight"}},
+		{name: "consistent hash by header", upstream: UpstreamConfig{Targets: []string{"http://a"}, Balance: "consistent_hash", HashBy: "header:X-User-ID"}},
+		{name: "health check", upstream: UpstreamConfig{BaseURL: "http://a", HealthCheck: HealthCheckConfig{Enabled: true, Path: "/healthz"}}},
+		{name: "no base url or targets", upstream: UpstreamConfig{}, wantErr: true},
+		{name: "empty target", upstream: UpstreamConfig{Targets: []string{""}}, wantErr: true},
+		{name: "target without scheme", upstream: UpstreamConfig{Targets: []string{"http://a", "b:9000"}}, wantErr: true},
+		{name: "target without host", upstream: UpstreamConfig{Targets: []string{"http://"}}, wantErr: true},
+		{name: "malformed target", upstream: UpstreamConfig{Targets: []string{"http://a b:9000/%zz"}}, wantErr: true},
+		{name: "malformed base url", upstream: UpstreamConfig{BaseURL: "localhost:9000"}, wantErr: true},
+		{name: "unknown balance", upstream: UpstreamConfig{BaseURL: "http://a", Balance: "random"}, wantErr: true},
+		{name: "unknown hash by", upstream: UpstreamConfig{BaseURL: "http://a", HashBy: "cookie:id"}, wantErr: true},
+		{name: "health check without path", upstream: UpstreamConfig{BaseURL: "http://a", HealthCheck: HealthCheckConfig{Enabled: true}}, wantErr: true},
+		{name: "negative ejection", upstream: UpstreamConfig{BaseURL: "http://a", Ejection: EjectionConfig{Enabled: true, Duration: -time.Second}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := validTestConfig()
+			cfg.Upstream = tt.upstream
+			err := cfg.validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Named upstreams are checked the same way
+	cfg := validTestConfig()
+	cfg.Upstreams = []NamedUpstreamConfig{{Name: "billing", UpstreamConfig: UpstreamConfig{Targets: []string{"http://a"}, Balance: "random"}}}
+	if err := cfg.validate(); err == nil {
+		t.Error("validate() should reject an unknown balance on a named upstream")
+	}
+}
+
+func TestTargetURLs(t *testing.T) {
+	up := NamedUpstreamConfig{UpstreamConfig: UpstreamConfig{BaseURL: "http://base"}}
+	if got := up.TargetURLs(); len(got) != 1 || got[0] != "http://base" {
+		t.Errorf("TargetURLs() = %v, want [base_url]", got)
+	}
+	up.Targets = []string{"http://a", "http://b"}
+	if got := up.TargetURLs(); len(got) != 2 || got[0] != "http://a" {
+		t.Errorf("TargetURLs() = %v, want targets", got)
+	}
+}
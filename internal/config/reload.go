@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync/atomic"
+)
+
+// Holder provides atomic access to the current configuration so it can be
+// swapped on reload while requests are in flight. Callers should take one
+// snapshot with Get per unit of work rather than caching the pointer.
+type Holder struct {
+	current atomic.Pointer[Config]
+}
+
+// NewHolder creates a holder serving cfg
+func NewHolder(cfg *Config) *Holder {
+	h := &Holder{}
+	h.current.Store(cfg)
+	return h
+}
+
+// Get returns the current configuration
+func (h *Holder) Get() *Config {
+	return h.current.Load()
+}
+
+// Reload loads, validates, and compiles the configuration at path and swaps it
+// in. If the new configuration is invalid the current one is kept and an error
+// is returned. It returns the previous and the now-current configuration.
+func (h *Holder) Reload(path string) (*Config, *Config, error) {
+	previous := h.Get()
+	cfg, err := Load(path)
+	if err != nil {
+		return previous, previous, err
+	}
+	h.current.Store(cfg)
+	return previous, cfg, nil
+}
+
+// Diff returns the dotted YAML names of the settings that differ between two
+// configurations, e.g. "cache.default_ttl". Endpoint lists are compared per
+// endpoint identifier, e.g. "cache.endpoints[/query]".
+func Diff(old, updated *Config) []string {
+	var changes []string
+	diffValue("", reflect.ValueOf(*old), reflect.ValueOf(*updated), &changes)
+	return changes
+}
+
+// identifiedEndpoint is implemented by endpoint configs compared by identifier
+type identifiedEndpoint interface {
+	EndpointIdentifier() string
+}
+
+func diffValue(name string, old, updated reflect.Value, changes *[]string) {
+	switch old.Kind() {
+	case reflect.Struct:
+		for i := 0; i < old.NumField(); i++ {
+			field := old.Type().Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if !field.IsExported() || tag == "" || tag == "-" {
+				continue
+			}
+			if name != "" {
+				tag = name + "." + tag
+			}
+			diffValue(tag, old.Field(i), updated.Field(i), changes)
+		}
+	case reflect.Slice:
+		if old.Type().Elem().Kind() == reflect.Struct && reflect.PointerTo(old.Type().Elem()).Implements(reflect.TypeFor[identifiedEndpoint]()) {
+			diffEndpoints(name, old, updated, changes)
+			return
+		}
+		fallthrough
+	default:
+		if !reflect.DeepEqual(old.Interface(), updated.Interface()) {
+			*changes = append(*changes, name)
+		}
+	}
+}
+
+// diffEndpoints compares endpoint lists grouped by identifier, since several
+// entries may share a path and differ only in query param matching
+func diffEndpoints(name string, old, updated reflect.Value, changes *[]string) {
+	group := func(list reflect.Value) (map[string][]reflect.Value, []string) {
+		groups := make(map[string][]reflect.Value)
+		var order []string
+		for i := 0; i < list.Len(); i++ {
+			id := list.Index(i).Addr().Interface().(identifiedEndpoint).EndpointIdentifier()
+			if _, ok := groups[id]; !ok {
+				order = append(order, id)
+			}
+			groups[id] = append(groups[id], list.Index(i))
+		}
+		return groups, order
+	}
+
+	oldGroups, oldOrder := group(old)
+	newGroups, newOrder := group(updated)
+
+	for _, id := range oldOrder {
+		entries, ok := newGroups[id]
+		if !ok || len(entries) != len(oldGroups[id]) {
+			*changes = append(*changes, fmt.Sprintf("%s[%s]", name, id))
+			continue
+		}
+		var sub []string
+		for i := range entries {
+			diffValue("", oldGroups[id][i], entries[i], &sub)
+		}
+		if len(sub) > 0 {
+			*changes = append(*changes, fmt.Sprintf("%s[%s]", name, id))
+		}
+	}
+	for _, id := range newOrder {
+		if _, ok := oldGroups[id]; !ok {
+			*changes = append(*changes, fmt.Sprintf("%s[%s]", name, id))
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const reloadTestConfig = `
+server:
+  port: 8080
+valkey:
+  port: 6379
+upstream:
+  base_url: "http://localhost:9000"
+cache:
+  default_ttl: %s
+  endpoints:
+    - path_regex: "^/api/v1/users/[0-9]+$"
+      methods: ["GET"]
+      ttl: 60s
+`
+
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+}
+
+func TestHolderReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, fmt.Sprintf(reloadTestConfig, "300s"))
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	holder := NewHolder(cfg)
+
+	writeConfig(t, path, fmt.Sprintf(reloadTestConfig, "600s"))
+	previous, current, err := holder.Reload(path)
+	if err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if previous != cfg || holder.Get() != current {
+		t.Error("Reload did not swap in the new configuration")
+	}
+	if current.Cache.DefaultTTL != 10*time.Minute {
+		t.Errorf("DefaultTTL = %v, want 10m", current.Cache.DefaultTTL)
+	}
+	if ep := current.GetEndpointCacheConfig("/api/v1/users/42", "GET", nil); ep == nil {
+		t.Error("regex endpoint not compiled after reload")
+	}
+
+	// An invalid configuration is rejected and the current one kept
+	writeConfig(t, path, "server:\n  port: -1\n")
+	if _, _, err := holder.Reload(path); err == nil {
+		t.Error("Expected error reloading invalid configuration, got nil")
+	}
+	if holder.Get() != current {
+		t.Error("invalid configuration replaced the current one")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := validTestConfig()
+	old.Cache.DefaultTTL = 5 * time.Minute
+	old.Cache.Endpoints = []EndpointCacheConfig{
+		{Path: "/query", TTL: time.Hour, MatchQueryParams: map[string][]string{"function": {"EOD"}}},
+		{Path: "/query", TTL: time.Minute},
+		{Path: "/users", TTL: time.Minute},
+	}
+	old.RateLimit.Endpoints = []EndpointRateLimitConfig{{Path: "/query", RequestsPerSecond: 10, Burst: 20}}
+
+	updated := validTestConfig()
+	updated.Cache.DefaultTTL = 10 * time.Minute
+	updated.Cache.Endpoints = []EndpointCacheConfig{
+		{Path: "/query", TTL: time.Hour, MatchQueryParams: map[string][]string{"function": {"EOD"}}},
+		{Path: "/query", TTL: 2 * time.Minute},
+		{PathRegex: "^/items/[0-9]+$", TTL: time.Minute},
+	}
+	updated.RateLimit.Endpoints = []EndpointRateLimitConfig{{Path: "/query", RequestsPerSecond: 10, Burst: 20}}
+
+	want := []string{
+		"cache.default_ttl",
+		"cache.endpoints[/query]",
+		"cache.endpoints[/users]",
+		"cache.endpoints[regex:^/items/[0-9]+$]",
+	}
+	if got := Diff(old, updated); !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+
+	if got := Diff(old, old); len(got) != 0 {
+		t.Errorf("Diff() of identical configs = %v, want none", got)
+	}
+}
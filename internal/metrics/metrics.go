@@ -0,0 +1,185 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+const namespace = "api_cache"
+
+// DefaultEndpoint labels requests that matched no endpoint config. Labels are
+// always endpoint config identifiers rather than raw paths to bound cardinality.
+const DefaultEndpoint = "default"
+
+var (
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_requests_total",
+		Help:      "Proxied requests by endpoint config and cache result (hit, miss, stale, coalesced, bypass).",
+	}, []string{"endpoint", "result"})
+
+	cacheBytesServed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_served_bytes_total",
+		Help:      "Response body bytes served from cache by endpoint config.",
+	}, []string{"endpoint"})
+
+	l1Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "l1_requests_total",
+		Help:      "In-process L1 cache lookups by result (hit, miss).",
+	}, []string{"result"})
+
+	upstreamDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_request_duration_seconds",
+		Help:      "Latency of individual upstream attempts by endpoint config and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint", "status"})
+
+	upstreamRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_retries_total",
+		Help:      "Upstream retry attempts by endpoint config.",
+	}, []string{"endpoint"})
+
+	valkeyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "valkey_operation_duration_seconds",
+		Help:      "Latency of Valkey commands by command name.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	valkeyErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "valkey_errors_total",
+		Help:      "Failed Valkey commands by command name. Cache misses are not errors.",
+	}, []string{"operation"})
+
+	rateLimitRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests rejected by the inbound rate limiter by rate limit endpoint config.",
+	}, []string{"endpoint"})
+
+	circuitBreakerTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "circuit_breaker_transitions_total",
+		Help:      "Upstream circuit breaker state changes by endpoint config and new state (closed, open, half_open).",
+	}, []string{"endpoint", "state"})
+
+	cacheWarmRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_warm_requests_total",
+		Help:      "Cache warming requests by result (warmed, fresh, skipped, failed).",
+	}, []string{"result"})
+)
+
+// Handler returns the Prometheus scrape handler. Whether metrics are enabled
+// and the scrape path follow configuration reloads.
+func Handler(configs *config.Holder) http.Handler {
+	scrape := promhttp.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := configs.Get().Metrics
+		if !m.Enabled || r.URL.Path != m.ScrapePath() {
+			http.NotFound(w, r)
+			return
+		}
+		scrape.ServeHTTP(w, r)
+	})
+}
+
+// EndpointLabel returns the metric label for a matched cache endpoint config
+func EndpointLabel(ep *config.EndpointCacheConfig) string {
+	if ep == nil {
+		return DefaultEndpoint
+	}
+	return ep.EndpointIdentifier()
+}
+
+// RecordCacheResult counts a proxied request by its cache result
+func RecordCacheResult(endpoint, result string) {
+	cacheRequests.WithLabelValues(endpoint, result).Inc()
+}
+
+// RecordCacheBytesServed adds to the bytes served from cache
+func RecordCacheBytesServed(endpoint string, n int) {
+	cacheBytesServed.WithLabelValues(endpoint).Add(float64(n))
+}
+
+// RecordL1Result counts an in-process L1 cache lookup
+func RecordL1Result(result string) {
+	l1Requests.WithLabelValues(result).Inc()
+}
+
+// ObserveUpstream records the latency of one upstream attempt. A zero status
+// means the attempt failed before a response was received.
+func ObserveUpstream(endpoint string, status int, duration time.Duration) {
+	label := "error"
+	if status > 0 {
+		label = strconv.Itoa(status)
+	}
+	upstreamDuration.WithLabelValues(endpoint, label).Observe(duration.Seconds())
+}
+
+// RecordUpstreamRetry counts an upstream retry attempt
+func RecordUpstreamRetry(endpoint string) {
+	upstreamRetries.WithLabelValues(endpoint).Inc()
+}
+
+// RecordRateLimitRejection counts a request rejected by the inbound rate limiter
+func RecordRateLimitRejection(endpoint string) {
+	rateLimitRejections.WithLabelValues(endpoint).Inc()
+}
+
+// RecordCircuitBreakerTransition counts a circuit breaker moving to state
+func RecordCircuitBreakerTransition(endpoint, state string) {
+	circuitBreakerTransitions.WithLabelValues(endpoint, state).Inc()
+}
+
+// RecordCacheWarm counts a cache warming request by its result
+func RecordCacheWarm(result string) {
+	cacheWarmRequests.WithLabelValues(result).Inc()
+}
+
+// ValkeyHook instruments Valkey commands issued through a go-redis client
+type ValkeyHook struct{}
+
+func (ValkeyHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (ValkeyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		observeValkey(cmd.Name(), time.Since(start), err)
+		return err
+	}
+}
+
+func (ValkeyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		observeValkey("pipeline", time.Since(start), err)
+		return err
+	}
+}
+
+// observeValkey records a Valkey operation, treating redis.Nil (key not found) as success
+func observeValkey(operation string, duration time.Duration, err error) {
+	valkeyDuration.WithLabelValues(operation).Observe(duration.Seconds())
+	if err != nil && err != redis.Nil {
+		valkeyErrors.WithLabelValues(operation).Inc()
+	}
+}
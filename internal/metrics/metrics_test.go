@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+func TestValkeyHookCountsErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		wantCount float64
+	}{
+		{name: "success", wantCount: 0},
+		{name: "key not found", err: redis.Nil, wantCount: 0},
+		{name: "failure", err: errors.New("connection reset"), wantCount: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			cmd := redis.NewStringCmd(ctx, "get", "key")
+			process := ValkeyHook{}.ProcessHook(func(context.Context, redis.Cmder) error { return tt.err })
+
+			before := testutil.ToFloat64(valkeyErrors.WithLabelValues("get"))
+			if err := process(ctx, cmd); err != tt.err {
+				t.Errorf("hook returned %v, want %v", err, tt.err)
+			}
+			if got := testutil.ToFloat64(valkeyErrors.WithLabelValues("get")) - before; got != tt.wantCount {
+				t.Errorf("valkey errors increased by %v, want %v", got, tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestValkeyHookPipelineIgnoresNil(t *testing.T) {
+	process := ValkeyHook{}.ProcessPipelineHook(func(context.Context, []redis.Cmder) error { return redis.Nil })
+
+	before := testutil.ToFloat64(valkeyErrors.WithLabelValues("pipeline"))
+	process(context.Background(), nil)
+	if got := testutil.ToFloat64(valkeyErrors.WithLabelValues("pipeline")); got != before {
+		t.Errorf("valkey errors = %v, want %v", got, before)
+	}
+}
+
+func TestCountersLabelledByEndpoint(t *testing.T) {
+	exact := &config.EndpointCacheConfig{Path: "/users"}
+	regex := &config.EndpointCacheConfig{PathRegex: "^/orders/[0-9]+$"}
+
+	tests := []struct {
+		name string
+		ep   *config.EndpointCacheConfig
+		want string
+	}{
+		{name: "no endpoint", want: DefaultEndpoint},
+		{name: "exact path", ep: exact, want: "/users"},
+		{name: "path regex", ep: regex, want: "regex:^/orders/[0-9]+$"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			label := EndpointLabel(tt.ep)
+			if label != tt.want {
+				t.Fatalf("EndpointLabel() = %q, want %q", label, tt.want)
+			}
+
+			hits := cacheRequests.WithLabelValues(tt.want, "hit")
+			served := cacheBytesServed.WithLabelValues(tt.want)
+			hitsBefore, servedBefore := testutil.ToFloat64(hits), testutil.ToFloat64(served)
+
+			RecordCacheResult(label, "hit")
+			RecordCacheBytesServed(label, 512)
+
+			if got := testutil.ToFloat64(hits) - hitsBefore; got != 1 {
+				t.Errorf("hits for %q increased by %v, want 1", tt.want, got)
+			}
+			if got := testutil.ToFloat64(served) - servedBefore; got != 512 {
+				t.Errorf("bytes served for %q increased by %v, want 512", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandlerFollowsConfig(t *testing.T) {
+	cfg := &config.Config{}
+	handler := Handler(config.NewHolder(cfg))
+
+	scrape := func(path string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w.Code
+	}
+
+	if got := scrape("/metrics"); got != http.StatusNotFound {
+		t.Errorf("disabled metrics = %d, want %d", got, http.StatusNotFound)
+	}
+
+	cfg.Metrics = config.MetricsConfig{Enabled: true, Path: "/internal/metrics"}
+	if got := scrape("/internal/metrics"); got != http.StatusOK {
+		t.Errorf("enabled metrics = %d, want %d", got, http.StatusOK)
+	}
+	if got := scrape("/metrics"); got != http.StatusNotFound {
+		t.Errorf("metrics on another path = %d, want %d", got, http.StatusNotFound)
+	}
+}
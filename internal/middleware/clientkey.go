@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+// clientKey identifies the client a request is rate limited as, from the
+// configured key_by sources. It returns the limiter key and a loggable identity
+// in which header and query values are replaced by a short fingerprint.
+// Requests missing a source value share the limiter for the empty value.
+func clientKey(r *http.Request, rl *config.RateLimitConfig, keyBy []string) (string, string) {
+	if len(keyBy) == 0 {
+		return "", ""
+	}
+
+	keyParts := make([]string, 0, len(keyBy))
+	identityParts := make([]string, 0, len(keyBy))
+	for _, source := range keyBy {
+		var value, identity string
+		switch {
+		case source == config.RateLimitKeyIP:
+			value = clientIP(r, rl)
+			identity = value
+		case strings.HasPrefix(source, config.RateLimitKeyHeader):
+			value = r.Header.Get(strings.TrimPrefix(source, config.RateLimitKeyHeader))
+			identity = fingerprint(value)
+		case strings.HasPrefix(source, config.RateLimitKeyQuery):
+			value = r.URL.Query().Get(strings.TrimPrefix(source, config.RateLimitKeyQuery))
+			identity = fingerprint(value)
+		}
+		keyParts = append(keyParts, source+"="+value)
+		identityParts = append(identityParts, source+"="+identity)
+	}
+	return strings.Join(keyParts, "|"), strings.Join(identityParts, "|")
+}
+
+// clientIP returns the address of the client that sent a request. When the
+// direct peer is a trusted proxy, X-Forwarded-For is walked from the right,
+// skipping trusted proxies, so clients cannot spoof their address by
+// prepending entries.
+func clientIP(r *http.Request, rl *config.RateLimitConfig) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !rl.IsTrustedProxy(peer) {
+		return host
+	}
+
+	var hops []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+
+	client := host
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// Anything left of a malformed entry cannot be trusted
+			break
+		}
+		client = addr.Unmap().String()
+		if !rl.IsTrustedProxy(addr) {
+			break
+		}
+	}
+	return client
+}
+
+// fingerprint returns a short, non-reversible identifier for a secret value
+func fingerprint(value string) string {
+	if value == "" {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(hash[:4])
+}
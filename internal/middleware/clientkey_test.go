@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	cfg := loadConfig(t, "rate_limit:\n  trusted_proxies: [\"10.0.0.1\", \"192.168.0.0/16\"]\n")
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{name: "no forwarded header", remote: "203.0.113.5:4321", want: "203.0.113.5"},
+		{name: "remote without port", remote: "203.0.113.5", want: "203.0.113.5"},
+		{name: "untrusted peer cannot forward", remote: "203.0.113.5:4321", xff: []string{"198.51.100.7"}, want: "203.0.113.5"},
+		{name: "trusted peer without forwarded header", remote: "10.0.0.1:4321", want: "10.0.0.1"},
+		{name: "trusted peer", remote: "10.0.0.1:4321", xff: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "spoofed entries left of the client are ignored", remote: "10.0.0.1:4321", xff: []string{"1.2.3.4, 198.51.100.7"}, want: "198.51.100.7"},
+		{name: "trusted proxies in the chain are skipped", remote: "10.0.0.1:4321", xff: []string{"198.51.100.7, 192.168.4.4"}, want: "198.51.100.7"},
+		{name: "trusted by CIDR", remote: "192.168.1.10:4321", xff: []string{"198.51.100.7"}, want: "198.51.100.7"},
+		{name: "chain of trusted proxies only", remote: "10.0.0.1:4321", xff: []string{"192.168.1.1, 10.0.0.1"}, want: "192.168.1.1"},
+		{name: "multiple header lines", remote: "10.0.0.1:4321", xff: []string{"1.2.3.4", "198.51.100.7, 192.168.4.4"}, want: "198.51.100.7"},
+		{name: "malformed last entry", remote: "10.0.0.1:4321", xff: []string{"198.51.100.7, not-an-ip"}, want: "10.0.0.1"},
+		{name: "malformed entry left of the client", remote: "10.0.0.1:4321", xff: []string{"not-an-ip, 198.51.100.7"}, want: "198.51.100.7"},
+		{name: "malformed entry behind a trusted proxy", remote: "10.0.0.1:4321", xff: []string{"198.51.100.7, garbage, 192.168.4.4"}, want: "192.168.4.4"},
+		{name: "IPv4-mapped address", remote: "10.0.0.1:4321", xff: []string{"::ffff:198.51.100.7"}, want: "198.51.100.7"},
+		{name: "IPv6 client", remote: "[::ffff:10.0.0.1]:4321", xff: []string{"2001:db8::1"}, want: "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			if got := clientIP(r, &cfg.RateLimit); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	cfg := loadConfig(t, "")
+
+	tests := []struct {
+		name         string
+		keyBy        []string
+		target       string
+		header       http.Header
+		wantKey      string
+		wantIdentity string
+	}{
+		{name: "shared limiter", target: "/"},
+		{
+			name:         "ip",
+			keyBy:        []string{"ip"},
+			target:       "/",
+			wantKey:      "ip=192.0.2.1",
+			wantIdentity: "ip=192.0.2.1",
+		},
+		{
+			name:         "header",
+			keyBy:        []string{"header:X-API-Key"},
+			target:       "/",
+			header:       http.Header{"X-Api-Key": {"secret"}},
+			wantKey:      "header:X-API-Key=secret",
+			wantIdentity: "header:X-API-Key=" + fingerprint("secret"),
+		},
+		{
+			name:         "query",
+			keyBy:        []string{"query:apikey"},
+			target:       "/?apikey=secret",
+			wantKey:      "query:apikey=secret",
+			wantIdentity: "query:apikey=" + fingerprint("secret"),
+		},
+		{
+			name:         "missing value",
+			keyBy:        []string{"header:X-API-Key"},
+			target:       "/",
+			wantKey:      "header:X-API-Key=",
+			wantIdentity: "header:X-API-Key=",
+		},
+		{
+			name:         "combined sources",
+			keyBy:        []string{"ip", "query:apikey"},
+			target:       "/?apikey=secret",
+			wantKey:      "ip=192.0.2.1|query:apikey=secret",
+			wantIdentity: "ip=192.0.2.1|query:apikey=" + fingerprint("secret"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			r.RemoteAddr = "192.0.2.1:4321"
+			for name, values := range tt.header {
+				r.Header[name] = values
+			}
+			key, identity := clientKey(r, &cfg.RateLimit, tt.keyBy)
+			if key != tt.wantKey || identity != tt.wantIdentity {
+				t.Errorf("clientKey() = %q, %q, want %q, %q", key, identity, tt.wantKey, tt.wantIdentity)
+			}
+			if strings.Contains(identity, "secret") {
+				t.Errorf("identity %q leaks the key value", identity)
+			}
+		})
+	}
+}
@@ -1,86 +1,313 @@
 package middleware
 
 import (
+	"context"
+	"math"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/singh-gur/api_cache/internal/cache"
 	"github.com/singh-gur/api_cache/internal/config"
 	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/metrics"
 	"golang.org/x/time/rate"
 )
 
+// distributedTimeout bounds each shared limiter check so a slow Valkey cannot
+// stall requests
+const distributedTimeout = 250 * time.Millisecond
+
+// distributedRetryInterval is how long local limiting is used after the shared
+// limiter fails before Valkey is tried again
+const distributedRetryInterval = 5 * time.Second
+
+// DistributedStore admits requests against rate limit budgets shared by all
+// replicas
+type DistributedStore interface {
+	AllowRequest(ctx context.Context, key string, rps float64, burst int, maxWait time.Duration) (cache.RateLimitResult, error)
+	// RefundRequest hands back the slot of an admitted request that gave up
+	RefundRequest(ctx context.Context, key string, rps float64) error
+}
+
 type RateLimiter struct {
-	config   *config.RateLimitConfig
-	limiters map[string]*rate.Limiter
+	config   *config.Holder
+	limiters map[string]*limiterEntry
 	mu       sync.RWMutex
+	// lastSweep is when idle limiters were last evicted
+	lastSweep time.Time
+	// store backs rate_limit.distributed; it may be nil
+	store DistributedStore
+	// storeDownUntil is the Unix nanosecond time until which the store is
+	// skipped after a failure
+	storeDownUntil atomic.Int64
+	storeDown      atomic.Bool
 }
 
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter(cfg *config.RateLimitConfig) *RateLimiter {
+// limiterEntry is a limiter together with the settings it was built from, so it
+// can be rebuilt when a configuration reload changes them
+type limiterEntry struct {
+	limiter *rate.Limiter
+	rps     float64
+	burst   int
+	// lastUsed is the Unix nanosecond time the limiter was last consulted
+	lastUsed atomic.Int64
+}
+
+// NewRateLimiter creates a new rate limiter. store backs distributed rate
+// limiting and may be nil, in which case limits are always per replica.
+func NewRateLimiter(configs *config.Holder, store DistributedStore) *RateLimiter {
 	return &RateLimiter{
-		config:   cfg,
-		limiters: make(map[string]*rate.Limiter),
+		config:    configs,
+		limiters:  make(map[string]*limiterEntry),
+		lastSweep: time.Now(),
+		store:     store,
+	}
+}
+
+// limiterKey identifies the limiter for a path and client key
+func limiterKey(path, client string) string {
+	if client == "" {
+		return path
+	}
+	return path + "#" + client
+}
+
+// limits returns the rate and burst for an endpoint, or the global defaults
+func limits(cfg *config.RateLimitConfig, endpointConfig *config.EndpointRateLimitConfig) (float64, int) {
+	if endpointConfig != nil {
+		return endpointConfig.RequestsPerSecond, endpointConfig.Burst
+	}
+	return cfg.RequestsPerSecond, cfg.Burst
+}
+
+// allow admits or rejects a request. With distributed limiting enabled the
+// shared Valkey budget is used, falling back to the local limiter while Valkey
+// is unavailable. An admitted request must wait result.Wait before proceeding;
+// cancel returns its token if it gives up first.
+func (rl *RateLimiter) allow(r *http.Request, cfg *config.RateLimitConfig, path, client string, endpointConfig *config.EndpointRateLimitConfig) (result cache.RateLimitResult, cancel func()) {
+	rps, burst := limits(cfg, endpointConfig)
+
+	if cfg.Distributed && rl.store != nil && time.Now().UnixNano() >= rl.storeDownUntil.Load() {
+		key := limiterKey(path, client)
+		ctx, cancelCtx := context.WithTimeout(r.Context(), distributedTimeout)
+		result, err := rl.store.AllowRequest(ctx, key, rps, burst, cfg.MaxWait())
+		cancelCtx()
+
+		if err == nil {
+			if rl.storeDown.CompareAndSwap(true, false) {
+				logger.Log.Info("Distributed rate limiter recovered, sharing budgets across replicas again")
+			}
+			if !result.Allowed {
+				return result, func() {}
+			}
+			return result, func() { rl.refund(key, rps) }
+		}
+
+		rl.storeDownUntil.Store(time.Now().Add(distributedRetryInterval).UnixNano())
+		if rl.storeDown.CompareAndSwap(false, true) {
+			logger.WithFields(map[string]interface{}{
+				"error":       err,
+				"retry_after": distributedRetryInterval.Seconds(),
+			}).Warn("Distributed rate limiter unavailable, falling back to per-replica limits")
+		}
+	}
+
+	limiter := rl.getLimiter(cfg, path, client, endpointConfig)
+	now := time.Now()
+	reservation := limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		// A zero burst admits nothing, ever
+		return cache.RateLimitResult{Wait: time.Second}, func() {}
+	}
+
+	wait := reservation.DelayFrom(now)
+	if wait > cfg.MaxWait() {
+		reservation.CancelAt(now)
+		tokens := limiter.TokensAt(now)
+		return cache.RateLimitResult{Wait: wait, Reset: fullIn(tokens, rps, burst)}, func() {}
+	}
+
+	tokens := limiter.TokensAt(now)
+	return cache.RateLimitResult{
+		Allowed:   true,
+		Remaining: max(int(math.Floor(tokens)), 0),
+		Wait:      wait,
+		Reset:     fullIn(tokens, rps, burst),
+	}, reservation.Cancel
+}
+
+// refund hands a shared slot back to the store. It runs after the request's
+// context is done, so it gets its own deadline.
+func (rl *RateLimiter) refund(key string, rps float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), distributedTimeout)
+	defer cancel()
+	if err := rl.store.RefundRequest(ctx, key, rps); err != nil {
+		logger.WithFields(map[string]interface{}{
+			"error": err,
+			"key":   key,
+		}).Warn("Failed to refund distributed rate limit slot")
 	}
 }
 
-// getLimiter returns the rate limiter for a specific path
-func (rl *RateLimiter) getLimiter(path string, endpointConfig *config.EndpointRateLimitConfig) *rate.Limiter {
+// fullIn returns how long a bucket holding tokens takes to refill to burst
+func fullIn(tokens, rps float64, burst int) time.Duration {
+	missing := float64(burst) - tokens
+	if missing <= 0 || rps <= 0 {
+		return 0
+	}
+	return time.Duration(missing / rps * float64(time.Second))
+}
+
+// ceilSeconds rounds a duration up to whole seconds for header values
+func ceilSeconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+// setRateLimitHeaders describes the client's remaining budget using the IETF
+// RateLimit header fields
+func setRateLimitHeaders(w http.ResponseWriter, burst int, result cache.RateLimitResult) {
+	w.Header().Set("RateLimit-Limit", strconv.Itoa(burst))
+	w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	w.Header().Set("RateLimit-Reset", ceilSeconds(result.Reset))
+}
+
+// getLimiter returns the rate limiter for a path and client key, rebuilding it
+// if its settings changed since it was created
+func (rl *RateLimiter) getLimiter(cfg *config.RateLimitConfig, path, client string, endpointConfig *config.EndpointRateLimitConfig) *rate.Limiter {
+	now := time.Now()
+	key := limiterKey(path, client)
+
+	// Use endpoint-specific config or default
+	rps, burst := limits(cfg, endpointConfig)
+
 	rl.mu.RLock()
-	limiter, exists := rl.limiters[path]
+	entry, exists := rl.limiters[key]
 	rl.mu.RUnlock()
 
-	if exists {
-		return limiter
+	if exists && entry.rps == rps && entry.burst == burst {
+		entry.lastUsed.Store(now.UnixNano())
+		return entry.limiter
 	}
 
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
 	// Double-check after acquiring write lock
-	if limiter, exists := rl.limiters[path]; exists {
-		return limiter
+	entry, exists = rl.limiters[key]
+	if exists && entry.rps == rps && entry.burst == burst {
+		entry.lastUsed.Store(now.UnixNano())
+		return entry.limiter
 	}
 
-	// Use endpoint-specific config or default
-	rps := rl.config.RequestsPerSecond
-	burst := rl.config.Burst
+	if exists {
+		logger.WithFields(map[string]interface{}{
+			"path":      path,
+			"old_rps":   entry.rps,
+			"old_burst": entry.burst,
+			"rps":       rps,
+			"burst":     burst,
+		}).Debug("Rebuilding rate limiter after configuration change")
+	}
 
-	if endpointConfig != nil {
-		rps = endpointConfig.RequestsPerSecond
-		burst = endpointConfig.Burst
+	entry = &limiterEntry{
+		limiter: rate.NewLimiter(rate.Limit(rps), burst),
+		rps:     rps,
+		burst:   burst,
 	}
+	entry.lastUsed.Store(now.UnixNano())
+	rl.limiters[key] = entry
 
-	limiter = rate.NewLimiter(rate.Limit(rps), burst)
-	rl.limiters[path] = limiter
+	// Evict idle limiters as new ones are added, so per-client keys cannot
+	// grow the map without bound
+	idleTimeout := cfg.LimiterIdleTimeout()
+	if now.Sub(rl.lastSweep) >= idleTimeout/2 {
+		rl.evictIdle(now, idleTimeout)
+		rl.lastSweep = now
+	}
 
-	return limiter
+	return entry.limiter
+}
+
+// evictIdle removes limiters unused for longer than idleTimeout. The caller must
+// hold the write lock.
+func (rl *RateLimiter) evictIdle(now time.Time, idleTimeout time.Duration) {
+	cutoff := now.Add(-idleTimeout).UnixNano()
+	evicted := 0
+	for key, entry := range rl.limiters {
+		if entry.lastUsed.Load() < cutoff {
+			delete(rl.limiters, key)
+			evicted++
+		}
+	}
+	if evicted > 0 {
+		logger.WithFields(map[string]interface{}{
+			"evicted":   evicted,
+			"remaining": len(rl.limiters),
+		}).Debug("Evicted idle rate limiters")
+	}
 }
 
 // Middleware returns a rate limiting middleware
-func (rl *RateLimiter) Middleware(cfg *config.Config) func(http.Handler) http.Handler {
+func (rl *RateLimiter) Middleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if !rl.config.Enabled {
+			cfg := rl.config.Get()
+			if !cfg.RateLimit.Enabled {
 				next.ServeHTTP(w, r)
 				return
 			}
 
 			endpointConfig := cfg.GetEndpointRateLimitConfig(r.URL.Path)
-			limiter := rl.getLimiter(r.URL.Path, endpointConfig)
+			client, identity := clientKey(r, &cfg.RateLimit, cfg.RateLimitKeyBy(endpointConfig))
+			result, cancel := rl.allow(r, &cfg.RateLimit, r.URL.Path, client, endpointConfig)
+			_, burst := limits(&cfg.RateLimit, endpointConfig)
+			setRateLimitHeaders(w, burst, result)
+
+			if !result.Allowed {
+				endpoint := "global"
+				if endpointConfig != nil {
+					endpoint = endpointConfig.EndpointIdentifier()
+				}
+				metrics.RecordRateLimitRejection(endpoint)
 
-			if !limiter.Allow() {
 				logger.WithFields(map[string]interface{}{
-					"path":   r.URL.Path,
-					"method": r.Method,
-					"remote": r.RemoteAddr,
+					"request_id":  GetRequestID(r.Context()),
+					"path":        r.URL.Path,
+					"method":      r.Method,
+					"remote":      r.RemoteAddr,
+					"client":      identity,
+					"retry_after": result.Wait.Seconds(),
 				}).Warn("Rate limit exceeded")
 
+				w.Header().Set("Retry-After", ceilSeconds(max(result.Wait, time.Second)))
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusTooManyRequests)
 				w.Write([]byte(`{"error":"rate limit exceeded","message":"too many requests"}`))
 				return
 			}
 
+			// In delay mode, hold the request until its token is available
+			if result.Wait > 0 {
+				logger.WithFields(map[string]interface{}{
+					"request_id": GetRequestID(r.Context()),
+					"path":       r.URL.Path,
+					"client":     identity,
+					"delay_ms":   result.Wait.Milliseconds(),
+				}).Debug("Delaying request to stay within rate limit")
+
+				timer := time.NewTimer(result.Wait)
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					timer.Stop()
+					cancel()
+					return
+				}
+			}
+
 			next.ServeHTTP(w, r)
 		})
 	}
@@ -0,0 +1,298 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// initLogger initializes logging once for every test in the package
+var initLogger sync.Once
+
+// loadConfig loads a minimal configuration extended with extra YAML, so that
+// trusted proxies and regexes are parsed as they are at startup
+func loadConfig(t *testing.T, extra string) *config.Config {
+	t.Helper()
+	initLogger.Do(func() { logger.Init(config.LoggingConfig{Level: "error"}) })
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	base := "server:\n  port: 8080\nvalkey:\n  port: 6379\nupstream:\n  base_url: http://localhost:9000\n"
+	if err := os.WriteFile(path, []byte(base+extra), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	return cfg
+}
+
+// fakeStore is a DistributedStore returning a fixed result or error
+type fakeStore struct {
+	mu      sync.Mutex
+	calls   int
+	refunds int
+	result  cache.RateLimitResult
+	err     error
+}
+
+func (s *fakeStore) AllowRequest(context.Context, string, float64, int, time.Duration) (cache.RateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return s.result, s.err
+}
+
+func (s *fakeStore) RefundRequest(context.Context, string, float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refunds++
+	return nil
+}
+
+func (s *fakeStore) set(result cache.RateLimitResult, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.result, s.err = result, err
+}
+
+func (s *fakeStore) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func TestGetLimiterRebuildsOnChange(t *testing.T) {
+	cfg := loadConfig(t, "rate_limit:\n  enabled: true\n  requests_per_second: 10\n  burst: 5\n")
+	rl := NewRateLimiter(config.NewHolder(cfg), nil)
+
+	limiter := rl.getLimiter(&cfg.RateLimit, "/items", "", nil)
+	if again := rl.getLimiter(&cfg.RateLimit, "/items", "", nil); again != limiter {
+		t.Error("unchanged settings should reuse the limiter")
+	}
+
+	cfg.RateLimit.Burst = 10
+	rebuilt := rl.getLimiter(&cfg.RateLimit, "/items", "", nil)
+	if rebuilt == limiter || rebuilt.Burst() != 10 {
+		t.Errorf("burst change: got burst %d, want a new limiter with burst 10", rebuilt.Burst())
+	}
+
+	cfg.RateLimit.RequestsPerSecond = 20
+	if again := rl.getLimiter(&cfg.RateLimit, "/items", "", nil); again == rebuilt || again.Limit() != 20 {
+		t.Errorf("rps change: got limit %v, want a new limiter with limit 20", again.Limit())
+	}
+}
+
+func TestGetLimiterEvictsIdle(t *testing.T) {
+	cfg := loadConfig(t, "rate_limit:\n  enabled: true\n  requests_per_second: 10\n  burst: 5\n  idle_timeout: 1m\n")
+	rl := NewRateLimiter(config.NewHolder(cfg), nil)
+
+	rl.getLimiter(&cfg.RateLimit, "/items", "idle", nil)
+	rl.getLimiter(&cfg.RateLimit, "/items", "recent", nil)
+	now := time.Now()
+	rl.limiters[limiterKey("/items", "idle")].lastUsed.Store(now.Add(-2 * time.Minute).UnixNano())
+	rl.limiters[limiterKey("/items", "recent")].lastUsed.Store(now.Add(-10 * time.Second).UnixNano())
+
+	// No sweep until half the idle timeout has passed since the last one
+	rl.getLimiter(&cfg.RateLimit, "/items", "new", nil)
+	if _, ok := rl.limiters[limiterKey("/items", "idle")]; !ok {
+		t.Fatal("idle limiter evicted before the sweep interval")
+	}
+
+	rl.lastSweep = now.Add(-time.Minute)
+	rl.getLimiter(&cfg.RateLimit, "/items", "newer", nil)
+	if _, ok := rl.limiters[limiterKey("/items", "idle")]; ok {
+		t.Error("idle limiter was not evicted")
+	}
+	for _, client := range []string{"recent", "new", "newer"} {
+		if _, ok := rl.limiters[limiterKey("/items", client)]; !ok {
+			t.Errorf("limiter for %q was evicted", client)
+		}
+	}
+}
+
+func TestDistributedFallsBackWhenStoreFails(t *testing.T) {
+	cfg := loadConfig(t, "rate_limit:\n  enabled: true\n  distributed: true\n  requests_per_second: 1\n  burst: 2\n")
+	store := &fakeStore{err: errors.New("connection refused")}
+	rl := NewRateLimiter(config.NewHolder(cfg), store)
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+
+	// The failure switches to this replica's own limiter
+	start := time.Now()
+	result, _ := rl.allow(r, &cfg.RateLimit, "/items", "", nil)
+	if store.callCount() != 1 || !result.Allowed || result.Remaining != 1 {
+		t.Fatalf("first request = %+v after %d store calls, want a local admission", result, store.callCount())
+	}
+	if downUntil := time.Unix(0, rl.storeDownUntil.Load()); downUntil.Before(start.Add(distributedRetryInterval)) {
+		t.Errorf("store retried at %v, want %v after the failure", downUntil, distributedRetryInterval)
+	}
+
+	// Within the retry window the store is skipped and local limits apply
+	if result, _ := rl.allow(r, &cfg.RateLimit, "/items", "", nil); !result.Allowed {
+		t.Errorf("second request = %+v, want admitted locally", result)
+	}
+	if result, _ := rl.allow(r, &cfg.RateLimit, "/items", "", nil); result.Allowed {
+		t.Errorf("request over the local burst = %+v, want rejected", result)
+	}
+	if got := store.callCount(); got != 1 {
+		t.Errorf("store called %d times during the retry window, want 1", got)
+	}
+
+	// Once the window has passed the store is used again
+	rl.storeDownUntil.Store(time.Now().UnixNano())
+	store.set(cache.RateLimitResult{Allowed: true, Remaining: 7}, nil)
+	result, _ = rl.allow(r, &cfg.RateLimit, "/items", "", nil)
+	if store.callCount() != 2 || result.Remaining != 7 {
+		t.Errorf("request after the retry window = %+v, want the store's result", result)
+	}
+	if rl.storeDown.Load() {
+		t.Error("store still marked down after it recovered")
+	}
+}
+
+// limited wraps a handler counting the requests that get through
+func limited(rl *RateLimiter, passed *atomic.Int32) http.Handler {
+	return rl.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestMiddlewareSetsRateLimitHeaders(t *testing.T) {
+	cfg := loadConfig(t, "rate_limit:\n  enabled: true\n  requests_per_second: 1\n  burst: 3\n")
+	var passed atomic.Int32
+	handler := limited(NewRateLimiter(config.NewHolder(cfg), nil), &passed)
+
+	tests := []struct {
+		status    int
+		remaining string
+		reset     string
+	}{
+		{status: http.StatusOK, remaining: "2", reset: "1"},
+		{status: http.StatusOK, remaining: "1", reset: "2"},
+		{status: http.StatusOK, remaining: "0", reset: "3"},
+		{status: http.StatusTooManyRequests, remaining: "0", reset: "3"},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+		if w.Code != tt.status {
+			t.Errorf("request %d: status = %d, want %d", i+1, w.Code, tt.status)
+		}
+		got := [3]string{w.Header().Get("RateLimit-Limit"), w.Header().Get("RateLimit-Remaining"), w.Header().Get("RateLimit-Reset")}
+		if want := [3]string{"3", tt.remaining, tt.reset}; got != want {
+			t.Errorf("request %d: RateLimit headers (limit, remaining, reset) = %v, want %v", i+1, got, want)
+		}
+	}
+	if got := passed.Load(); got != 3 {
+		t.Errorf("%d requests reached the handler, want 3", got)
+	}
+}
+
+func TestMiddlewareRejectsWithRetryAfter(t *testing.T) {
+	cfg := loadConfig(t, "rate_limit:\n  enabled: true\n  requests_per_second: 0.5\n  burst: 1\n")
+	var passed atomic.Int32
+	handler := limited(NewRateLimiter(config.NewHolder(cfg), nil), &passed)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items", nil))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Retry-After = %q, want 2", got)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", got)
+	}
+	if got := passed.Load(); got != 1 {
+		t.Errorf("%d requests reached the handler, want 1", got)
+	}
+}
+
+func TestMiddlewareDelayMode(t *testing.T) {
+	cfg := loadConfig(t, "rate_limit:\n  enabled: true\n  requests_per_second: 2\n  burst: 1\n  mode: delay\n  max_delay: 600ms\n")
+	var passed atomic.Int32
+	handler := limited(NewRateLimiter(config.NewHolder(cfg), nil), &passed)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items", nil))
+
+	// The next token is 500ms away, within max_delay, so the request is held
+	delayed := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("delayed request status = %d, want %d", w.Code, http.StatusOK)
+		}
+		delayed <- time.Since(start)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// The one after that would wait about a second, past max_delay
+	start := time.Now()
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil))
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("request beyond max_delay: status = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("request beyond max_delay held for %v, want an immediate rejection", elapsed)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want 1", got)
+	}
+
+	if elapsed := <-delayed; elapsed < 400*time.Millisecond || elapsed > 600*time.Millisecond {
+		t.Errorf("delayed request held for %v, want about 500ms", elapsed)
+	}
+	if got := passed.Load(); got != 2 {
+		t.Errorf("%d requests reached the handler, want 2", got)
+	}
+}
+
+func TestMiddlewareDistributedDelayCancel(t *testing.T) {
+	cfg := loadConfig(t, "rate_limit:\n  enabled: true\n  distributed: true\n  requests_per_second: 1\n  burst: 5\n  mode: delay\n")
+	store := &fakeStore{result: cache.RateLimitResult{Allowed: true, Remaining: 4, Wait: time.Second, Reset: 2 * time.Second}}
+	rl := NewRateLimiter(config.NewHolder(cfg), store)
+	var passed atomic.Int32
+	handler := limited(rl, &passed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	w := httptest.NewRecorder()
+	start := time.Now()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/items", nil).WithContext(ctx))
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("cancelled request held for %v, want it released on cancel", elapsed)
+	}
+	if got := passed.Load(); got != 0 {
+		t.Errorf("%d cancelled requests reached the handler, want 0", got)
+	}
+	if got := w.Header().Get("RateLimit-Remaining"); got != "4" {
+		t.Errorf("RateLimit-Remaining = %q, want the store's 4", got)
+	}
+	// The shared slot is handed back, without touching a local limiter
+	store.mu.Lock()
+	refunds := store.refunds
+	store.mu.Unlock()
+	if store.callCount() != 1 || refunds != 1 || len(rl.limiters) != 0 {
+		t.Errorf("store calls = %d, refunds = %d, local limiters = %d, want 1, 1, and 0", store.callCount(), refunds, len(rl.limiters))
+	}
+}
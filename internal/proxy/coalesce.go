@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// peerPollInterval is how often a replica waiting on another replica's fetch
+// checks whether the entry has landed in cache.
+const peerPollInterval = 50 * time.Millisecond
+
+// flightCall is an in-progress upstream fetch shared by concurrent requests
+type flightCall struct {
+	done chan struct{}
+	res  *upstreamResult
+	err  error
+}
+
+// flightGroup collapses concurrent fetches for the same cache key into one
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// join returns the in-flight call for key, creating it if none exists.
+// The second return value is true if the caller is the leader and must
+// complete the call with finish.
+func (g *flightGroup) join(key string) (*flightCall, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if call, ok := g.calls[key]; ok {
+		return call, false
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	return call, true
+}
+
+// finish publishes the leader's result to all waiters and forgets the call
+func (g *flightGroup) finish(key string, call *flightCall, res *upstreamResult, err error) {
+	call.res = res
+	call.err = err
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+
+	close(call.done)
+}
+
+// coalesceAndCache serves a cache miss, sharing a single upstream fetch among all
+// concurrent requests for the same cache key
+func (h *Handler) coalesceAndCache(w http.ResponseWriter, r *http.Request, ctx context.Context, cfg *config.Config, cacheKey string, endpointConfig *config.EndpointCacheConfig, match config.EndpointMatch, stale *cache.CachedResponse, requestID string, startTime time.Time) {
+	call, leader := h.flights.join(cacheKey)
+	if leader {
+		// Detach from the leader's cancellation: waiters depend on this fetch
+		// even if the leader's client goes away. The upstream timeout still applies.
+		res, err := h.fetchCoalesced(r, context.WithoutCancel(ctx), cfg, cacheKey, endpointConfig, match, stale, requestID)
+		h.flights.finish(cacheKey, call, res, err)
+		if h.serveStaleOnError(w, r, res, err, stale, cacheKey, endpointConfig, match, requestID, startTime) {
+			return
+		}
+		if err != nil {
+			writeFetchError(w, err)
+			return
+		}
+		h.writeUpstreamResult(w, r, res, res.cacheStatus(), cacheKey, match, requestID, startTime)
+		return
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"request_id": requestID,
+		"cache_key":  cacheKey,
+		"path":       r.URL.Path,
+		"query":      h.sanitizeQuery(r),
+	}).Debug("Waiting on in-flight upstream fetch")
+
+	waitTimeout := cfg.Cache.Coalescing.WaitTimeout
+	timer := time.NewTimer(waitTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-call.done:
+		if h.serveStaleOnError(w, r, call.res, call.err, stale, cacheKey, endpointConfig, match, requestID, startTime) {
+			return
+		}
+		if call.err != nil {
+			writeFetchError(w, call.err)
+			return
+		}
+		// The shared response may be a different Vary variant than this request needs
+		if !h.sharedResultApplies(r, cfg, cacheKey, call.res) {
+			h.forwardAndCache(w, r, ctx, cfg, cacheKey, endpointConfig, match, stale, requestID, startTime)
+			return
+		}
+		h.writeUpstreamResult(w, r, call.res, "COALESCED", cacheKey, match, requestID, startTime)
+	case <-timer.C:
+		logger.WithFields(map[string]interface{}{
+			"request_id":   requestID,
+			"cache_key":    cacheKey,
+			"path":         r.URL.Path,
+			"wait_timeout": waitTimeout.Seconds(),
+		}).Warn("Timed out waiting on in-flight fetch, fetching independently")
+		h.forwardAndCache(w, r, ctx, cfg, cacheKey, endpointConfig, match, stale, requestID, startTime)
+	case <-ctx.Done():
+	}
+}
+
+// fetchCoalesced performs the leader's fetch. With distributed coalescing enabled,
+// it first takes the Valkey fetch lock; if another replica holds it, it waits for
+// that replica's result to appear in cache instead of fetching.
+func (h *Handler) fetchCoalesced(r *http.Request, ctx context.Context, cfg *config.Config, cacheKey string, endpointConfig *config.EndpointCacheConfig, match config.EndpointMatch, stale *cache.CachedResponse, requestID string) (*upstreamResult, error) {
+	coalescing := cfg.Cache.Coalescing
+	if !coalescing.Distributed {
+		return h.fetchAndCache(r, ctx, cfg, cacheKey, endpointConfig, match, stale, requestID)
+	}
+
+	token, acquired, err := h.cache.AcquireLock(ctx, cacheKey, coalescing.LockTTL)
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"request_id": requestID,
+			"error":      err,
+			"cache_key":  cacheKey,
+		}).Warn("Failed to acquire fetch lock, fetching without it")
+		return h.fetchAndCache(r, ctx, cfg, cacheKey, endpointConfig, match, stale, requestID)
+	}
+
+	if acquired {
+		defer func() {
+			if err := h.cache.ReleaseLock(ctx, cacheKey, token); err != nil {
+				logger.WithFields(map[string]interface{}{
+					"request_id": requestID,
+					"error":      err,
+					"cache_key":  cacheKey,
+				}).Warn("Failed to release fetch lock")
+			}
+		}()
+		return h.fetchAndCache(r, ctx, cfg, cacheKey, endpointConfig, match, stale, requestID)
+	}
+
+	if res := h.waitForPeer(ctx, cfg, cacheKey, requestID); res != nil {
+		return res, nil
+	}
+	return h.fetchAndCache(r, ctx, cfg, cacheKey, endpointConfig, match, stale, requestID)
+}
+
+// waitForPeer polls the cache until another replica's fetch lands, its lock is
+// released, or the wait timeout elapses. It returns nil if no entry appeared.
+func (h *Handler) waitForPeer(ctx context.Context, cfg *config.Config, cacheKey, requestID string) *upstreamResult {
+	deadline := time.Now().Add(cfg.Cache.Coalescing.WaitTimeout)
+	ticker := time.NewTicker(peerPollInterval)
+	defer ticker.Stop()
+
+	logger.WithFields(map[string]interface{}{
+		"request_id": requestID,
+		"cache_key":  cacheKey,
+	}).Debug("Fetch lock held by another replica, waiting for its result")
+
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// Check the lock before the entry: the peer caches before releasing,
+		// so a released lock followed by a miss means it cached nothing.
+		locked, lockErr := h.cache.IsLocked(ctx, cacheKey)
+
+		cached, err := h.cache.Get(ctx, cacheKey)
+		if err == nil && cached != nil && cached.IsFresh(time.Now()) {
+			body, err := cached.DecodedBody()
+			if err != nil {
+				break
+			}
+			return &upstreamResult{
+				StatusCode: cached.StatusCode,
+				Header:     cached.Headers,
+				Body:       body,
+				Cached:     true,
+				StoreKey:   cacheKey,
+				TTL:        time.Until(cached.FreshUntil),
+				FromPeer:   true,
+			}
+		}
+
+		// The peer finished without caching (e.g. non-2xx) or its lock expired
+		if lockErr == nil && !locked {
+			break
+		}
+	}
+
+	logger.WithFields(map[string]interface{}{
+		"request_id": requestID,
+		"cache_key":  cacheKey,
+	}).Debug("No result from peer replica, fetching independently")
+	return nil
+}
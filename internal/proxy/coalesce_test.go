@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+func TestFlightGroup(t *testing.T) {
+	g := newFlightGroup()
+
+	call, leader := g.join("key")
+	if !leader {
+		t.Fatal("first join should lead")
+	}
+	waiter, leader := g.join("key")
+	if leader || waiter != call {
+		t.Fatal("second join should wait on the first call")
+	}
+	if _, leader := g.join("other"); !leader {
+		t.Error("join for another key should lead")
+	}
+
+	res := &upstreamResult{StatusCode: http.StatusOK}
+	fetchErr := errors.New("upstream down")
+	g.finish("key", call, res, fetchErr)
+
+	select {
+	case <-waiter.done:
+	default:
+		t.Fatal("finish did not release waiters")
+	}
+	if waiter.res != res || waiter.err != fetchErr {
+		t.Errorf("waiter got (%v, %v), want the leader's result", waiter.res, waiter.err)
+	}
+	if _, leader := g.join("key"); !leader {
+		t.Error("join after finish should start a new call")
+	}
+}
+
+func TestCoalescingFetchesOnce(t *testing.T) {
+	upstream := newCountingUpstream(t, true)
+	cfg := &config.Config{}
+	cfg.Cache.Coalescing = config.CoalescingConfig{Enabled: true, WaitTimeout: 5 * time.Second}
+	h, _ := newTestHandler(t, cfg, upstream.URL)
+
+	const clients = 8
+	responses := make([]*httptest.ResponseRecorder, clients)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i] = serve(h, http.MethodGet, "/items", nil)
+		}()
+	}
+
+	// Let every request join the leader's fetch before it completes
+	<-upstream.arrived
+	time.Sleep(100 * time.Millisecond)
+	close(upstream.gate)
+	wg.Wait()
+
+	if got := upstream.calls.Load(); got != 1 {
+		t.Errorf("upstream called %d times, want 1", got)
+	}
+	statuses := map[string]int{}
+	for _, w := range responses {
+		statuses[w.Header().Get("X-Cache")]++
+		if w.Code != http.StatusOK || w.Body.String() != "body for /items" {
+			t.Errorf("response = %d %q, want the upstream body", w.Code, w.Body.String())
+		}
+	}
+	if statuses["MISS"] != 1 || statuses["COALESCED"] != clients-1 {
+		t.Errorf("X-Cache values = %v, want one MISS and %d COALESCED", statuses, clients-1)
+	}
+}
+
+func TestCoalescingWaiterFallsBackOnTimeout(t *testing.T) {
+	upstream := newCountingUpstream(t, true)
+	cfg := &config.Config{}
+	cfg.Cache.Coalescing = config.CoalescingConfig{Enabled: true, WaitTimeout: 20 * time.Millisecond}
+	h, _ := newTestHandler(t, cfg, upstream.URL)
+
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		serve(h, http.MethodGet, "/slow", nil)
+	}()
+	<-upstream.arrived
+
+	// The leader is stuck upstream, so the waiter gives up and fetches itself
+	w := serve(h, http.MethodGet, "/slow", nil)
+	if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("waiter response = %d X-Cache %q, want its own MISS", w.Code, w.Header().Get("X-Cache"))
+	}
+	if got := upstream.calls.Load(); got != 2 {
+		t.Errorf("upstream called %d times, want 2", got)
+	}
+
+	close(upstream.gate)
+	<-leaderDone
+}
+
+func TestDistributedCoalescingWaitsForPeer(t *testing.T) {
+	upstream := newCountingUpstream(t, false)
+	cfg := &config.Config{}
+	cfg.Cache.Coalescing = config.CoalescingConfig{
+		Enabled:     true,
+		WaitTimeout: 5 * time.Second,
+		Distributed: true,
+		LockTTL:     5 * time.Second,
+	}
+	h, _ := newTestHandler(t, cfg, upstream.URL)
+
+	// Another replica holds the fetch lock and caches its result shortly
+	ctx := context.Background()
+	key := h.cache.GenerateCacheKey(httptest.NewRequest(http.MethodGet, "/shared", nil), config.DefaultUpstreamName, nil)
+	token, acquired, err := h.cache.AcquireLock(ctx, key, time.Minute)
+	if err != nil || !acquired {
+		t.Fatalf("AcquireLock() = %v, %v", acquired, err)
+	}
+	go func() {
+		time.Sleep(2 * peerPollInterval)
+		now := time.Now()
+		h.cache.Set(ctx, key, &cache.CachedResponse{
+			StatusCode: http.StatusOK,
+			Headers:    http.Header{"Content-Type": {"text/plain"}},
+			Body:       []byte("from peer"),
+			CachedAt:   now,
+			FreshUntil: now.Add(time.Minute),
+		}, time.Minute)
+		h.cache.ReleaseLock(ctx, key, token)
+	}()
+
+	w := serve(h, http.MethodGet, "/shared", nil)
+	if w.Body.String() != "from peer" || w.Header().Get("X-Cache") != "COALESCED" {
+		t.Errorf("response = %q X-Cache %q, want the peer's COALESCED result", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+	if got := upstream.calls.Load(); got != 0 {
+		t.Errorf("upstream called %d times, want 0 while the peer fetched", got)
+	}
+}
+
+func TestSharedResultAppliesToSameVariant(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Cache.RespectUpstreamCacheHeaders = true
+	h := &Handler{config: config.NewHolder(cfg)}
+
+	vary := []string{"Accept-Language"}
+	english := http.Header{"Accept-Language": {"en"}}
+	res := &upstreamResult{
+		Header:   http.Header{"Vary": vary},
+		StoreKey: cache.VariantKey("cache:base", english, vary),
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/greeting", nil)
+	r.Header.Set("Accept-Language", "en")
+	if !h.sharedResultApplies(r, h.config.Get(), "cache:base", res) {
+		t.Error("result should apply to a request for the same variant")
+	}
+	r.Header.Set("Accept-Language", "fr")
+	if h.sharedResultApplies(r, h.config.Get(), "cache:base", res) {
+		t.Error("result should not apply to a request for another variant")
+	}
+
+	cfg.Cache.RespectUpstreamCacheHeaders = false
+	if !h.sharedResultApplies(r, h.config.Get(), "cache:base", res) {
+		t.Error("results always apply when upstream cache headers are ignored")
+	}
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+)
+
+// conditionalRequestHeaders are the client preconditions stripped from cacheable
+// upstream requests, so the cache always receives a full response to store
+var conditionalRequestHeaders = []string{"If-None-Match", "If-Modified-Since"}
+
+// writeNotModified answers a conditional GET with 304 Not Modified when the
+// client's validators match the response about to be served. The response headers
+// already set on w are kept except for representation metadata, which a 304 must
+// not carry. It returns true if a response was written.
+func writeNotModified(w http.ResponseWriter, r *http.Request, status int) bool {
+	if status != http.StatusOK || !cache.IsNotModified(r.Header, w.Header()) {
+		return false
+	}
+
+	for name := range w.Header() {
+		if strings.HasPrefix(name, "Content-") && name != "Content-Location" {
+			w.Header().Del(name)
+		}
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// setRevalidationHeaders replaces the client's preconditions on an upstream
+// request with the validators of the stale entry being revalidated, if any.
+// An ETag the cache generated is not sent, since the upstream could never
+// match it.
+func setRevalidationHeaders(header http.Header, stale *cache.CachedResponse) {
+	for _, name := range conditionalRequestHeaders {
+		header.Del(name)
+	}
+	if stale == nil {
+		return
+	}
+	staleHeader := http.Header(stale.Headers)
+	if etag := staleHeader.Get("Etag"); etag != "" && !stale.GeneratedETag {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := staleHeader.Get("Last-Modified"); lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+}
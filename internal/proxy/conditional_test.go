@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+// validatorUpstream answers with ETag etag, or none if empty, and with 304
+// when a request's If-None-Match matches it. It records the preconditions of
+// every request.
+type validatorUpstream struct {
+	*httptest.Server
+	mu          sync.Mutex
+	ifNoneMatch []string
+}
+
+func newValidatorUpstream(t *testing.T, etag string) *validatorUpstream {
+	t.Helper()
+	u := &validatorUpstream{}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u.mu.Lock()
+		u.ifNoneMatch = append(u.ifNoneMatch, r.Header.Get("If-None-Match"))
+		u.mu.Unlock()
+
+		if etag != "" {
+			w.Header().Set("Etag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Write([]byte("body for " + r.URL.Path))
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// preconditions returns the If-None-Match of every request so far
+func (u *validatorUpstream) preconditions() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return append([]string(nil), u.ifNoneMatch...)
+}
+
+func TestClientConditionalFromCache(t *testing.T) {
+	upstream := newCountingUpstream(t, false)
+	h, _ := newTestHandler(t, &config.Config{}, upstream.URL)
+	storeEntry(t, h, "/items", "cached", time.Minute)
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		want        int
+		wantBody    string
+	}{
+		{name: "matching", ifNoneMatch: `"v1"`, want: http.StatusNotModified},
+		{name: "one of several", ifNoneMatch: `"v0", "v1"`, want: http.StatusNotModified},
+		{name: "different", ifNoneMatch: `"v2"`, want: http.StatusOK, wantBody: "cached"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(h, http.MethodGet, "/items", http.Header{"If-None-Match": {tt.ifNoneMatch}})
+			if w.Code != tt.want || w.Body.String() != tt.wantBody {
+				t.Errorf("response = %d %q, want %d %q", w.Code, w.Body.String(), tt.want, tt.wantBody)
+			}
+			if w.Header().Get("X-Cache") != "HIT" || w.Header().Get("Etag") != `"v1"` {
+				t.Errorf("X-Cache %q ETag %s, want a HIT with the entry's ETag", w.Header().Get("X-Cache"), w.Header().Get("Etag"))
+			}
+		})
+	}
+	if got := upstream.calls.Load(); got != 0 {
+		t.Errorf("upstream called %d times, want 0", got)
+	}
+}
+
+func TestUpstreamNotModifiedRevalidates(t *testing.T) {
+	upstream := newValidatorUpstream(t, `"v1"`)
+	h, _ := newTestHandler(t, &config.Config{}, upstream.URL)
+	key := storeEntry(t, h, "/items", "cached", -10*time.Second)
+
+	w := serve(h, http.MethodGet, "/items", nil)
+	if w.Header().Get("X-Cache") != "REVALIDATED" || w.Body.String() != "cached" {
+		t.Fatalf("response = X-Cache %q %q, want the stale entry revalidated", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if got := upstream.preconditions(); len(got) != 1 || got[0] != `"v1"` {
+		t.Errorf("upstream If-None-Match = %q, want the entry's ETag", got)
+	}
+
+	// The entry is fresh again without being downloaded
+	cached, _ := h.cache.Peek(context.Background(), key)
+	if cached == nil || !cached.IsFresh(time.Now()) || string(cached.Body) != "cached" {
+		t.Errorf("entry after revalidation = %+v, want the same body, fresh", cached)
+	}
+	if w := serve(h, http.MethodGet, "/items", nil); w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("next response X-Cache = %q, want HIT", w.Header().Get("X-Cache"))
+	}
+}
+
+func TestGeneratedETagNotSentUpstream(t *testing.T) {
+	upstream := newValidatorUpstream(t, "")
+	h, _ := newTestHandler(t, &config.Config{}, upstream.URL)
+
+	w := serve(h, http.MethodGet, "/items", nil)
+	if w.Header().Get("Etag") == "" {
+		t.Fatal("a response stored without an upstream ETag should get one")
+	}
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+	key := h.cache.GenerateCacheKey(r, config.DefaultUpstreamName, nil)
+	cached, _ := h.cache.Peek(context.Background(), key)
+	if cached == nil || !cached.GeneratedETag {
+		t.Fatalf("entry = %+v, want its ETag marked as generated", cached)
+	}
+
+	// Revalidating the entry once stale must not send the generated ETag
+	cached.FreshUntil = time.Now().Add(-time.Second)
+	if err := h.cache.Set(context.Background(), key, cached, time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	if w := serve(h, http.MethodGet, "/items", nil); w.Header().Get("X-Cache") != "MISS" {
+		t.Errorf("X-Cache = %q, want MISS", w.Header().Get("X-Cache"))
+	}
+	if got := upstream.preconditions(); len(got) != 2 || got[1] != "" {
+		t.Errorf("upstream If-None-Match = %q, want none on revalidation", got)
+	}
+}
+
+func TestSetRevalidationHeaders(t *testing.T) {
+	lastModified := "Fri, 15 Mar 2024 12:00:00 GMT"
+	tests := []struct {
+		name         string
+		stale        *cache.CachedResponse
+		wantETag     string
+		wantModified string
+	}{
+		{name: "no stale entry"},
+		{
+			name:         "upstream validators",
+			stale:        &cache.CachedResponse{Headers: http.Header{"Etag": {`"v1"`}, "Last-Modified": {lastModified}}},
+			wantETag:     `"v1"`,
+			wantModified: lastModified,
+		},
+		{
+			name:         "generated etag",
+			stale:        &cache.CachedResponse{Headers: http.Header{"Etag": {`"gen"`}, "Last-Modified": {lastModified}}, GeneratedETag: true},
+			wantModified: lastModified,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The client's own preconditions are always replaced
+			header := http.Header{"If-None-Match": {`"client"`}, "If-Modified-Since": {"yesterday"}}
+			setRevalidationHeaders(header, tt.stale)
+			if got := header.Get("If-None-Match"); got != tt.wantETag {
+				t.Errorf("If-None-Match = %q, want %q", got, tt.wantETag)
+			}
+			if got := header.Get("If-Modified-Since"); got != tt.wantModified {
+				t.Errorf("If-Modified-Since = %q, want %q", got, tt.wantModified)
+			}
+		})
+	}
+}
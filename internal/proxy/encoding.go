@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+)
+
+// negotiateEncoding picks the representation of a cached response to send. Bodies
+// compressed at rest are sent as-is to clients that accept the coding and
+// decompressed for those that don't. It sets Content-Encoding, Vary, and the
+// representation's ETag on w and reports whether the body must be decoded; the
+// cached headers must already have been copied.
+func negotiateEncoding(w http.ResponseWriter, r *http.Request, cached *cache.CachedResponse) bool {
+	if cached.Encoding == "" {
+		return false
+	}
+
+	addVary(w.Header(), "Accept-Encoding")
+
+	if acceptsEncoding(r.Header, cached.Encoding) {
+		w.Header().Set("Content-Encoding", cached.Encoding)
+		if etag := w.Header().Get("Etag"); etag != "" {
+			w.Header().Set("Etag", encodedETag(etag, cached.Encoding))
+		}
+		return false
+	}
+
+	w.Header().Del("Content-Encoding")
+	return true
+}
+
+// encodedETag derives the entity tag of a compressed representation from the tag
+// of the identity representation, so the two are never confused by validators
+func encodedETag(etag, encoding string) string {
+	if !strings.HasSuffix(etag, `"`) || len(etag) < 2 {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding allows a content
+// coding, honoring q=0 exclusions and the "*" wildcard
+func acceptsEncoding(header http.Header, encoding string) bool {
+	wildcard := false
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != encoding && name != "*" {
+				continue
+			}
+
+			acceptable := true
+			if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					acceptable = false
+				}
+			}
+			if name == encoding {
+				return acceptable
+			}
+			wildcard = acceptable
+		}
+	}
+	return wildcard
+}
+
+// addVary adds a header name to Vary unless it is already listed
+func addVary(header http.Header, name string) {
+	for _, v := range header.Values("Vary") {
+		for _, existing := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
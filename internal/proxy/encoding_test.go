@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		name   string
+		accept []string
+		want   bool
+	}{
+		{name: "no header is identity only", want: false},
+		{name: "listed", accept: []string{"br, gzip"}, want: true},
+		{name: "case-insensitive", accept: []string{"GZIP"}, want: true},
+		{name: "positive q", accept: []string{"gzip; q=0.5"}, want: true},
+		{name: "q=0 excludes", accept: []string{"gzip;q=0"}, want: false},
+		{name: "identity", accept: []string{"identity"}, want: false},
+		{name: "other coding", accept: []string{"br"}, want: false},
+		{name: "wildcard", accept: []string{"*"}, want: true},
+		{name: "wildcard q=0", accept: []string{"*;q=0"}, want: false},
+		{name: "explicit q=0 beats wildcard", accept: []string{"*, gzip;q=0"}, want: false},
+		{name: "explicit beats wildcard q=0", accept: []string{"*;q=0", "gzip"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"Accept-Encoding": tt.accept}
+			if got := acceptsEncoding(header, cache.EncodingGzip); got != tt.want {
+				t.Errorf("acceptsEncoding(%q) = %v, want %v", tt.accept, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodedETag(t *testing.T) {
+	tests := []struct{ etag, want string }{
+		{`"abc"`, `"abc-gzip"`},
+		{`W/"abc"`, `W/"abc-gzip"`},
+		{`abc`, `abc`},
+		{`"`, `"`},
+	}
+	for _, tt := range tests {
+		if got := encodedETag(tt.etag, cache.EncodingGzip); got != tt.want {
+			t.Errorf("encodedETag(%s) = %s, want %s", tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	header := http.Header{"Vary": {"Accept, accept-encoding"}}
+	addVary(header, "Accept-Encoding")
+	if got := header.Values("Vary"); len(got) != 1 {
+		t.Errorf("Vary = %q, want Accept-Encoding not repeated", got)
+	}
+	addVary(header, "Authorization")
+	if got := header.Values("Vary"); len(got) != 2 || got[1] != "Authorization" {
+		t.Errorf("Vary = %q, want Authorization added", got)
+	}
+}
+
+// decode reverses a content coding
+func decode(t *testing.T, body []byte, encoding string) string {
+	t.Helper()
+	var decoded []byte
+	var err error
+	switch encoding {
+	case cache.EncodingGzip:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(bytes.NewReader(body)); err == nil {
+			decoded, err = io.ReadAll(zr)
+		}
+	case cache.EncodingZstd:
+		var dec *zstd.Decoder
+		if dec, err = zstd.NewReader(nil); err == nil {
+			decoded, err = dec.DecodeAll(body, nil)
+			dec.Close()
+		}
+	}
+	if err != nil {
+		t.Fatalf("failed to decode %s body: %v", encoding, err)
+	}
+	return string(decoded)
+}
+
+func TestServeCompressedEntry(t *testing.T) {
+	body := strings.Repeat("compressible payload ", 100)
+
+	for _, encoding := range []string{cache.EncodingGzip, cache.EncodingZstd} {
+		tests := []struct {
+			name       string
+			accept     string
+			wantCoding string
+			wantETag   string
+		}{
+			{name: "accepted", accept: "br, " + encoding, wantCoding: encoding, wantETag: `"v1-` + encoding + `"`},
+			{name: "not accepted", accept: "br", wantETag: `"v1"`},
+			{name: "no accept-encoding", wantETag: `"v1"`},
+		}
+
+		for _, tt := range tests {
+			t.Run(encoding+" "+tt.name, func(t *testing.T) {
+				cfg := &config.Config{}
+				cfg.Cache.Compression = config.CompressionConfig{Enabled: true, Algorithm: encoding, MinSize: 1}
+				h, _ := newTestHandler(t, cfg, "http://unused.invalid")
+				storeEntry(t, h, "/items", body, time.Minute)
+
+				header := http.Header{}
+				if tt.accept != "" {
+					header.Set("Accept-Encoding", tt.accept)
+				}
+				w := serve(h, http.MethodGet, "/items", header)
+
+				if got := w.Header().Get("Content-Encoding"); got != tt.wantCoding {
+					t.Errorf("Content-Encoding = %q, want %q", got, tt.wantCoding)
+				}
+				if got := w.Header().Get("Etag"); got != tt.wantETag {
+					t.Errorf("ETag = %s, want %s", got, tt.wantETag)
+				}
+				if got := w.Header().Values("Vary"); len(got) != 1 || got[0] != "Accept-Encoding" {
+					t.Errorf("Vary = %q, want Accept-Encoding", got)
+				}
+
+				got := w.Body.String()
+				if tt.wantCoding != "" {
+					got = decode(t, w.Body.Bytes(), encoding)
+				}
+				if got != body {
+					t.Errorf("body = %.40q..., want the stored body", got)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/balancer"
+	"github.com/singh-gur/api_cache/internal/breaker"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/quota"
+)
+
+// serviceInfo identifies the running build in health responses
+type serviceInfo struct {
+	Service       string  `json:"service"`
+	Version       string  `json:"version"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+// serviceInfo returns the build version and uptime of the handler
+func (h *Handler) serviceInfo(version string) serviceInfo {
+	return serviceInfo{
+		Service:       "api-cache",
+		Version:       version,
+		UptimeSeconds: time.Since(h.started).Seconds(),
+	}
+}
+
+// healthResponse is the body of the health check
+type healthResponse struct {
+	Status string `json:"status"`
+	serviceInfo
+	CircuitBreakers map[string]breaker.Status `json:"circuit_breakers,omitempty"`
+	// Upstreams reports each upstream target, keyed by upstream name and
+	// target URL
+	Upstreams map[string]map[string]balancer.TargetStatus `json:"upstreams,omitempty"`
+}
+
+// Health returns a health check handler. The service reports itself degraded,
+// but still healthy enough to serve traffic, while any circuit breaker is not
+// closed or any upstream target is unhealthy or ejected. It does not check
+// dependencies; use Readyz for that.
+func (h *Handler) Health(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp := healthResponse{
+			Status:          "healthy",
+			serviceInfo:     h.serviceInfo(version),
+			CircuitBreakers: h.breakers.Statuses(),
+			Upstreams:       h.balancer.Statuses(),
+		}
+		if degraded(resp.CircuitBreakers, resp.Upstreams) {
+			resp.Status = "degraded"
+		}
+		writeHealth(w, http.StatusOK, resp)
+	}
+}
+
+// degraded reports whether any circuit breaker is not closed or any upstream
+// target is unhealthy or ejected
+func degraded(breakers map[string]breaker.Status, upstreams map[string]map[string]balancer.TargetStatus) bool {
+	for _, status := range breakers {
+		if status.State != breaker.StateClosed {
+			return true
+		}
+	}
+	for _, targets := range upstreams {
+		for _, status := range targets {
+			if !status.Healthy || status.Ejected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// livenessResponse is the body of the liveness check
+type livenessResponse struct {
+	Status string `json:"status"`
+	serviceInfo
+}
+
+// Livez returns a liveness handler. It answers 200 as long as the process can
+// serve requests, whatever the state of its dependencies, so an orchestrator
+// only restarts the pod when it is wedged.
+func (h *Handler) Livez(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, livenessResponse{
+			Status:      "alive",
+			serviceInfo: h.serviceInfo(version),
+		})
+	}
+}
+
+// Dependency check results
+const (
+	checkUp   = "up"
+	checkDown = "down"
+)
+
+// checkResult is the outcome of one dependency check
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// readinessResponse is the body of the readiness check
+type readinessResponse struct {
+	Status string `json:"status"`
+	serviceInfo
+	// Checks holds the dependencies readiness requires, keyed by "valkey" or
+	// "upstream:<name>"
+	Checks map[string]checkResult `json:"checks"`
+	// CircuitBreakers, Quotas, and Upstreams are informational: an open
+	// breaker or an exhausted quota is served from cache and does not make
+	// the pod unready. Quotas are keyed by upstream name.
+	CircuitBreakers map[string]breaker.Status                   `json:"circuit_breakers,omitempty"`
+	Quotas          map[string]quota.Status                     `json:"quotas,omitempty"`
+	Upstreams       map[string]map[string]balancer.TargetStatus `json:"upstreams,omitempty"`
+}
+
+// Readyz returns a readiness handler. It pings Valkey and, when
+// health.probe_upstreams is set, each upstream, and answers 503 with the
+// result of every check if any fails, so traffic is routed away from the pod.
+func (h *Handler) Readyz(version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cfg := h.config.Get()
+		timeout := cfg.Health.CheckTimeout()
+
+		checks := map[string]func(ctx context.Context) error{
+			"valkey": h.cache.Ping,
+		}
+		if cfg.Health.ProbeUpstreams {
+			for _, up := range cfg.AllUpstreams() {
+				path := cfg.Health.UpstreamProbePath(up)
+				checks["upstream:"+up.Name] = func(ctx context.Context) error {
+					return h.probeUpstream(ctx, up, path)
+				}
+			}
+		}
+
+		resp := readinessResponse{
+			Status:          "ready",
+			serviceInfo:     h.serviceInfo(version),
+			Checks:          runChecks(r.Context(), checks, timeout),
+			CircuitBreakers: h.breakers.Statuses(),
+			Quotas:          h.quota.Statuses(),
+			Upstreams:       h.balancer.Statuses(),
+		}
+
+		code := http.StatusOK
+		for name, check := range resp.Checks {
+			if check.Status != checkUp {
+				resp.Status = "not_ready"
+				code = http.StatusServiceUnavailable
+				logger.WithFields(map[string]interface{}{
+					"check": name,
+					"error": check.Error,
+				}).Warn("Readiness check failed")
+			}
+		}
+		writeHealth(w, code, resp)
+	}
+}
+
+// runChecks runs every check concurrently, each bounded by timeout
+func runChecks(ctx context.Context, checks map[string]func(ctx context.Context) error, timeout time.Duration) map[string]checkResult {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]checkResult, len(checks))
+	)
+	for name, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check(checkCtx)
+			result := checkResult{
+				Status:    checkUp,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = checkDown
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// probeUpstream requests path from up's targets in turn and succeeds as soon
+// as one answers with anything other than a 5xx
+func (h *Handler) probeUpstream(ctx context.Context, up config.NamedUpstreamConfig, path string) error {
+	client := h.clients.get(up)
+
+	var lastErr error
+	for _, target := range up.TargetURLs() {
+		url := strings.TrimSuffix(target, "/") + "/" + strings.TrimPrefix(path, "/")
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < http.StatusInternalServerError {
+			return nil
+		}
+		lastErr = fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	if lastErr == nil {
+		return fmt.Errorf("no targets configured")
+	}
+	return lastErr
+}
+
+// writeHealth writes a health response as JSON
+func writeHealth(w http.ResponseWriter, code int, resp interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+func TestRunChecks(t *testing.T) {
+	results := runChecks(t.Context(), map[string]func(ctx context.Context) error{
+		"ok":   func(context.Context) error { return nil },
+		"down": func(context.Context) error { return errors.New("connection refused") },
+		"slow": func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	}, 10*time.Millisecond)
+
+	if got := results["ok"]; got.Status != checkUp || got.Error != "" {
+		t.Errorf("ok = %+v, want up", got)
+	}
+	if got := results["down"]; got.Status != checkDown || got.Error != "connection refused" {
+		t.Errorf("down = %+v, want down with error", got)
+	}
+	if got := results["slow"]; got.Status != checkDown {
+		t.Errorf("slow = %+v, want down after the timeout", got)
+	}
+}
+
+func TestProbeUpstream(t *testing.T) {
+	var probed string
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		probed = r.URL.Path
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	h := &Handler{clients: newClientPool()}
+
+	up := config.NamedUpstreamConfig{Name: "api", UpstreamConfig: config.UpstreamConfig{Targets: []string{failing.URL, healthy.URL + "/"}}}
+	if err := h.probeUpstream(t.Context(), up, "/status"); err != nil {
+		t.Errorf("probeUpstream() error = %v, want reachable through the second target", err)
+	}
+	if probed != "/status" {
+		t.Errorf("probed %q, want /status", probed)
+	}
+
+	up.Targets = []string{failing.URL}
+	if err := h.probeUpstream(t.Context(), up, "/"); err == nil {
+		t.Error("probeUpstream() succeeded with only a failing target")
+	}
+}
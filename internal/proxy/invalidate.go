@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// invalidateAfterWrite purges the cached GET responses a successful unsafe
+// request made stale: those for its own path and for the paths its matching
+// invalidation rules list. Each path is purged on the upstream it routes to.
+// Purging runs to completion even if the client goes away.
+func (h *Handler) invalidateAfterWrite(ctx context.Context, cfg *config.Config, r *http.Request, requestID string) {
+	targets := cfg.InvalidationTargets(r.Method, r.URL.Path)
+	if len(targets) == 0 {
+		return
+	}
+
+	paths := make([]cache.RequestPath, len(targets))
+	for i, target := range targets {
+		paths[i] = cache.RequestPath{
+			Upstream: cfg.ResolveUpstream(strings.TrimSuffix(target, "*")).Upstream.Name,
+			Path:     target,
+		}
+	}
+
+	deleted, err := h.cache.DeletePaths(context.WithoutCancel(ctx), paths)
+	fields := map[string]interface{}{
+		"request_id": requestID,
+		"method":     r.Method,
+		"path":       r.URL.Path,
+		"purged":     targets,
+		"deleted":    deleted,
+	}
+	if err != nil {
+		fields["error"] = err
+		logger.WithFields(fields).Error("Failed to invalidate cache after write")
+		return
+	}
+	logger.WithFields(fields).Debug("Invalidated cache after write")
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+// newWriteUpstream returns an upstream answering reads with 200 and writes
+// with the status in their status query parameter, or 204
+func newWriteUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			w.Write([]byte("body for " + r.URL.Path))
+			return
+		}
+		status, err := strconv.Atoi(r.URL.Query().Get("status"))
+		if err != nil {
+			status = http.StatusNoContent
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream
+}
+
+// cached reports whether a GET for target is served from the cache
+func cached(t *testing.T, h *Handler, target string) bool {
+	t.Helper()
+	w := serve(h, http.MethodGet, target, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s = %d, want %d", target, w.Code, http.StatusOK)
+	}
+	return w.Header().Get("X-Cache") == "HIT"
+}
+
+func TestWriteInvalidatesPathAndParent(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Cache.Invalidation = config.InvalidationConfig{Enabled: true, PurgeParent: true}
+	// fields picks a separate cache entry for the same path
+	cfg.Cache.Endpoints = []config.EndpointCacheConfig{{
+		Path:                "/items/1",
+		Methods:             []string{http.MethodGet},
+		TTL:                 time.Minute,
+		CacheKeyQueryParams: []string{"fields"},
+	}}
+	h, _ := newTestHandler(t, cfg, newWriteUpstream(t).URL)
+
+	reads := []string{"/items/1", "/items/1?fields=name", "/items", "/items/2"}
+	for _, target := range reads {
+		serve(h, http.MethodGet, target, nil)
+		if !cached(t, h, target) {
+			t.Fatalf("GET %s was not cached", target)
+		}
+	}
+
+	// A rejected write leaves every read cached
+	if w := serve(h, http.MethodPut, "/items/1?status=409", nil); w.Code != http.StatusConflict {
+		t.Fatalf("PUT = %d, want %d", w.Code, http.StatusConflict)
+	}
+	for _, target := range reads {
+		if !cached(t, h, target) {
+			t.Errorf("GET %s was purged by a failed write", target)
+		}
+	}
+
+	// A successful write purges every variant of its path and the parent
+	if w := serve(h, http.MethodPut, "/items/1", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("PUT = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	for i, wantCached := range []bool{false, false, false, true} {
+		if got := cached(t, h, reads[i]); got != wantCached {
+			t.Errorf("GET %s cached = %v after write, want %v", reads[i], got, wantCached)
+		}
+	}
+}
+
+func TestWriteInvalidatesRuleTargets(t *testing.T) {
+	// Loaded from YAML so the rule's regex is compiled
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := `
+server:
+  port: 8080
+valkey:
+  port: 6379
+upstream:
+  base_url: http://localhost:9000
+cache:
+  invalidation:
+    enabled: true
+    rules:
+      - path_regex: "^/users/([0-9]+)/orders$"
+        purge: ["/users/$1", "/users/$1/orders/*"]
+`
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("config.Load() error = %v", err)
+	}
+	h, _ := newTestHandler(t, cfg, newWriteUpstream(t).URL)
+
+	reads := []string{"/users/7", "/users/7/orders/1", "/users/7/orders/2", "/users/8/orders/1"}
+	for _, target := range reads {
+		serve(h, http.MethodGet, target, nil)
+		if !cached(t, h, target) {
+			t.Fatalf("GET %s was not cached", target)
+		}
+	}
+
+	if w := serve(h, http.MethodPost, "/users/7/orders", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("POST = %d, want %d", w.Code, http.StatusNoContent)
+	}
+	for i, wantCached := range []bool{false, false, false, true} {
+		if got := cached(t, h, reads[i]); got != wantCached {
+			t.Errorf("GET %s cached = %v after write, want %v", reads[i], got, wantCached)
+		}
+	}
+}
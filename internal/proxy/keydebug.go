@@ -0,0 +1,26 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+// keyDebugHeader carries the JSON description of a response's cache key
+const keyDebugHeader = "X-Cache-Key-Debug"
+
+// setKeyDebugHeader describes the cache key for a request in the response
+// headers. cacheKey is the key the request is served from, which differs from
+// the described key when the response is stored as a Vary variant.
+func (h *Handler) setKeyDebugHeader(w http.ResponseWriter, r *http.Request, upstream string, match config.EndpointMatch, cacheKey string) {
+	desc := h.cache.DescribeCacheKey(r, upstream, match)
+	if cacheKey != desc.CacheKey {
+		desc.VariantKey = cacheKey
+	}
+	value, err := json.Marshal(desc)
+	if err != nil {
+		return
+	}
+	w.Header().Set(keyDebugHeader, string(value))
+}
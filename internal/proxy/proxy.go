@@ -2,43 +2,71 @@ package proxy
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/singh-gur/api_cache/internal/balancer"
+	"github.com/singh-gur/api_cache/internal/breaker"
 	"github.com/singh-gur/api_cache/internal/cache"
 	"github.com/singh-gur/api_cache/internal/config"
 	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/metrics"
 	"github.com/singh-gur/api_cache/internal/middleware"
+	"github.com/singh-gur/api_cache/internal/quota"
+	"github.com/singh-gur/api_cache/internal/warmer"
 )
 
 type Handler struct {
-	cache      *cache.Client
-	config     *config.Config
-	httpClient *http.Client
+	cache    *cache.Client
+	config   *config.Holder
+	clients  *clientPool
+	flights  *flightGroup
+	quota    *quota.Tracker
+	breakers *breaker.Set
+	balancer *balancer.Set
+	warmer   *warmer.Warmer
+	started  time.Time
+	// stopping is closed by Stop to cut short retry and quota waits during
+	// shutdown
+	stopping chan struct{}
+	stopOnce sync.Once
 }
 
-// NewHandler creates a new proxy handler
-func NewHandler(cacheClient *cache.Client, cfg *config.Config) *Handler {
-	return &Handler{
-		cache:  cacheClient,
-		config: cfg,
-		httpClient: &http.Client{
-			Timeout: cfg.Upstream.Timeout,
-			Transport: &http.Transport{
-				MaxIdleConns:        cfg.Upstream.MaxIdleConns,
-				MaxConnsPerHost:     cfg.Upstream.MaxConnsPerHost,
-				IdleConnTimeout:     90 * time.Second,
-				TLSHandshakeTimeout: 10 * time.Second,
-			},
-		},
+// NewHandler creates a new proxy handler and starts health checking upstream
+// targets and warming the cache until Stop is called. It follows configuration
+// reloads, including upstream connection settings, which rebuild that
+// upstream's client.
+func NewHandler(cacheClient *cache.Client, configs *config.Holder) *Handler {
+	clients := newClientPool()
+	stopping := make(chan struct{})
+	h := &Handler{
+		cache:    cacheClient,
+		config:   configs,
+		clients:  clients,
+		flights:  newFlightGroup(),
+		quota:    quota.NewTracker(configs, cacheClient, stopping),
+		breakers: breaker.NewSet(configs),
+		balancer: balancer.NewSet(configs, clients.get),
+		started:  time.Now(),
+		stopping: stopping,
 	}
+	h.warmer = warmer.New(configs, cacheClient, h.warmRequest)
+	go h.balancer.Run(h.stopping)
+	go h.warmer.Run(h.stopping)
+	return h
 }
 
 // sanitizeQuery returns the request query string with sensitive params redacted.
 func (h *Handler) sanitizeQuery(r *http.Request) string {
-	return h.config.SanitizeQuery(r.URL.RawQuery)
+	return h.config.Get().SanitizeQuery(r.URL.RawQuery)
 }
 
 // endpointLogFields returns structured log fields describing the matched endpoint config.
@@ -78,6 +106,10 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"user_agent":  r.Header.Get("User-Agent"),
 	}).Info("Incoming request")
 
+	// Use one configuration snapshot for the whole request, even if a reload
+	// lands while it is in flight
+	cfg := h.config.Get()
+
 	// Only cache GET requests
 	if r.Method != http.MethodGet {
 		logger.WithFields(map[string]interface{}{
@@ -85,19 +117,35 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"method":     r.Method,
 			"path":       r.URL.Path,
 		}).Debug("Non-GET request, bypassing cache")
-		h.forwardRequest(w, r, ctx, requestID, startTime)
+		metrics.RecordCacheResult(metrics.DefaultEndpoint, "bypass")
+		h.forwardRequest(w, r, ctx, cfg, requestID, startTime)
 		return
 	}
 
 	// Get endpoint-specific cache config with match metadata
-	match := h.config.GetEndpointCacheConfigMatch(r.URL.Path, r.Method, r.URL.Query())
+	match := cfg.GetEndpointCacheConfigMatch(r.URL.Path, r.Method, r.URL.Query())
 	endpointConfig := match.Config
 
-	// Generate cache key
-	cacheKey := h.cache.GenerateCacheKey(r, endpointConfig)
+	// Generate cache key, keeping responses from different upstreams apart
+	upstream := cfg.ResolveUpstream(r.URL.Path).Upstream.Name
+	cacheKey := h.cache.GenerateCacheKey(r, upstream, endpointConfig)
+	if cfg.Cache.RespectUpstreamCacheHeaders {
+		cacheKey = h.resolveVariantKey(ctx, r, cacheKey, requestID)
+	}
+
+	// Show how the cache key was derived when debugging keys
+	if cfg.Cache.DebugKeyHeader {
+		h.setKeyDebugHeader(w, r, upstream, match, cacheKey)
+	}
+
+	// Count the request toward the most requested URLs to warm, unless its
+	// cache key depends on headers the warmer would not send
+	if endpointConfig == nil || len(endpointConfig.CacheKeyHeaders) == 0 {
+		h.warmer.Record(r)
+	}
 
 	// Determine effective TTL
-	ttl := h.getTTL(endpointConfig)
+	ttl := h.getTTL(cfg, endpointConfig)
 
 	// Log cache key and endpoint config details
 	logFields := map[string]interface{}{
@@ -107,6 +155,7 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"query":      safeQuery,
 		"method":     r.Method,
 		"ttl":        ttl.Seconds(),
+		"upstream":   upstream,
 	}
 	for k, v := range endpointLogFields(match) {
 		logFields[k] = v
@@ -125,10 +174,37 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}).Error("Failed to get from cache")
 	}
 
+	var stale *cache.CachedResponse
 	if cached != nil {
-		// Serve from cache
-		h.serveCachedResponse(w, r, cached, cacheKey, match, requestID, startTime)
-		return
+		now := time.Now()
+		if cached.IsFresh(now) {
+			// Serve from cache
+			h.serveCachedResponse(w, r, cached, "HIT", cacheKey, match, requestID, startTime)
+
+			// Renew entries that are still being hit before they expire
+			if endpointConfig != nil && cached.DueForRefresh(now, endpointConfig.RefreshAhead) {
+				logger.WithFields(map[string]interface{}{
+					"request_id":    requestID,
+					"cache_key":     cacheKey,
+					"path":          r.URL.Path,
+					"cache_age":     now.Sub(cached.CachedAt).Seconds(),
+					"refresh_ahead": endpointConfig.RefreshAhead,
+				}).Debug("Refreshing cache entry ahead of expiry")
+				h.revalidateInBackground(r, ctx, cfg, cacheKey, endpointConfig, match, cached, requestID)
+			}
+			return
+		}
+
+		// Within the stale-while-revalidate window, serve the stale copy now
+		// and refresh it in the background
+		if endpointConfig != nil && now.Before(cached.FreshUntil.Add(endpointConfig.StaleWhileRevalidate)) {
+			h.serveCachedResponse(w, r, cached, "STALE", cacheKey, match, requestID, startTime)
+			h.revalidateInBackground(r, ctx, cfg, cacheKey, endpointConfig, match, cached, requestID)
+			return
+		}
+
+		// Otherwise keep it around as a fallback if the upstream fails
+		stale = cached
 	}
 
 	logger.WithFields(map[string]interface{}{
@@ -139,12 +215,17 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		"ttl":        ttl.Seconds(),
 	}).Debug("Cache miss")
 
-	// Cache miss - forward request to upstream
-	h.forwardAndCache(w, r, ctx, cacheKey, endpointConfig, match, requestID, startTime)
+	// Cache miss - forward request to upstream, collapsing concurrent misses if enabled
+	if cfg.Cache.Coalescing.Enabled {
+		h.coalesceAndCache(w, r, ctx, cfg, cacheKey, endpointConfig, match, stale, requestID, startTime)
+		return
+	}
+	h.forwardAndCache(w, r, ctx, cfg, cacheKey, endpointConfig, match, stale, requestID, startTime)
 }
 
-// serveCachedResponse writes a cached response to the client
-func (h *Handler) serveCachedResponse(w http.ResponseWriter, r *http.Request, cached *cache.CachedResponse, cacheKey string, match config.EndpointMatch, requestID string, startTime time.Time) {
+// serveCachedResponse writes a cached response to the client.
+// cacheStatus is reported in the X-Cache header (HIT or STALE).
+func (h *Handler) serveCachedResponse(w http.ResponseWriter, r *http.Request, cached *cache.CachedResponse, cacheStatus, cacheKey string, match config.EndpointMatch, requestID string, startTime time.Time) {
 	// Copy headers
 	for key, values := range cached.Headers {
 		for _, value := range values {
@@ -153,25 +234,57 @@ func (h *Handler) serveCachedResponse(w http.ResponseWriter, r *http.Request, ca
 	}
 
 	// Add cache headers
-	w.Header().Set("X-Cache", "HIT")
+	w.Header().Set("X-Cache", cacheStatus)
 	w.Header().Set("X-Cache-Time", cached.CachedAt.Format(time.RFC3339))
 
-	// Write status and body
-	w.WriteHeader(cached.StatusCode)
-	w.Write(cached.Body)
+	// Serve compressed bodies as-is or decompressed depending on Accept-Encoding
+	decode := negotiateEncoding(w, r, cached)
+
+	// Answer conditional requests whose validators match without a body
+	status := cached.StatusCode
+	var body []byte
+	if writeNotModified(w, r, status) {
+		status = http.StatusNotModified
+	} else {
+		body = cached.Body
+		if decode {
+			var err error
+			if body, err = cached.DecodedBody(); err != nil {
+				logger.WithFields(map[string]interface{}{
+					"request_id": requestID,
+					"error":      err,
+					"cache_key":  cacheKey,
+					"encoding":   cached.Encoding,
+				}).Error("Failed to decode cached response")
+				http.Error(w, "failed to read cached response", http.StatusInternalServerError)
+				return
+			}
+		}
+		if cached.Encoding != "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		}
+
+		// Write status and body
+		w.WriteHeader(status)
+		w.Write(body)
+	}
+
+	endpoint := metrics.EndpointLabel(match.Config)
+	metrics.RecordCacheResult(endpoint, strings.ToLower(cacheStatus))
+	metrics.RecordCacheBytesServed(endpoint, len(body))
 
 	duration := time.Since(startTime)
 	cacheAge := time.Since(cached.CachedAt)
 	logFields := map[string]interface{}{
 		"request_id": requestID,
-		"cache":      "hit",
+		"cache":      strings.ToLower(cacheStatus),
 		"cache_key":  cacheKey,
 		"path":       r.URL.Path,
 		"query":      h.sanitizeQuery(r),
-		"status":     cached.StatusCode,
+		"status":     status,
 		"duration":   duration.Milliseconds(),
 		"cache_age":  cacheAge.Seconds(),
-		"body_size":  len(cached.Body),
+		"body_size":  len(body),
 		"cached_at":  cached.CachedAt.Format(time.RFC3339),
 	}
 	for k, v := range endpointLogFields(match) {
@@ -180,8 +293,68 @@ func (h *Handler) serveCachedResponse(w http.ResponseWriter, r *http.Request, ca
 	logger.WithFields(logFields).Info("Request served from cache")
 }
 
-// forwardAndCache forwards the request to upstream and caches the response
-func (h *Handler) forwardAndCache(w http.ResponseWriter, r *http.Request, ctx context.Context, cacheKey string, endpointConfig *config.EndpointCacheConfig, match config.EndpointMatch, requestID string, startTime time.Time) {
+// upstreamResult holds a fully-read upstream response so it can be written to
+// one or more clients.
+type upstreamResult struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Cached     bool
+	// StoreKey is the key the response was (or would have been) cached under,
+	// which differs from the lookup key when the response has a Vary header.
+	StoreKey string
+	// TTL is the freshness lifetime applied to the response
+	TTL time.Duration
+	// FromPeer is set when the response was produced by another replica's
+	// fetch and read back from cache.
+	FromPeer bool
+	// Revalidated is set when the upstream confirmed a stale entry with 304 Not
+	// Modified and the response was rebuilt from that entry.
+	Revalidated bool
+	// Upstream describes the upstream calls made for the fetch; zero when the
+	// response was read back from cache
+	Upstream upstreamStats
+}
+
+// errReadUpstreamBody marks failures reading the upstream response body, which are
+// reported to the client as 500 rather than 502.
+var errReadUpstreamBody = errors.New("failed to read upstream response")
+
+// errReadRequestBody marks failures reading the client's request body, which are
+// reported to the client as 400.
+var errReadRequestBody = errors.New("failed to read request body")
+
+// forwardAndCache forwards the request to upstream and caches the response.
+// If the fetch fails and stale is still within the endpoint's stale-if-error
+// window, the stale entry is served instead.
+func (h *Handler) forwardAndCache(w http.ResponseWriter, r *http.Request, ctx context.Context, cfg *config.Config, cacheKey string, endpointConfig *config.EndpointCacheConfig, match config.EndpointMatch, stale *cache.CachedResponse, requestID string, startTime time.Time) {
+	res, err := h.fetchAndCache(r, ctx, cfg, cacheKey, endpointConfig, match, stale, requestID)
+	if h.serveStaleOnError(w, r, res, err, stale, cacheKey, endpointConfig, match, requestID, startTime) {
+		return
+	}
+	if err != nil {
+		writeFetchError(w, err)
+		return
+	}
+	h.writeUpstreamResult(w, r, res, res.cacheStatus(), cacheKey, match, requestID, startTime)
+}
+
+// cacheStatus returns the X-Cache value for a response fetched by this request
+func (res *upstreamResult) cacheStatus() string {
+	switch {
+	case res.FromPeer:
+		return "COALESCED"
+	case res.Revalidated:
+		return "REVALIDATED"
+	default:
+		return "MISS"
+	}
+}
+
+// fetchAndCache fetches the response from upstream and caches it if successful.
+// When stale is set, the upstream request is made conditional on its validators
+// and a 304 Not Modified refreshes the stale entry instead of re-downloading it.
+func (h *Handler) fetchAndCache(r *http.Request, ctx context.Context, cfg *config.Config, cacheKey string, endpointConfig *config.EndpointCacheConfig, match config.EndpointMatch, stale *cache.CachedResponse, requestID string) (*upstreamResult, error) {
 	safeQuery := h.sanitizeQuery(r)
 
 	logger.WithFields(map[string]interface{}{
@@ -192,8 +365,13 @@ func (h *Handler) forwardAndCache(w http.ResponseWriter, r *http.Request, ctx co
 		"method":     r.Method,
 	}).Debug("Forwarding request to upstream")
 
+	// Send the stale entry's validators instead of the client's, which are
+	// answered from cache once the response is stored
+	upstreamReq := r.Clone(ctx)
+	setRevalidationHeaders(upstreamReq.Header, stale)
+
 	// Forward request with retry logic
-	resp, err := h.forwardWithRetry(r, ctx, requestID)
+	resp, stats, err := h.forwardWithRetry(upstreamReq, ctx, cfg, endpointConfig, requestID)
 	if err != nil {
 		logger.WithFields(map[string]interface{}{
 			"request_id": requestID,
@@ -202,8 +380,7 @@ func (h *Handler) forwardAndCache(w http.ResponseWriter, r *http.Request, ctx co
 			"path":       r.URL.Path,
 			"query":      safeQuery,
 		}).Error("Failed to forward request")
-		http.Error(w, "upstream service unavailable", http.StatusBadGateway)
-		return
+		return nil, err
 	}
 	defer resp.Body.Close()
 
@@ -216,80 +393,251 @@ func (h *Handler) forwardAndCache(w http.ResponseWriter, r *http.Request, ctx co
 			"cache_key":  cacheKey,
 			"path":       r.URL.Path,
 		}).Error("Failed to read response body")
-		http.Error(w, "failed to read upstream response", http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("%w: %v", errReadUpstreamBody, err)
 	}
 
-	// Cache successful responses (2xx status codes)
-	wasCached := false
-	ttl := h.getTTL(endpointConfig)
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+	res := &upstreamResult{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+		Upstream:   stats,
+	}
+
+	// A 304 confirms the stale entry: keep its body (still compressed at rest,
+	// if it was) and take the refreshed headers from the upstream
+	storedBody, encoding := body, ""
+	if resp.StatusCode == http.StatusNotModified && stale != nil {
+		decoded, err := stale.DecodedBody()
+		if err != nil {
+			logger.WithFields(map[string]interface{}{
+				"request_id": requestID,
+				"error":      err,
+				"cache_key":  cacheKey,
+				"encoding":   stale.Encoding,
+			}).Error("Failed to decode stale response")
+			return nil, fmt.Errorf("%w: %v", errReadUpstreamBody, err)
+		}
+		res.StatusCode = stale.StatusCode
+		res.Header = cache.UpdateHeaders(stale.Headers, resp.Header)
+		res.Body = decoded
+		res.Revalidated = true
+		storedBody, encoding = stale.Body, stale.Encoding
+
+		logger.WithFields(map[string]interface{}{
+			"request_id": requestID,
+			"cache_key":  cacheKey,
+			"path":       r.URL.Path,
+			"query":      safeQuery,
+		}).Debug("Stale response revalidated by upstream")
+	}
+
+	// Tag entries for purging by the endpoint's tags and, when configured, the
+	// upstream's surrogate keys, which are not passed on to clients
+	var tags []string
+	if endpointConfig != nil {
+		tags = endpointConfig.ResolveTags(r.URL.Path, r.URL.Query())
+	}
+	if cfg.Cache.SurrogateKeys {
+		for _, key := range cache.SurrogateKeys(res.Header) {
+			if !slices.Contains(tags, key) {
+				tags = append(tags, key)
+			}
+		}
+		res.Header.Del(cache.SurrogateKeyHeader)
+	}
+
+	// Cache successful responses (2xx status codes), subject to the upstream's
+	// own caching headers when configured to respect them
+	ttl := h.getTTL(cfg, endpointConfig)
+	storeKey := cacheKey
+	var vary []string
+	isSuccess := res.StatusCode >= 200 && res.StatusCode < 300
+	cacheable := isSuccess
+	if isSuccess && cfg.Cache.RespectUpstreamCacheHeaders {
+		ttl, storeKey, vary, cacheable = h.upstreamCachePolicy(r, cfg, res.Header, cacheKey, ttl, time.Now())
+	}
+	res.StoreKey = storeKey
+	res.TTL = ttl
+
+	if cacheable {
+		// Give every stored response a validator so conditional requests can be
+		// answered from cache, remembering which ones the upstream never issued
+		generatedETag := res.Revalidated && stale.GeneratedETag && resp.Header.Get("Etag") == ""
+		if res.Header.Get("Etag") == "" {
+			res.Header.Set("Etag", cache.GenerateETag(res.Body))
+			generatedETag = true
+		}
+
+		now := time.Now()
+		storeTTL := ttl
+		if endpointConfig != nil {
+			storeTTL += endpointConfig.StaleWindow()
+		}
 		cachedResp := &cache.CachedResponse{
-			StatusCode: resp.StatusCode,
-			Headers:    resp.Header,
-			Body:       body,
-			CachedAt:   time.Now(),
+			StatusCode:    res.StatusCode,
+			Headers:       res.Header,
+			Body:          storedBody,
+			CachedAt:      now,
+			FreshUntil:    now.Add(ttl),
+			ExpiresAt:     now.Add(storeTTL),
+			Encoding:      encoding,
+			GeneratedETag: generatedETag,
+		}
+		if endpointConfig != nil {
+			cachedResp.EndpointID = endpointConfig.EndpointIdentifier()
+		}
+
+		if len(vary) > 0 {
+			if err := h.cache.SetVary(ctx, cache.BaseKey(cacheKey), vary, storeTTL); err != nil {
+				logger.WithFields(map[string]interface{}{
+					"request_id": requestID,
+					"error":      err,
+					"cache_key":  cacheKey,
+				}).Error("Failed to record Vary headers")
+			}
 		}
 
-		if err := h.cache.Set(ctx, cacheKey, cachedResp, ttl); err != nil {
+		if err := h.cache.Set(ctx, storeKey, cachedResp, storeTTL); err != nil {
 			logger.WithFields(map[string]interface{}{
 				"request_id": requestID,
 				"error":      err,
-				"cache_key":  cacheKey,
+				"cache_key":  storeKey,
 				"path":       r.URL.Path,
 				"query":      safeQuery,
 			}).Error("Failed to cache response")
 		} else {
-			wasCached = true
+			res.Cached = true
+			// Index the entry by its request path too when writes invalidate
+			index := cache.EntryIndex{Tags: tags}
+			if cfg.Cache.Invalidation.Enabled {
+				index.Path = &cache.RequestPath{
+					Upstream: cfg.ResolveUpstream(r.URL.Path).Upstream.Name,
+					Path:     r.URL.Path,
+				}
+			}
+			if err := h.cache.IndexEntry(ctx, storeKey, index, storeTTL); err != nil {
+				logger.WithFields(map[string]interface{}{
+					"request_id": requestID,
+					"error":      err,
+					"cache_key":  storeKey,
+					"tags":       tags,
+				}).Error("Failed to index cached response")
+			}
 			logFields := map[string]interface{}{
 				"request_id": requestID,
-				"cache_key":  cacheKey,
+				"cache_key":  storeKey,
 				"path":       r.URL.Path,
 				"query":      safeQuery,
 				"ttl":        ttl.Seconds(),
 				"body_size":  len(body),
+				"tags":       tags,
 			}
 			for k, v := range endpointLogFields(match) {
 				logFields[k] = v
 			}
 			logger.WithFields(logFields).Debug("Response cached successfully")
 		}
+	} else if isSuccess {
+		logger.WithFields(map[string]interface{}{
+			"request_id":    requestID,
+			"cache_key":     cacheKey,
+			"path":          r.URL.Path,
+			"query":         safeQuery,
+			"cache_control": res.Header.Values("Cache-Control"),
+		}).Debug("Response not cached (upstream cache headers)")
 	} else {
 		logger.WithFields(map[string]interface{}{
 			"request_id": requestID,
 			"cache_key":  cacheKey,
 			"path":       r.URL.Path,
 			"query":      safeQuery,
-			"status":     resp.StatusCode,
+			"status":     res.StatusCode,
 		}).Debug("Response not cached (non-2xx status)")
 	}
 
+	return res, nil
+}
+
+// writeFetchError writes the client-facing error for a failed upstream fetch
+func writeFetchError(w http.ResponseWriter, err error) {
+	var exhausted *quota.ExhaustedError
+	if errors.As(err, &exhausted) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(exhausted.RetryAfter.Seconds()))))
+		http.Error(w, "upstream quota exhausted", http.StatusTooManyRequests)
+		return
+	}
+	var open *breaker.OpenError
+	if errors.As(err, &open) {
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(open.RetryAfter.Seconds())), 1)))
+		http.Error(w, "upstream circuit open", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, errReadRequestBody) {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, errReadUpstreamBody) {
+		http.Error(w, "failed to read upstream response", http.StatusInternalServerError)
+		return
+	}
+	http.Error(w, "upstream service unavailable", http.StatusBadGateway)
+}
+
+// setUpstreamHeaders reports how many upstream calls a fetch made and the
+// upstream quota left after it, if a quota applies
+func setUpstreamHeaders(w http.ResponseWriter, stats upstreamStats) {
+	if stats.Attempts > 0 {
+		w.Header().Set("X-Upstream-Attempts", strconv.Itoa(stats.Attempts))
+	}
+	if stats.Quota.Limited {
+		w.Header().Set("X-Upstream-Quota-Remaining", strconv.Itoa(stats.Quota.Remaining))
+	}
+}
+
+// writeUpstreamResult writes a fetched upstream response to the client.
+// cacheStatus is reported in the X-Cache header (MISS or COALESCED).
+func (h *Handler) writeUpstreamResult(w http.ResponseWriter, r *http.Request, res *upstreamResult, cacheStatus, cacheKey string, match config.EndpointMatch, requestID string, startTime time.Time) {
 	// Copy headers to response
-	for key, values := range resp.Header {
+	for key, values := range res.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
 
 	// Add cache headers
-	w.Header().Set("X-Cache", "MISS")
+	w.Header().Set("X-Cache", cacheStatus)
+	setUpstreamHeaders(w, res.Upstream)
+
+	// Write response, answering conditional requests for stored responses
+	// the same way a later cache hit would
+	status := res.StatusCode
+	if res.Cached && writeNotModified(w, r, status) {
+		status = http.StatusNotModified
+	} else {
+		w.WriteHeader(status)
+		w.Write(res.Body)
+	}
 
-	// Write response
-	w.WriteHeader(resp.StatusCode)
-	w.Write(body)
+	metrics.RecordCacheResult(metrics.EndpointLabel(match.Config), strings.ToLower(cacheStatus))
 
 	duration := time.Since(startTime)
 	logFields := map[string]interface{}{
 		"request_id": requestID,
-		"cache":      "miss",
+		"cache":      strings.ToLower(cacheStatus),
 		"cache_key":  cacheKey,
 		"path":       r.URL.Path,
-		"query":      safeQuery,
-		"status":     resp.StatusCode,
+		"query":      h.sanitizeQuery(r),
+		"status":     status,
 		"duration":   duration.Milliseconds(),
-		"body_size":  len(body),
-		"cached":     wasCached,
-		"ttl":        ttl.Seconds(),
+		"body_size":  len(res.Body),
+		"cached":     res.Cached,
+		"ttl":        res.TTL.Seconds(),
+	}
+	if res.Upstream.Attempts > 0 {
+		logFields["upstream_attempts"] = res.Upstream.Attempts
+	}
+	if res.Upstream.Quota.Limited {
+		logFields["quota_remaining"] = res.Upstream.Quota.Remaining
 	}
 	for k, v := range endpointLogFields(match) {
 		logFields[k] = v
@@ -298,14 +646,14 @@ func (h *Handler) forwardAndCache(w http.ResponseWriter, r *http.Request, ctx co
 }
 
 // forwardRequest forwards a non-cacheable request to upstream
-func (h *Handler) forwardRequest(w http.ResponseWriter, r *http.Request, ctx context.Context, requestID string, startTime time.Time) {
+func (h *Handler) forwardRequest(w http.ResponseWriter, r *http.Request, ctx context.Context, cfg *config.Config, requestID string, startTime time.Time) {
 	logger.WithFields(map[string]interface{}{
 		"request_id": requestID,
 		"method":     r.Method,
 		"path":       r.URL.Path,
 	}).Debug("Forwarding non-cacheable request to upstream")
 
-	resp, err := h.forwardWithRetry(r, ctx, requestID)
+	resp, stats, err := h.forwardWithRetry(r, ctx, cfg, nil, requestID)
 	if err != nil {
 		logger.WithFields(map[string]interface{}{
 			"request_id": requestID,
@@ -313,17 +661,24 @@ func (h *Handler) forwardRequest(w http.ResponseWriter, r *http.Request, ctx con
 			"method":     r.Method,
 			"path":       r.URL.Path,
 		}).Error("Failed to forward request")
-		http.Error(w, "upstream service unavailable", http.StatusBadGateway)
+		writeFetchError(w, err)
 		return
 	}
 	defer resp.Body.Close()
 
+	// Purge what a successful write made stale before answering, so the
+	// client's next read misses
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		h.invalidateAfterWrite(ctx, cfg, r, requestID)
+	}
+
 	// Copy headers
 	for key, values := range resp.Header {
 		for _, value := range values {
 			w.Header().Add(key, value)
 		}
 	}
+	setUpstreamHeaders(w, stats)
 
 	// Write status
 	w.WriteHeader(resp.StatusCode)
@@ -342,19 +697,71 @@ func (h *Handler) forwardRequest(w http.ResponseWriter, r *http.Request, ctx con
 	}).Info("Request forwarded (non-cacheable)")
 }
 
-// forwardWithRetry forwards a request with retry logic
-func (h *Handler) forwardWithRetry(r *http.Request, ctx context.Context, requestID string) (*http.Response, error) {
+// upstreamStats describes the upstream calls made for one fetch
+type upstreamStats struct {
+	// Quota is the upstream quota left after the last call
+	Quota quota.Usage
+	// Attempts is the number of upstream calls made
+	Attempts int
+}
+
+// forwardWithRetry forwards a request with retry logic to the upstream its path
+// routes to, using that upstream's client, retry settings, and quota. Each
+// attempt is balanced across the upstream's targets, preferring one not yet
+// tried, and draws on the upstream's quota. endpointConfig is the matched cache
+// endpoint config, if any, and is used to label metrics and pick the circuit
+// breaker. Waits between attempts are jittered, honor the upstream's
+// Retry-After, stay within the retry budget, and end early if ctx is done or
+// the handler is stopping. Only requests that are safe to repeat and whose
+// body fits in the buffer limit are retried.
+func (h *Handler) forwardWithRetry(r *http.Request, ctx context.Context, cfg *config.Config, endpointConfig *config.EndpointCacheConfig, requestID string) (*http.Response, upstreamStats, error) {
+	route := cfg.ResolveUpstream(r.URL.Path)
+	retry := route.Retry
+	client := h.clients.get(route.Upstream)
+	endpoint := metrics.EndpointLabel(endpointConfig)
+	breakerKey := endpoint
+	if route.Upstream.Name != config.DefaultUpstreamName {
+		breakerKey = route.Upstream.Name + ":" + endpoint
+	}
+	hashKey := balanceKey(r, route.Upstream)
+	var tried []string
 	var lastErr error
-	backoff := h.config.Retry.InitialBackoff
+	var stats upstreamStats
+	delays := newBackoff(retry)
+	start := time.Now()
 
 	maxAttempts := 1
-	if h.config.Retry.Enabled {
-		maxAttempts = h.config.Retry.MaxAttempts
+	if retry.Enabled {
+		maxAttempts = retry.MaxAttempts
+	}
+	if maxAttempts > 1 && !retriesAllowed(retry, r) {
+		logger.WithFields(map[string]interface{}{
+			"request_id": requestID,
+			"method":     r.Method,
+			"path":       r.URL.Path,
+		}).Debug("Non-idempotent request without Idempotency-Key, not retrying")
+		maxAttempts = 1
+	}
+
+	body, err := newUpstreamBody(r, maxAttempts > 1, retry.BodyBufferLimit())
+	if err != nil {
+		return nil, stats, err
+	}
+	if maxAttempts > 1 && !body.replayable() {
+		logger.WithFields(map[string]interface{}{
+			"request_id":     requestID,
+			"method":         r.Method,
+			"path":           r.URL.Path,
+			"max_body_bytes": retry.BodyBufferLimit(),
+		}).Debug("Request body too large to buffer, not retrying")
+		maxAttempts = 1
 	}
 
 	for attempt := 1; attempt <= maxAttempts; attempt++ {
-		// Create upstream request
-		upstreamURL := h.config.Upstream.BaseURL + r.URL.Path
+		// Create upstream request against the chosen target
+		target, release := h.balancer.Pick(route.Upstream, hashKey, tried)
+		tried = append(tried, target)
+		upstreamURL := target + route.Path
 		if r.URL.RawQuery != "" {
 			upstreamURL += "?" + r.URL.RawQuery
 		}
@@ -364,61 +771,126 @@ func (h *Handler) forwardWithRetry(r *http.Request, ctx context.Context, request
 			"attempt":      attempt,
 			"max_attempts": maxAttempts,
 			"upstream_url": upstreamURL,
+			"upstream":     route.Upstream.Name,
 			"method":       r.Method,
 		}).Debug("Attempting upstream request")
 
-		req, err := http.NewRequestWithContext(ctx, r.Method, upstreamURL, r.Body)
+		req, err := body.newRequest(ctx, r.Method, upstreamURL)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create upstream request: %w", err)
+			release(breaker.Ignored)
+			return nil, stats, fmt.Errorf("failed to create upstream request: %w", err)
 		}
 
 		// Copy headers
 		req.Header = r.Header.Clone()
 
+		if attempt > 1 {
+			metrics.RecordUpstreamRetry(endpoint)
+		}
+
+		// Skip the upstream entirely while its circuit is open
+		done, err := h.breakers.Allow(breakerKey)
+		if err != nil {
+			release(breaker.Ignored)
+			logger.WithFields(map[string]interface{}{
+				"request_id":   requestID,
+				"attempt":      attempt,
+				"error":        err,
+				"upstream_url": upstreamURL,
+			}).Warn("Circuit breaker open, not calling upstream")
+			return nil, stats, err
+		}
+
+		// Each attempt is a real upstream call and draws on the upstream quota
+		usage, quotaErr := h.quota.Acquire(ctx, route.Upstream)
+		if quotaErr != nil {
+			done(breaker.Ignored)
+			release(breaker.Ignored)
+			logger.WithFields(map[string]interface{}{
+				"request_id":   requestID,
+				"attempt":      attempt,
+				"error":        quotaErr,
+				"upstream":     route.Upstream.Name,
+				"upstream_url": upstreamURL,
+			}).Warn("Upstream quota exhausted, not calling upstream")
+			return nil, stats, quotaErr
+		}
+		stats.Quota = usage
+
 		// Execute request
-		resp, err := h.httpClient.Do(req)
+		stats.Attempts = attempt
+		attemptStart := time.Now()
+		resp, err := client.Do(req)
+		outcome := breakerOutcome(ctx, resp, err)
+		done(outcome)
+		release(outcome)
 		if err != nil {
+			metrics.ObserveUpstream(endpoint, 0, time.Since(attemptStart))
 			lastErr = err
-			if attempt < maxAttempts {
+			if wait := delays.Next(); attempt < maxAttempts && withinBudget(retry, start, wait) {
 				logger.WithFields(map[string]interface{}{
 					"request_id":   requestID,
 					"attempt":      attempt,
 					"max_attempts": maxAttempts,
 					"error":        err,
-					"backoff_ms":   backoff.Milliseconds(),
+					"backoff_ms":   wait.Milliseconds(),
 					"upstream_url": upstreamURL,
 				}).Warn("Request failed, retrying")
-				time.Sleep(backoff)
-				backoff = time.Duration(float64(backoff) * h.config.Retry.BackoffMultiplier)
-				if backoff > h.config.Retry.MaxBackoff {
-					backoff = h.config.Retry.MaxBackoff
+				if err := h.waitRetry(ctx, wait); err != nil {
+					return nil, stats, fmt.Errorf("retry abandoned after %d attempts: %w (last error: %v)", attempt, err, lastErr)
 				}
 				continue
 			}
 			logger.WithFields(map[string]interface{}{
 				"request_id":   requestID,
-				"attempts":     maxAttempts,
+				"attempts":     attempt,
 				"error":        lastErr,
 				"upstream_url": upstreamURL,
 			}).Error("All retry attempts exhausted")
-			return nil, fmt.Errorf("all retry attempts failed: %w", lastErr)
+			return nil, stats, fmt.Errorf("all retry attempts failed: %w", lastErr)
 		}
 
+		metrics.ObserveUpstream(endpoint, resp.StatusCode, time.Since(attemptStart))
+
 		// Check if status code is retryable
-		if h.isRetryableStatus(resp.StatusCode) && attempt < maxAttempts {
+		if isRetryableStatus(retry, resp.StatusCode) && attempt < maxAttempts {
+			wait := delays.Next()
+			if retryAfter, ok := upstreamRetryAfter(resp, time.Now()); ok {
+				if retryAfter > retry.RetryAfterLimit() {
+					logger.WithFields(map[string]interface{}{
+						"request_id":     requestID,
+						"attempt":        attempt,
+						"status":         resp.StatusCode,
+						"retry_after_ms": retryAfter.Milliseconds(),
+						"upstream_url":   upstreamURL,
+					}).Warn("Upstream Retry-After too long, not retrying")
+					return resp, stats, nil
+				}
+				wait = max(wait, retryAfter)
+			}
+			if !withinBudget(retry, start, wait) {
+				logger.WithFields(map[string]interface{}{
+					"request_id":   requestID,
+					"attempt":      attempt,
+					"status":       resp.StatusCode,
+					"backoff_ms":   wait.Milliseconds(),
+					"upstream_url": upstreamURL,
+				}).Warn("Retry budget exhausted, not retrying")
+				return resp, stats, nil
+			}
+
 			resp.Body.Close()
+			lastErr = fmt.Errorf("upstream returned status %d", resp.StatusCode)
 			logger.WithFields(map[string]interface{}{
 				"request_id":   requestID,
 				"attempt":      attempt,
 				"max_attempts": maxAttempts,
 				"status":       resp.StatusCode,
-				"backoff_ms":   backoff.Milliseconds(),
+				"backoff_ms":   wait.Milliseconds(),
 				"upstream_url": upstreamURL,
 			}).Warn("Retryable status code, retrying")
-			time.Sleep(backoff)
-			backoff = time.Duration(float64(backoff) * h.config.Retry.BackoffMultiplier)
-			if backoff > h.config.Retry.MaxBackoff {
-				backoff = h.config.Retry.MaxBackoff
+			if err := h.waitRetry(ctx, wait); err != nil {
+				return nil, stats, fmt.Errorf("retry abandoned after %d attempts: %w (last error: %v)", attempt, err, lastErr)
 			}
 			continue
 		}
@@ -432,18 +904,31 @@ func (h *Handler) forwardWithRetry(r *http.Request, ctx context.Context, request
 			}).Info("Request succeeded after retry")
 		}
 
-		return resp, nil
+		return resp, stats, nil
 	}
 
-	return nil, fmt.Errorf("all retry attempts failed: %w", lastErr)
+	return nil, stats, fmt.Errorf("all retry attempts failed: %w", lastErr)
+}
+
+// breakerOutcome classifies an upstream attempt for the circuit breaker.
+// Attempts abandoned by the client say nothing about upstream health.
+func breakerOutcome(ctx context.Context, resp *http.Response, err error) breaker.Outcome {
+	switch {
+	case err != nil && ctx.Err() != nil:
+		return breaker.Ignored
+	case err != nil, resp.StatusCode >= http.StatusInternalServerError:
+		return breaker.Failure
+	default:
+		return breaker.Success
+	}
 }
 
 // isRetryableStatus checks if a status code is retryable
-func (h *Handler) isRetryableStatus(statusCode int) bool {
-	if !h.config.Retry.Enabled {
+func isRetryableStatus(retry config.RetryConfig, statusCode int) bool {
+	if !retry.Enabled {
 		return false
 	}
-	for _, code := range h.config.Retry.RetryableStatusCodes {
+	for _, code := range retry.RetryableStatusCodes {
 		if code == statusCode {
 			return true
 		}
@@ -452,18 +937,9 @@ func (h *Handler) isRetryableStatus(statusCode int) bool {
 }
 
 // getTTL returns the TTL from endpoint config or falls back to default
-func (h *Handler) getTTL(endpointConfig *config.EndpointCacheConfig) time.Duration {
+func (h *Handler) getTTL(cfg *config.Config, endpointConfig *config.EndpointCacheConfig) time.Duration {
 	if endpointConfig != nil && endpointConfig.TTL > 0 {
 		return endpointConfig.TTL
 	}
-	return h.config.Cache.DefaultTTL
-}
-
-// Health returns a health check handler
-func (h *Handler) Health() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"healthy","service":"api-cache"}`))
-	}
+	return cfg.Cache.DefaultTTL
 }
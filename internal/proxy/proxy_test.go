@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// initLogger initializes logging once, since background work started by one
+// test may still be logging when the next starts
+var initLogger sync.Once
+
+// newTestHandler returns a handler proxying to upstreamURL, backed by an
+// in-memory Valkey that the test can inspect
+func newTestHandler(t *testing.T, cfg *config.Config, upstreamURL string) (*Handler, *miniredis.Miniredis) {
+	t.Helper()
+	initLogger.Do(func() { logger.Init(config.LoggingConfig{Level: "error"}) })
+
+	mr := miniredis.RunT(t)
+	host, port, _ := net.SplitHostPort(mr.Addr())
+	cfg.Valkey.Host = host
+	cfg.Valkey.Port, _ = strconv.Atoi(port)
+	cfg.Upstream.BaseURL = upstreamURL
+	if cfg.Upstream.Timeout == 0 {
+		cfg.Upstream.Timeout = 5 * time.Second
+	}
+	if cfg.Cache.DefaultTTL == 0 {
+		cfg.Cache.DefaultTTL = time.Minute
+	}
+
+	configs := config.NewHolder(cfg)
+	cacheClient, err := cache.NewClient(configs)
+	if err != nil {
+		t.Fatalf("cache.NewClient() error = %v", err)
+	}
+	t.Cleanup(func() { cacheClient.Close() })
+
+	h := NewHandler(cacheClient, configs)
+	t.Cleanup(h.Stop)
+	return h, mr
+}
+
+// countingUpstream is an upstream that counts its calls and, while gate is
+// set, holds the first call until gate is closed. It answers with status, or
+// 200 while status is zero.
+type countingUpstream struct {
+	*httptest.Server
+	calls  atomic.Int32
+	status atomic.Int32
+	// arrived is closed when the first call arrives
+	arrived chan struct{}
+	gate    chan struct{}
+}
+
+func newCountingUpstream(t *testing.T, gated bool) *countingUpstream {
+	t.Helper()
+	u := &countingUpstream{arrived: make(chan struct{})}
+	if gated {
+		u.gate = make(chan struct{})
+	}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u.calls.Add(1) == 1 {
+			close(u.arrived)
+			if u.gate != nil {
+				<-u.gate
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		if status := u.status.Load(); status != 0 {
+			w.WriteHeader(int(status))
+		}
+		w.Write([]byte("body for " + r.URL.Path))
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// serve sends a request through the handler and returns the response
+func serve(h *Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for name, values := range header {
+		r.Header[name] = values
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// storeEntry caches body for a GET of target as the proxy would, fresh for
+// freshFor from now (negative for an entry already stale), and returns its
+// cache key
+func storeEntry(t *testing.T, h *Handler, target, body string, freshFor time.Duration) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	cfg := h.config.Get()
+	match := cfg.GetEndpointCacheConfigMatch(r.URL.Path, r.Method, r.URL.Query())
+	key := h.cache.GenerateCacheKey(r, config.DefaultUpstreamName, match.Config)
+
+	freshUntil := time.Now().Add(freshFor)
+	entry := &cache.CachedResponse{
+		StatusCode: http.StatusOK,
+		Headers:    http.Header{"Content-Type": {"text/plain"}, "Etag": {`"v1"`}},
+		Body:       []byte(body),
+		CachedAt:   freshUntil.Add(-time.Minute),
+		FreshUntil: freshUntil,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+	if match.Config != nil {
+		entry.EndpointID = match.Config.EndpointIdentifier()
+	}
+	if err := h.cache.Set(context.Background(), key, entry, time.Hour); err != nil {
+		t.Fatalf("Set() error = %v", err)
+	}
+	return key
+}
+
+// waitForBody waits until the entry under key holds body
+func waitForBody(t *testing.T, h *Handler, key, body string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		cached, _ := h.cache.Peek(context.Background(), key)
+		if cached != nil && string(cached.Body) == body {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("entry %s was not replaced with %q", key, body)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+// errShuttingDown is returned when a retry wait is cut short by shutdown
+var errShuttingDown = errors.New("proxy shutting down")
+
+// isIdempotent reports whether sending a request with method more than once
+// has the same effect as sending it once (RFC 9110 section 9.2.2)
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retriesAllowed reports whether r may be sent upstream more than once.
+// Non-idempotent methods are only retried when opted in and the client
+// supplied an Idempotency-Key for the upstream to deduplicate on.
+func retriesAllowed(retry config.RetryConfig, r *http.Request) bool {
+	if isIdempotent(r.Method) {
+		return true
+	}
+	return retry.RetryWithIdempotencyKey && r.Header.Get("Idempotency-Key") != ""
+}
+
+// upstreamBody supplies the request body for each upstream attempt
+type upstreamBody struct {
+	// buf is a buffered body, sent again on every attempt
+	buf []byte
+	// stream is a body too large to buffer, which can only be sent once
+	stream io.Reader
+	length int64
+}
+
+// newUpstreamBody prepares r's body for forwarding. When buffer is set, bodies
+// up to limit bytes are read into memory so they can be replayed on retry.
+func newUpstreamBody(r *http.Request, buffer bool, limit int64) (*upstreamBody, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return &upstreamBody{}, nil
+	}
+	if !buffer {
+		return &upstreamBody{stream: r.Body, length: r.ContentLength}, nil
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(r.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errReadRequestBody, err)
+	}
+	if int64(len(buf)) > limit {
+		// Send what was read followed by the rest of the body
+		return &upstreamBody{stream: io.MultiReader(bytes.NewReader(buf), r.Body), length: r.ContentLength}, nil
+	}
+	return &upstreamBody{buf: buf}, nil
+}
+
+// replayable reports whether the body can be sent on more than one attempt
+func (b *upstreamBody) replayable() bool {
+	return b.stream == nil
+}
+
+// newRequest builds the upstream request for one attempt. Buffered bodies get
+// a GetBody so the transport can also replay them.
+func (b *upstreamBody) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	if b.stream != nil {
+		req, err := http.NewRequestWithContext(ctx, method, url, b.stream)
+		if err == nil && b.length > 0 {
+			req.ContentLength = b.length
+		}
+		return req, err
+	}
+	if b.buf == nil {
+		return http.NewRequestWithContext(ctx, method, url, nil)
+	}
+	return http.NewRequestWithContext(ctx, method, url, bytes.NewReader(b.buf))
+}
+
+// backoff computes the waits between upstream attempts of one request
+type backoff struct {
+	retry config.RetryConfig
+	// base is the un-jittered exponential backoff for the next wait
+	base time.Duration
+	// prev is the last wait, which decorrelated jitter grows from
+	prev time.Duration
+	// randN returns a random duration in [0, n]
+	randN func(n time.Duration) time.Duration
+}
+
+func newBackoff(retry config.RetryConfig) *backoff {
+	return &backoff{
+		retry: retry,
+		base:  retry.InitialBackoff,
+		prev:  retry.InitialBackoff,
+		randN: func(n time.Duration) time.Duration {
+			if n <= 0 {
+				return 0
+			}
+			return rand.N(n + 1)
+		},
+	}
+}
+
+// Next returns the wait before the next attempt
+func (b *backoff) Next() time.Duration {
+	var wait time.Duration
+	switch b.retry.Jitter {
+	case config.RetryJitterNone:
+		wait = b.base
+	case config.RetryJitterDecorrelated:
+		// Random between the initial backoff and three times the last wait
+		initial := b.retry.InitialBackoff
+		wait = b.capped(initial + b.randN(max(3*b.prev-initial, 0)))
+		b.prev = wait
+	default:
+		wait = b.randN(b.base)
+	}
+
+	b.base = b.capped(time.Duration(float64(b.base) * b.retry.BackoffMultiplier))
+	return wait
+}
+
+// capped limits d to the configured max backoff, if one is set
+func (b *backoff) capped(d time.Duration) time.Duration {
+	if b.retry.MaxBackoff > 0 && d > b.retry.MaxBackoff {
+		return b.retry.MaxBackoff
+	}
+	return d
+}
+
+// upstreamRetryAfter returns the wait requested by a 429 or 503 response's
+// Retry-After header, given in seconds or as an HTTP date
+func upstreamRetryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return 0, false
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0), true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+// withinBudget reports whether a retry after wait still fits in the retry
+// budget of a request that started at start
+func withinBudget(retry config.RetryConfig, start time.Time, wait time.Duration) bool {
+	return retry.Budget <= 0 || time.Since(start)+wait < retry.Budget
+}
+
+// waitRetry waits before a retry, returning early if the request is cancelled
+// or the handler is stopping
+func (h *Handler) waitRetry(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-h.stopping:
+		return errShuttingDown
+	}
+}
+
+// Stop cuts short pending retry and quota waits so in-flight requests finish
+// promptly during shutdown. Upstream calls already in progress are not interrupted.
+func (h *Handler) Stop() {
+	h.stopOnce.Do(func() { close(h.stopping) })
+}
@@ -0,0 +1,208 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+func TestBackoffNext(t *testing.T) {
+	retry := config.RetryConfig{
+		InitialBackoff:    100 * time.Millisecond,
+		MaxBackoff:        time.Second,
+		BackoffMultiplier: 2,
+	}
+
+	tests := []struct {
+		name   string
+		jitter string
+		// randN picks the top or bottom of the random range
+		randN func(n time.Duration) time.Duration
+		want  []time.Duration
+	}{
+		{
+			name:   "none",
+			jitter: config.RetryJitterNone,
+			want:   []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second},
+		},
+		{
+			name:  "full at top of range",
+			randN: func(n time.Duration) time.Duration { return n },
+			want:  []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, time.Second},
+		},
+		{
+			name:  "full at bottom of range",
+			randN: func(time.Duration) time.Duration { return 0 },
+			want:  []time.Duration{0, 0, 0},
+		},
+		{
+			name:   "decorrelated at top of range",
+			jitter: config.RetryJitterDecorrelated,
+			randN:  func(n time.Duration) time.Duration { return n },
+			want:   []time.Duration{300 * time.Millisecond, 900 * time.Millisecond, time.Second, time.Second},
+		},
+		{
+			name:   "decorrelated at bottom of range",
+			jitter: config.RetryJitterDecorrelated,
+			randN:  func(time.Duration) time.Duration { return 0 },
+			want:   []time.Duration{100 * time.Millisecond, 100 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := retry
+			cfg.Jitter = tt.jitter
+			b := newBackoff(cfg)
+			if tt.randN != nil {
+				b.randN = tt.randN
+			}
+			for i, want := range tt.want {
+				if got := b.Next(); got != want {
+					t.Errorf("wait %d = %v, want %v", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestBackoffFullJitterInRange(t *testing.T) {
+	b := newBackoff(config.RetryConfig{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, BackoffMultiplier: 2})
+	for i := 0; i < 100; i++ {
+		if got := b.Next(); got < 0 || got > time.Second {
+			t.Fatalf("wait %v outside [0, max_backoff]", got)
+		}
+	}
+}
+
+func TestUpstreamRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		status     int
+		retryAfter string
+		want       time.Duration
+		wantOK     bool
+	}{
+		{name: "seconds on 503", status: http.StatusServiceUnavailable, retryAfter: "3", want: 3 * time.Second, wantOK: true},
+		{name: "seconds on 429", status: http.StatusTooManyRequests, retryAfter: " 1 ", want: time.Second, wantOK: true},
+		{name: "http date", status: http.StatusServiceUnavailable, retryAfter: now.Add(5 * time.Second).Format(http.TimeFormat), want: 5 * time.Second, wantOK: true},
+		{name: "date in the past", status: http.StatusServiceUnavailable, retryAfter: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, wantOK: true},
+		{name: "other status", status: http.StatusBadGateway, retryAfter: "3"},
+		{name: "missing", status: http.StatusServiceUnavailable},
+		{name: "malformed", status: http.StatusServiceUnavailable, retryAfter: "soon"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			got, ok := upstreamRetryAfter(resp, now)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("upstreamRetryAfter() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRetriesAllowed(t *testing.T) {
+	tests := []struct {
+		name           string
+		method         string
+		idempotencyKey string
+		optIn          bool
+		want           bool
+	}{
+		{name: "get", method: http.MethodGet, want: true},
+		{name: "put", method: http.MethodPut, want: true},
+		{name: "delete", method: http.MethodDelete, want: true},
+		{name: "post", method: http.MethodPost},
+		{name: "post with key but not opted in", method: http.MethodPost, idempotencyKey: "abc"},
+		{name: "post opted in without key", method: http.MethodPost, optIn: true},
+		{name: "post opted in with key", method: http.MethodPost, idempotencyKey: "abc", optIn: true, want: true},
+		{name: "patch opted in with key", method: http.MethodPatch, idempotencyKey: "abc", optIn: true, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api", nil)
+			if tt.idempotencyKey != "" {
+				r.Header.Set("Idempotency-Key", tt.idempotencyKey)
+			}
+			if got := retriesAllowed(config.RetryConfig{RetryWithIdempotencyKey: tt.optIn}, r); got != tt.want {
+				t.Errorf("retriesAllowed() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// readAttempt builds the upstream request for one attempt and returns its body
+func readAttempt(t *testing.T, body *upstreamBody) string {
+	t.Helper()
+	req, err := body.newRequest(t.Context(), http.MethodPost, "http://upstream/api")
+	if err != nil {
+		t.Fatalf("newRequest() error = %v", err)
+	}
+	if req.Body == nil {
+		return ""
+	}
+	data, err := io.ReadAll(req.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	return string(data)
+}
+
+func TestUpstreamBodyReplay(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/api", strings.NewReader(`{"id":1}`))
+	body, err := newUpstreamBody(r, true, 1024)
+	if err != nil {
+		t.Fatalf("newUpstreamBody() error = %v", err)
+	}
+	if !body.replayable() {
+		t.Fatal("small body should be replayable")
+	}
+	for attempt := 1; attempt <= 2; attempt++ {
+		if got := readAttempt(t, body); got != `{"id":1}` {
+			t.Errorf("attempt %d body = %q, want full body", attempt, got)
+		}
+	}
+
+	req, _ := body.newRequest(t.Context(), http.MethodPost, "http://upstream/api")
+	if req.GetBody == nil || req.ContentLength != 8 {
+		t.Errorf("buffered request GetBody set = %v, ContentLength = %d, want GetBody and 8", req.GetBody != nil, req.ContentLength)
+	}
+}
+
+func TestUpstreamBodyOverLimit(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPut, "/api", strings.NewReader("0123456789"))
+	body, err := newUpstreamBody(r, true, 4)
+	if err != nil {
+		t.Fatalf("newUpstreamBody() error = %v", err)
+	}
+	if body.replayable() {
+		t.Error("body over the limit should not be replayable")
+	}
+	if got := readAttempt(t, body); got != "0123456789" {
+		t.Errorf("body = %q, want the whole body sent once", got)
+	}
+}
+
+func TestUpstreamBodyEmpty(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api", nil)
+	body, err := newUpstreamBody(r, true, 1024)
+	if err != nil {
+		t.Fatalf("newUpstreamBody() error = %v", err)
+	}
+	if !body.replayable() || readAttempt(t, body) != "" {
+		t.Error("request without a body should be replayable and send no body")
+	}
+}
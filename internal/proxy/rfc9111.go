@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// resolveVariantKey returns the key to look up for a request when the upstream has
+// previously responded with a Vary header for this base cache key.
+func (h *Handler) resolveVariantKey(ctx context.Context, r *http.Request, baseKey, requestID string) string {
+	vary, err := h.cache.GetVary(ctx, baseKey)
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"request_id": requestID,
+			"error":      err,
+			"cache_key":  baseKey,
+		}).Error("Failed to get Vary headers from cache")
+		return baseKey
+	}
+	return cache.VariantKey(baseKey, r.Header, vary)
+}
+
+// upstreamCachePolicy applies the upstream's Cache-Control, Expires, and Vary headers.
+// It returns the freshness TTL (clamped to cache.max_ttl), the key to store the
+// response under, the Vary header list, and whether the response may be cached.
+func (h *Handler) upstreamCachePolicy(r *http.Request, cfg *config.Config, header http.Header, cacheKey string, ttl time.Duration, now time.Time) (time.Duration, string, []string, bool) {
+	policy := cache.ParseUpstreamPolicy(header, now)
+	if policy.NoStore {
+		return ttl, cacheKey, nil, false
+	}
+
+	if policy.HasTTL {
+		ttl = policy.TTL
+		if maxTTL := cfg.Cache.MaxTTL; maxTTL > 0 && ttl > maxTTL {
+			ttl = maxTTL
+		}
+	}
+	if ttl <= 0 {
+		return ttl, cacheKey, nil, false
+	}
+
+	storeKey := cache.VariantKey(cache.BaseKey(cacheKey), r.Header, policy.Vary)
+	return ttl, storeKey, policy.Vary, true
+}
+
+// sharedResultApplies reports whether a response fetched for another request can be
+// served to r. With upstream cache headers respected, a response carrying Vary is
+// only shared with requests that map to the same variant.
+func (h *Handler) sharedResultApplies(r *http.Request, cfg *config.Config, cacheKey string, res *upstreamResult) bool {
+	if !cfg.Cache.RespectUpstreamCacheHeaders || res.StoreKey == "" {
+		return true
+	}
+	vary := cache.ParseVary(res.Header)
+	return cache.VariantKey(cache.BaseKey(cacheKey), r.Header, vary) == res.StoreKey
+}
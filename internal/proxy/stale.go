@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/breaker"
+	"github.com/singh-gur/api_cache/internal/cache"
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/quota"
+)
+
+// serveStaleOnError serves a stale cache entry in place of a failed upstream fetch
+// (transport error or 5xx) when the endpoint's stale-if-error window still covers it.
+// When the upstream quota is exhausted or its circuit is open, any stale entry
+// still stored is served.
+// It returns true if a response was written.
+func (h *Handler) serveStaleOnError(w http.ResponseWriter, r *http.Request, res *upstreamResult, err error, stale *cache.CachedResponse, cacheKey string, endpointConfig *config.EndpointCacheConfig, match config.EndpointMatch, requestID string, startTime time.Time) bool {
+	if stale == nil {
+		return false
+	}
+	if !errors.Is(err, quota.ErrExhausted) && !errors.Is(err, breaker.ErrOpen) {
+		if endpointConfig == nil || endpointConfig.StaleIfError <= 0 {
+			return false
+		}
+		if err == nil && res.StatusCode < http.StatusInternalServerError {
+			return false
+		}
+		if !time.Now().Before(stale.FreshUntil.Add(endpointConfig.StaleIfError)) {
+			return false
+		}
+	}
+
+	fields := map[string]interface{}{
+		"request_id": requestID,
+		"cache_key":  cacheKey,
+		"path":       r.URL.Path,
+		"query":      h.sanitizeQuery(r),
+		"stale_for":  time.Since(stale.FreshUntil).Seconds(),
+	}
+	if err != nil {
+		fields["error"] = err
+	} else {
+		fields["upstream_status"] = res.StatusCode
+	}
+	logger.WithFields(fields).Warn("Upstream failed, serving stale response")
+
+	h.serveCachedResponse(w, r, stale, "STALE", cacheKey, match, requestID, startTime)
+	return true
+}
+
+// revalidateInBackground refreshes a stale or soon-to-expire entry from upstream
+// without blocking the caller. Refreshes are deduplicated per cache key with
+// concurrent misses.
+func (h *Handler) revalidateInBackground(r *http.Request, ctx context.Context, cfg *config.Config, cacheKey string, endpointConfig *config.EndpointCacheConfig, match config.EndpointMatch, stale *cache.CachedResponse, requestID string) {
+	call, leader := h.flights.join(cacheKey)
+	if !leader {
+		return
+	}
+
+	bgCtx := context.WithoutCancel(ctx)
+	bgReq := r.Clone(bgCtx)
+
+	go func() {
+		res, err := h.fetchAndCache(bgReq, bgCtx, cfg, cacheKey, endpointConfig, match, stale, requestID)
+		h.flights.finish(cacheKey, call, res, err)
+
+		fields := map[string]interface{}{
+			"request_id": requestID,
+			"cache_key":  cacheKey,
+			"path":       bgReq.URL.Path,
+		}
+		if err != nil {
+			fields["error"] = err
+			logger.WithFields(fields).Warn("Background revalidation failed")
+			return
+		}
+		fields["status"] = res.StatusCode
+		fields["cached"] = res.Cached
+		fields["revalidated"] = res.Revalidated
+		logger.WithFields(fields).Debug("Background revalidation complete")
+	}()
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+// staleConfig caches /items for a minute with the given stale windows
+func staleConfig(whileRevalidate, ifError time.Duration) *config.Config {
+	cfg := &config.Config{}
+	cfg.Cache.Endpoints = []config.EndpointCacheConfig{{
+		Path:                 "/items",
+		Methods:              []string{http.MethodGet},
+		TTL:                  time.Minute,
+		StaleWhileRevalidate: whileRevalidate,
+		StaleIfError:         ifError,
+	}}
+	return cfg
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	upstream := newCountingUpstream(t, false)
+	h, _ := newTestHandler(t, staleConfig(time.Minute, 0), upstream.URL)
+	key := storeEntry(t, h, "/items", "old", -10*time.Second)
+
+	w := serve(h, http.MethodGet, "/items", nil)
+	if w.Header().Get("X-Cache") != "STALE" || w.Body.String() != "old" {
+		t.Fatalf("response = X-Cache %q %q, want the stale entry", w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	// The background refresh replaces the entry, which later requests hit
+	waitForBody(t, h, key, "body for /items")
+	w = serve(h, http.MethodGet, "/items", nil)
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "body for /items" {
+		t.Errorf("response = X-Cache %q %q, want the refreshed entry", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if got := upstream.calls.Load(); got != 1 {
+		t.Errorf("upstream called %d times, want 1", got)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	tests := []struct {
+		name string
+		// fail makes the upstream fail, with a 5xx or by going away
+		fail func(*countingUpstream)
+	}{
+		{name: "upstream 5xx", fail: func(u *countingUpstream) { u.status.Store(http.StatusServiceUnavailable) }},
+		{name: "transport error", fail: func(u *countingUpstream) { u.Close() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := newCountingUpstream(t, false)
+			h, _ := newTestHandler(t, staleConfig(0, time.Minute), upstream.URL)
+			storeEntry(t, h, "/items", "old", -10*time.Second)
+			tt.fail(upstream)
+
+			w := serve(h, http.MethodGet, "/items", nil)
+			if w.Code != http.StatusOK || w.Header().Get("X-Cache") != "STALE" || w.Body.String() != "old" {
+				t.Errorf("response = %d X-Cache %q %q, want the stale entry", w.Code, w.Header().Get("X-Cache"), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestPastStaleWindowsGoesToOrigin(t *testing.T) {
+	upstream := newCountingUpstream(t, false)
+	h, _ := newTestHandler(t, staleConfig(time.Minute, time.Minute), upstream.URL)
+
+	// Past the stale-while-revalidate window the client waits for the origin
+	storeEntry(t, h, "/items", "old", -2*time.Minute)
+	w := serve(h, http.MethodGet, "/items", nil)
+	if w.Header().Get("X-Cache") != "MISS" || w.Body.String() != "body for /items" {
+		t.Errorf("response = X-Cache %q %q, want the origin's response", w.Header().Get("X-Cache"), w.Body.String())
+	}
+
+	// Past the stale-if-error window the origin's error is passed on
+	storeEntry(t, h, "/items", "old", -2*time.Minute)
+	upstream.status.Store(http.StatusServiceUnavailable)
+	w = serve(h, http.MethodGet, "/items", nil)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("X-Cache") == "STALE" {
+		t.Errorf("response = %d X-Cache %q, want the origin's 503", w.Code, w.Header().Get("X-Cache"))
+	}
+	if got := upstream.calls.Load(); got != 2 {
+		t.Errorf("upstream called %d times, want 2", got)
+	}
+}
+
+// refreshConfig caches /items for a minute, renewing hits past fraction of it
+func refreshConfig(fraction float64) *config.Config {
+	cfg := staleConfig(0, 0)
+	cfg.Cache.Endpoints[0].RefreshAhead = fraction
+	return cfg
+}
+
+func TestRefreshAhead(t *testing.T) {
+	upstream := newCountingUpstream(t, true)
+	h, _ := newTestHandler(t, refreshConfig(0.5), upstream.URL)
+	// Cached 50s of its minute ago, so past the refresh window
+	key := storeEntry(t, h, "/items", "old", 10*time.Second)
+
+	// Concurrent hits are all served from cache while a single refresh runs
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := serve(h, http.MethodGet, "/items", nil)
+			if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "old" {
+				t.Errorf("response = X-Cache %q %q, want the cached entry", w.Header().Get("X-Cache"), w.Body.String())
+			}
+		}()
+	}
+	wg.Wait()
+	select {
+	case <-upstream.arrived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("hits past the refresh window did not refresh the entry")
+	}
+	close(upstream.gate)
+
+	// The refreshed entry replaces the old one, and is not due for refresh
+	waitForBody(t, h, key, "body for /items")
+	w := serve(h, http.MethodGet, "/items", nil)
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "body for /items" {
+		t.Errorf("response = X-Cache %q %q, want the refreshed entry", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := upstream.calls.Load(); got != 1 {
+		t.Errorf("upstream called %d times, want 1", got)
+	}
+}
+
+func TestRefreshAheadWaitsForWindow(t *testing.T) {
+	upstream := newCountingUpstream(t, false)
+	h, _ := newTestHandler(t, refreshConfig(0.9), upstream.URL)
+	// Cached 50s of its minute ago, short of the refresh window
+	storeEntry(t, h, "/items", "old", 10*time.Second)
+
+	w := serve(h, http.MethodGet, "/items", nil)
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "old" {
+		t.Errorf("response = X-Cache %q %q, want the cached entry", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	time.Sleep(50 * time.Millisecond)
+	if got := upstream.calls.Load(); got != 0 {
+		t.Errorf("upstream called %d times before the refresh window, want 0", got)
+	}
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+)
+
+// clientPool holds an http.Client per upstream. A client is rebuilt when its
+// upstream's connection settings change on reload.
+type clientPool struct {
+	mu      sync.Mutex
+	clients map[string]*pooledClient
+}
+
+// pooledClient is a client and the settings it was built with
+type pooledClient struct {
+	settings clientSettings
+	client   *http.Client
+}
+
+// clientSettings are the upstream settings a client is built from
+type clientSettings struct {
+	timeout         time.Duration
+	maxIdleConns    int
+	maxConnsPerHost int
+}
+
+func newClientPool() *clientPool {
+	return &clientPool{clients: make(map[string]*pooledClient)}
+}
+
+// get returns the client for an upstream, building it on first use
+func (p *clientPool) get(upstream config.NamedUpstreamConfig) *http.Client {
+	settings := clientSettings{
+		timeout:         upstream.Timeout,
+		maxIdleConns:    upstream.MaxIdleConns,
+		maxConnsPerHost: upstream.MaxConnsPerHost,
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pooled, ok := p.clients[upstream.Name]; ok {
+		if pooled.settings == settings {
+			return pooled.client
+		}
+		pooled.client.CloseIdleConnections()
+	}
+
+	client := &http.Client{
+		Timeout: upstream.Timeout,
+		Transport: &http.Transport{
+			MaxIdleConns:        upstream.MaxIdleConns,
+			MaxConnsPerHost:     upstream.MaxConnsPerHost,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+	p.clients[upstream.Name] = &pooledClient{settings: settings, client: client}
+	return client
+}
+
+// balanceKey returns what consistent_hash balancing hashes for a request to up
+func balanceKey(r *http.Request, up config.NamedUpstreamConfig) string {
+	switch {
+	case up.Balance != config.BalanceConsistentHash:
+		return ""
+	case strings.HasPrefix(up.HashBy, config.HashByHeader):
+		return r.Header.Get(strings.TrimPrefix(up.HashBy, config.HashByHeader))
+	case strings.HasPrefix(up.HashBy, config.HashByQuery):
+		return r.URL.Query().Get(strings.TrimPrefix(up.HashBy, config.HashByQuery))
+	default:
+		return r.URL.RequestURI()
+	}
+}
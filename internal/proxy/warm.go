@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/warmer"
+)
+
+// Warmer returns the handler's cache warmer, for the admin API
+func (h *Handler) Warmer() *warmer.Warmer {
+	return h.warmer
+}
+
+// warmRequest warms one request for the cache warmer. It resolves the cache
+// key, coalesces with client requests for the same key, and fetches through
+// the same path as a client GET, so upstream quotas, circuit breakers, and
+// retries all apply.
+func (h *Handler) warmRequest(ctx context.Context, req warmer.Request, requestID string) (warmer.Result, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, req.URI, nil)
+	if err != nil {
+		return "", err
+	}
+	if req.Header != nil {
+		r.Header = req.Header.Clone()
+	}
+
+	cfg := h.config.Get()
+	match := cfg.GetEndpointCacheConfigMatch(r.URL.Path, r.Method, r.URL.Query())
+	endpointConfig := match.Config
+	upstream := cfg.ResolveUpstream(r.URL.Path).Upstream.Name
+	cacheKey := h.cache.GenerateCacheKey(r, upstream, endpointConfig)
+	if cfg.Cache.RespectUpstreamCacheHeaders {
+		cacheKey = h.resolveVariantKey(ctx, r, cacheKey, requestID)
+	}
+
+	cached, err := h.cache.Get(ctx, cacheKey)
+	if err != nil {
+		return "", err
+	}
+	if cached != nil && cached.IsFresh(time.Now()) {
+		return warmer.Fresh, nil
+	}
+
+	// A client request is already fetching this key
+	call, leader := h.flights.join(cacheKey)
+	if !leader {
+		return warmer.Skipped, nil
+	}
+
+	// Revalidate an expired entry rather than downloading it again
+	var res *upstreamResult
+	if cfg.Cache.Coalescing.Enabled {
+		res, err = h.fetchCoalesced(r, ctx, cfg, cacheKey, endpointConfig, match, cached, requestID)
+	} else {
+		res, err = h.fetchAndCache(r, ctx, cfg, cacheKey, endpointConfig, match, cached, requestID)
+	}
+	h.flights.finish(cacheKey, call, res, err)
+
+	switch {
+	case err != nil:
+		return "", err
+	case res.FromPeer:
+		return warmer.Fresh, nil
+	case !res.Cached:
+		return warmer.Skipped, nil
+	default:
+		return warmer.Warmed, nil
+	}
+}
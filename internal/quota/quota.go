@@ -0,0 +1,338 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+// ErrExhausted is matched by errors returned when the upstream quota is used up
+// and the window does not reset within max_wait
+var ErrExhausted = errors.New("upstream quota exhausted")
+
+// ExhaustedError reports which quota window is used up and when it resets
+type ExhaustedError struct {
+	Window     string
+	RetryAfter time.Duration
+}
+
+func (e *ExhaustedError) Error() string {
+	return fmt.Sprintf("upstream %s quota exhausted, resets in %s", e.Window, e.RetryAfter.Round(time.Second))
+}
+
+// Is makes errors.Is(err, ErrExhausted) match
+func (e *ExhaustedError) Is(target error) bool {
+	return target == ErrExhausted
+}
+
+// storeTimeout bounds each shared quota check
+const storeTimeout = 250 * time.Millisecond
+
+// storeRetryInterval is how long local counting is used after the shared store
+// fails before it is tried again
+const storeRetryInterval = 5 * time.Second
+
+// Store counts upstream calls in fixed windows shared by all replicas.
+// ReserveQuota records one call in every window if all are below their limits,
+// and returns the window counts after the call (or the current counts if the
+// call was rejected) and whether it was recorded.
+type Store interface {
+	ReserveQuota(ctx context.Context, keys []string, limits []int, ttls []time.Duration) ([]int, bool, error)
+}
+
+// Usage describes the quota left after a call was admitted
+type Usage struct {
+	// Limited is set when a quota applies; Remaining is meaningless otherwise
+	Limited bool
+	// Remaining is the number of calls left in the tightest window
+	Remaining int
+}
+
+// window is one fixed quota window at a point in time
+type window struct {
+	name    string
+	key     string
+	limit   int
+	resetAt time.Time
+}
+
+// windowsAt returns the configured windows containing now. Windows are aligned
+// to UTC minute and day boundaries, like most upstream quotas.
+func windowsAt(cfg config.QuotaConfig, now time.Time) []window {
+	now = now.UTC()
+	var windows []window
+	if cfg.PerMinute > 0 {
+		start := now.Truncate(time.Minute)
+		windows = append(windows, window{
+			name:    "minute",
+			key:     fmt.Sprintf("minute:%d", start.Unix()),
+			limit:   cfg.PerMinute,
+			resetAt: start.Add(time.Minute),
+		})
+	}
+	if cfg.PerDay > 0 {
+		start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		windows = append(windows, window{
+			name:    "day",
+			key:     "day:" + start.Format("20060102"),
+			limit:   cfg.PerDay,
+			resetAt: start.AddDate(0, 0, 1),
+		})
+	}
+	return windows
+}
+
+// Tracker admits upstream calls within each upstream's per-minute and per-day
+// quotas
+type Tracker struct {
+	config *config.Holder
+	// store backs distributed quotas; it may be nil
+	store          Store
+	local          *localStore
+	storeDownUntil atomic.Int64
+	storeDown      atomic.Bool
+	// stopping is closed on shutdown to cut short waits for a window reset
+	stopping <-chan struct{}
+
+	// mu guards the last observed quota state of each upstream, reported by
+	// Statuses
+	mu     sync.Mutex
+	states map[string]*state
+}
+
+// state is the last observed quota state of one upstream
+type state struct {
+	lastRemaining  int
+	lastResetAt    time.Time
+	exhaustedUntil time.Time
+}
+
+// Status is the last observed quota state, for health reporting
+type Status struct {
+	Enabled bool `json:"enabled"`
+	// Remaining is the number of calls left in the tightest window when the
+	// last call was admitted; it is omitted once that window has reset
+	Remaining *int `json:"remaining,omitempty"`
+	// Exhausted is set while a window that refused a call has not reset
+	Exhausted       bool    `json:"exhausted"`
+	ResetsInSeconds float64 `json:"resets_in_seconds,omitempty"`
+}
+
+// NewTracker creates a quota tracker. store backs distributed quotas and may
+// be nil, in which case calls are counted per replica. Closing stopping ends
+// pending waits for a window reset, which then fail as exhausted.
+func NewTracker(configs *config.Holder, store Store, stopping <-chan struct{}) *Tracker {
+	return &Tracker{
+		config:   configs,
+		store:    store,
+		local:    newLocalStore(),
+		stopping: stopping,
+		states:   make(map[string]*state),
+	}
+}
+
+// Acquire reserves one call to upstream. If a window is used up and resets
+// within max_wait, it waits for the reset; otherwise, or if the tracker is
+// stopped while waiting, it returns an *ExhaustedError.
+func (t *Tracker) Acquire(ctx context.Context, upstream config.NamedUpstreamConfig) (Usage, error) {
+	cfg := upstream.Quota
+	if !cfg.Enabled {
+		return Usage{}, nil
+	}
+
+	deadline := time.Now().Add(cfg.MaxWait)
+	for {
+		now := time.Now()
+		windows := windowsAt(cfg, now)
+
+		counts, ok := t.reserve(ctx, upstream.Name, cfg, windows, now)
+		if ok {
+			usage := Usage{Limited: true, Remaining: -1}
+			var resetAt time.Time
+			for i, w := range windows {
+				if remaining := w.limit - counts[i]; usage.Remaining < 0 || remaining < usage.Remaining {
+					usage.Remaining = remaining
+					resetAt = w.resetAt
+				}
+			}
+			t.mu.Lock()
+			st := t.state(upstream.Name)
+			st.lastRemaining, st.lastResetAt, st.exhaustedUntil = usage.Remaining, resetAt, time.Time{}
+			t.mu.Unlock()
+			return usage, nil
+		}
+
+		// Wait for the latest reset among the used-up windows
+		var exhausted window
+		for i, w := range windows {
+			if counts[i] >= w.limit && w.resetAt.After(exhausted.resetAt) {
+				exhausted = w
+			}
+		}
+		if exhausted.resetAt.After(deadline) {
+			return Usage{}, t.exhausted(upstream.Name, exhausted, now)
+		}
+
+		logger.WithFields(map[string]interface{}{
+			"upstream": upstream.Name,
+			"window":   exhausted.name,
+			"wait":     exhausted.resetAt.Sub(now).Seconds(),
+		}).Debug("Upstream quota exhausted, waiting for window reset")
+
+		timer := time.NewTimer(exhausted.resetAt.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Usage{}, ctx.Err()
+		case <-t.stopping:
+			timer.Stop()
+			return Usage{}, t.exhausted(upstream.Name, exhausted, time.Now())
+		case <-timer.C:
+		}
+	}
+}
+
+// exhausted records that w refused a call to upstream and returns the error
+// reporting it
+func (t *Tracker) exhausted(upstream string, w window, now time.Time) error {
+	t.mu.Lock()
+	t.state(upstream).exhaustedUntil = w.resetAt
+	t.mu.Unlock()
+	return &ExhaustedError{Window: w.name, RetryAfter: w.resetAt.Sub(now)}
+}
+
+// state returns the quota state of an upstream. The caller must hold mu.
+func (t *Tracker) state(upstream string) *state {
+	st, ok := t.states[upstream]
+	if !ok {
+		st = &state{}
+		t.states[upstream] = st
+	}
+	return st
+}
+
+// Statuses returns the quota state observed by the most recent calls to each
+// upstream with a quota, keyed by upstream name
+func (t *Tracker) Statuses() map[string]Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	statuses := make(map[string]Status)
+	for _, up := range t.config.Get().AllUpstreams() {
+		if !up.Quota.Enabled {
+			continue
+		}
+		status := Status{Enabled: true}
+		if st, ok := t.states[up.Name]; ok {
+			if now.Before(st.lastResetAt) {
+				remaining := st.lastRemaining
+				status.Remaining = &remaining
+			}
+			if now.Before(st.exhaustedUntil) {
+				status.Exhausted = true
+				status.ResetsInSeconds = st.exhaustedUntil.Sub(now).Seconds()
+			}
+		}
+		statuses[up.Name] = status
+	}
+	return statuses
+}
+
+// storeKey namespaces a window by upstream. The default upstream keeps the
+// keys it had before routes existed.
+func storeKey(upstream string, w window) string {
+	if upstream == config.DefaultUpstreamName {
+		return w.key
+	}
+	return upstream + ":" + w.key
+}
+
+// reserve records a call in the shared store when distributed quotas are
+// enabled, falling back to local counting while the store is unavailable
+func (t *Tracker) reserve(ctx context.Context, upstream string, cfg config.QuotaConfig, windows []window, now time.Time) ([]int, bool) {
+	keys := make([]string, len(windows))
+	limits := make([]int, len(windows))
+	ttls := make([]time.Duration, len(windows))
+	for i, w := range windows {
+		keys[i] = storeKey(upstream, w)
+		limits[i] = w.limit
+		// Keep a little past the reset so replicas with skewed clocks agree
+		ttls[i] = w.resetAt.Sub(now) + time.Minute
+	}
+
+	if cfg.Distributed && t.store != nil && now.UnixNano() >= t.storeDownUntil.Load() {
+		storeCtx, cancel := context.WithTimeout(ctx, storeTimeout)
+		counts, ok, err := t.store.ReserveQuota(storeCtx, keys, limits, ttls)
+		cancel()
+
+		if err == nil {
+			if t.storeDown.CompareAndSwap(true, false) {
+				logger.Log.Info("Distributed upstream quota recovered, sharing counts across replicas again")
+			}
+			return counts, ok
+		}
+
+		t.storeDownUntil.Store(time.Now().Add(storeRetryInterval).UnixNano())
+		if t.storeDown.CompareAndSwap(false, true) {
+			logger.WithFields(map[string]interface{}{
+				"error":       err,
+				"retry_after": storeRetryInterval.Seconds(),
+			}).Warn("Distributed upstream quota unavailable, falling back to per-replica counting")
+		}
+	}
+
+	return t.local.reserve(keys, limits, ttls, now)
+}
+
+// localStore counts calls per window in process memory
+type localStore struct {
+	mu      sync.Mutex
+	counts  map[string]int
+	expires map[string]time.Time
+}
+
+func newLocalStore() *localStore {
+	return &localStore{
+		counts:  make(map[string]int),
+		expires: make(map[string]time.Time),
+	}
+}
+
+func (s *localStore) reserve(keys []string, limits []int, ttls []time.Duration, now time.Time) ([]int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop windows that have ended
+	for key, expiresAt := range s.expires {
+		if !now.Before(expiresAt) {
+			delete(s.counts, key)
+			delete(s.expires, key)
+		}
+	}
+
+	counts := make([]int, len(keys))
+	ok := true
+	for i, key := range keys {
+		counts[i] = s.counts[key]
+		if counts[i] >= limits[i] {
+			ok = false
+		}
+	}
+	if !ok {
+		return counts, false
+	}
+
+	for i, key := range keys {
+		s.counts[key]++
+		counts[i] = s.counts[key]
+		s.expires[key] = now.Add(ttls[i])
+	}
+	return counts, true
+}
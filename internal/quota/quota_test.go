@@ -0,0 +1,177 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+)
+
+func TestWindowsAt(t *testing.T) {
+	now := time.Date(2024, 3, 15, 13, 45, 30, 0, time.UTC)
+	windows := windowsAt(config.QuotaConfig{PerMinute: 5, PerDay: 500}, now)
+	if len(windows) != 2 {
+		t.Fatalf("got %d windows, want 2", len(windows))
+	}
+
+	if want := time.Date(2024, 3, 15, 13, 46, 0, 0, time.UTC); !windows[0].resetAt.Equal(want) {
+		t.Errorf("minute window resets at %v, want %v", windows[0].resetAt, want)
+	}
+	if want := time.Date(2024, 3, 16, 0, 0, 0, 0, time.UTC); !windows[1].resetAt.Equal(want) {
+		t.Errorf("day window resets at %v, want %v", windows[1].resetAt, want)
+	}
+	if windows[1].key != "day:20240315" {
+		t.Errorf("day window key = %q, want day:20240315", windows[1].key)
+	}
+
+	if got := windowsAt(config.QuotaConfig{PerDay: 500}, now); len(got) != 1 || got[0].name != "day" {
+		t.Errorf("expected only a day window, got %+v", got)
+	}
+}
+
+func TestTrackerAcquire(t *testing.T) {
+	cfg := &config.Config{Upstream: config.UpstreamConfig{Quota: config.QuotaConfig{
+		Enabled: true,
+		PerDay:  2,
+	}}}
+	tracker := NewTracker(config.NewHolder(cfg), nil, nil)
+	upstream := cfg.AllUpstreams()[0]
+
+	for want := 1; want >= 0; want-- {
+		usage, err := tracker.Acquire(context.Background(), upstream)
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		if !usage.Limited || usage.Remaining != want {
+			t.Errorf("usage = %+v, want %d remaining", usage, want)
+		}
+	}
+
+	_, err := tracker.Acquire(context.Background(), upstream)
+	if !errors.Is(err, ErrExhausted) {
+		t.Fatalf("Acquire error = %v, want ErrExhausted", err)
+	}
+	var exhausted *ExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.Window != "day" || exhausted.RetryAfter <= 0 {
+		t.Errorf("unexpected exhausted error: %+v", exhausted)
+	}
+
+	status := tracker.Statuses()[config.DefaultUpstreamName]
+	if !status.Enabled || status.Remaining == nil || *status.Remaining != 0 || !status.Exhausted || status.ResetsInSeconds <= 0 {
+		t.Errorf("status = %+v, want exhausted with 0 remaining", status)
+	}
+}
+
+func TestTrackerCountsUpstreamsSeparately(t *testing.T) {
+	quota := config.QuotaConfig{Enabled: true, PerMinute: 1}
+	cfg := &config.Config{
+		Upstream:  config.UpstreamConfig{Quota: quota},
+		Upstreams: []config.NamedUpstreamConfig{{Name: "billing", UpstreamConfig: config.UpstreamConfig{Quota: quota}}, {Name: "search"}},
+	}
+	tracker := NewTracker(config.NewHolder(cfg), nil, nil)
+	upstreams := cfg.AllUpstreams()
+
+	for _, up := range upstreams[:2] {
+		if _, err := tracker.Acquire(context.Background(), up); err != nil {
+			t.Errorf("first call to %s: %v", up.Name, err)
+		}
+	}
+	if _, err := tracker.Acquire(context.Background(), upstreams[1]); !errors.Is(err, ErrExhausted) {
+		t.Errorf("second call to billing: error = %v, want ErrExhausted", err)
+	}
+	for range 3 {
+		if usage, err := tracker.Acquire(context.Background(), upstreams[2]); err != nil || usage.Limited {
+			t.Errorf("call to search without a quota = %+v, %v, want unlimited", usage, err)
+		}
+	}
+
+	statuses := tracker.Statuses()
+	if len(statuses) != 2 || !statuses["billing"].Exhausted || statuses[config.DefaultUpstreamName].Exhausted {
+		t.Errorf("statuses = %+v, want billing exhausted and default not", statuses)
+	}
+}
+
+func TestTrackerStopEndsWait(t *testing.T) {
+	logger.Init(config.LoggingConfig{Level: "error"})
+	cfg := &config.Config{Upstream: config.UpstreamConfig{Quota: config.QuotaConfig{
+		Enabled: true,
+		PerDay:  1,
+		MaxWait: 48 * time.Hour,
+	}}}
+	stopping := make(chan struct{})
+	tracker := NewTracker(config.NewHolder(cfg), nil, stopping)
+	upstream := cfg.AllUpstreams()[0]
+
+	if _, err := tracker.Acquire(context.Background(), upstream); err != nil {
+		t.Fatalf("first Acquire failed: %v", err)
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := tracker.Acquire(context.Background(), upstream)
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(stopping)
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrExhausted) {
+			t.Errorf("Acquire error after stop = %v, want ErrExhausted", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Acquire still waiting for the window reset after stop")
+	}
+	if status := tracker.Statuses()[config.DefaultUpstreamName]; !status.Exhausted {
+		t.Errorf("status = %+v, want exhausted", status)
+	}
+}
+
+func TestStoreKey(t *testing.T) {
+	w := window{key: "day:20240315"}
+	if got := storeKey(config.DefaultUpstreamName, w); got != "day:20240315" {
+		t.Errorf("default upstream key = %q, want the unprefixed window key", got)
+	}
+	if got := storeKey("billing", w); got != "billing:day:20240315" {
+		t.Errorf("named upstream key = %q, want billing:day:20240315", got)
+	}
+}
+
+func TestTrackerDisabled(t *testing.T) {
+	cfg := &config.Config{}
+	tracker := NewTracker(config.NewHolder(cfg), nil, nil)
+	usage, err := tracker.Acquire(context.Background(), cfg.AllUpstreams()[0])
+	if err != nil || usage.Limited {
+		t.Errorf("Acquire() = %+v, %v; want unlimited", usage, err)
+	}
+	if statuses := tracker.Statuses(); len(statuses) != 0 {
+		t.Errorf("Statuses() = %+v, want none", statuses)
+	}
+}
+
+func TestLocalStoreRejectsWithoutCounting(t *testing.T) {
+	store := newLocalStore()
+	now := time.Now()
+	keys := []string{"minute:1", "day:1"}
+	limits := []int{1, 10}
+	ttls := []time.Duration{time.Minute, time.Hour}
+
+	if _, ok := store.reserve(keys, limits, ttls, now); !ok {
+		t.Fatal("first call rejected")
+	}
+	counts, ok := store.reserve(keys, limits, ttls, now)
+	if ok {
+		t.Fatal("call over the minute limit admitted")
+	}
+	if counts[1] != 1 {
+		t.Errorf("rejected call counted against the day window: %v", counts)
+	}
+
+	// Windows are forgotten once they expire
+	if _, ok := store.reserve(keys, limits, ttls, now.Add(2*time.Hour)); !ok {
+		t.Error("call rejected after windows expired")
+	}
+}
@@ -0,0 +1,85 @@
+package warmer
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+)
+
+// maxTracked bounds how many distinct URLs a replica counts. Once full, new
+// URLs are ignored until counts decay.
+const maxTracked = 10000
+
+// popularity counts requests per URL. counts decay after every warming run so
+// recent traffic outweighs old; pending holds what has not yet been added to
+// the shared store, when there is one.
+type popularity struct {
+	mu      sync.Mutex
+	shared  bool
+	counts  map[string]int
+	pending map[string]int
+}
+
+func newPopularity(shared bool) *popularity {
+	return &popularity{
+		shared:  shared,
+		counts:  make(map[string]int),
+		pending: make(map[string]int),
+	}
+}
+
+// record counts one request for uri
+func (p *popularity) record(uri string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.counts[uri]; !ok && len(p.counts) >= maxTracked {
+		return
+	}
+	p.counts[uri]++
+	if p.shared {
+		p.pending[uri]++
+	}
+}
+
+// top returns the n most requested URLs, most requested first
+func (p *popularity) top(n int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	uris := make([]string, 0, len(p.counts))
+	for uri := range p.counts {
+		uris = append(uris, uri)
+	}
+	slices.SortFunc(uris, func(a, b string) int {
+		if c := cmp.Compare(p.counts[b], p.counts[a]); c != 0 {
+			return c
+		}
+		return cmp.Compare(a, b)
+	})
+	return uris[:min(n, len(uris))]
+}
+
+// decay halves every count, forgetting URLs that drop to zero
+func (p *popularity) decay() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for uri, n := range p.counts {
+		if n /= 2; n == 0 {
+			delete(p.counts, uri)
+		} else {
+			p.counts[uri] = n
+		}
+	}
+}
+
+// takePending returns and resets the counts not yet shared
+func (p *popularity) takePending() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	pending := p.pending
+	p.pending = make(map[string]int)
+	return pending
+}
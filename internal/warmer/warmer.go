@@ -0,0 +1,431 @@
+package warmer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/metrics"
+	"github.com/singh-gur/api_cache/internal/quota"
+)
+
+// checkInterval is how often the warmer checks whether a run is due and
+// shares its request counts
+const checkInterval = time.Second
+
+// flushInterval is how often request counts are added to the shared store
+const flushInterval = time.Minute
+
+// ErrDisabled is returned when a run is requested while the warmer is disabled
+var ErrDisabled = errors.New("cache warmer disabled")
+
+// ErrRunning is returned when a run is requested while one is in progress
+var ErrRunning = errors.New("cache warming already running")
+
+// Result is the outcome of warming one request
+type Result string
+
+const (
+	// Warmed means the response was fetched from upstream and cached
+	Warmed Result = "warmed"
+	// Fresh means a fresh response was already cached
+	Fresh Result = "fresh"
+	// Skipped means nothing was cached, because another fetch of the same key
+	// was in flight or the response was not cacheable
+	Skipped Result = "skipped"
+)
+
+// Request is one GET request to warm
+type Request struct {
+	// URI is the path and query string
+	URI    string
+	Header http.Header
+}
+
+// FetchFunc warms one request through the proxy's cache path, fetching it
+// from upstream unless a fresh response is already cached
+type FetchFunc func(ctx context.Context, req Request, requestID string) (Result, error)
+
+// Store shares request counts across replicas and restarts, so a new replica
+// can warm what was popular before it started
+type Store interface {
+	AddRequestCounts(ctx context.Context, counts map[string]int) error
+	TopRequests(ctx context.Context, n int) ([]string, error)
+}
+
+// Status reports the warmer's most recent run
+type Status struct {
+	Enabled bool `json:"enabled"`
+	Running bool `json:"running"`
+	// Trigger is what started the run: startup, schedule, or admin
+	Trigger    string     `json:"trigger,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	NextRunAt  *time.Time `json:"next_run_at,omitempty"`
+	Total      int        `json:"total"`
+	Warmed     int        `json:"warmed"`
+	Fresh      int        `json:"fresh"`
+	Skipped    int        `json:"skipped"`
+	Failed     int        `json:"failed"`
+	// Aborted says why the run stopped before every request was issued
+	Aborted string `json:"aborted,omitempty"`
+}
+
+// Warmer fills the cache ahead of client requests on startup, on a schedule,
+// and on demand
+type Warmer struct {
+	config  *config.Holder
+	store   Store
+	fetch   FetchFunc
+	popular *popularity
+	now     func() time.Time
+	trigger chan string
+
+	mu      sync.Mutex
+	status  Status
+	started bool
+	lastRun time.Time
+}
+
+// New creates a warmer that warms requests with fetch. store shares request
+// counts across replicas and may be nil, in which case only this replica's
+// counts are used.
+func New(configs *config.Holder, store Store, fetch FetchFunc) *Warmer {
+	return &Warmer{
+		config:  configs,
+		store:   store,
+		fetch:   fetch,
+		popular: newPopularity(store != nil),
+		now:     time.Now,
+		trigger: make(chan string, 1),
+	}
+}
+
+// Record counts a client request toward the most requested URLs. Requests
+// carrying redacted query parameters, such as API keys, are not recorded.
+func (w *Warmer) Record(r *http.Request) {
+	cfg := w.config.Get()
+	if !cfg.Warmer.Enabled || cfg.Warmer.TopRequests == 0 {
+		return
+	}
+	if cfg.SanitizeQuery(r.URL.RawQuery) != r.URL.RawQuery {
+		return
+	}
+	w.popular.record(r.URL.RequestURI())
+}
+
+// Run starts warming runs when they are due until stop is closed, which also
+// cancels a run in progress
+func (w *Warmer) Run(stop <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	lastFlush := w.now()
+	w.startDue(ctx)
+	for {
+		select {
+		case <-stop:
+			return
+		case trigger := <-w.trigger:
+			w.start(ctx, trigger)
+		case <-ticker.C:
+			if w.now().Sub(lastFlush) >= flushInterval {
+				w.flush(ctx)
+				lastFlush = w.now()
+			}
+			w.startDue(ctx)
+		}
+	}
+}
+
+// Trigger requests a run now
+func (w *Warmer) Trigger() error {
+	if !w.config.Get().Warmer.Enabled {
+		return ErrDisabled
+	}
+
+	w.mu.Lock()
+	running := w.status.Running
+	w.mu.Unlock()
+	if running {
+		return ErrRunning
+	}
+
+	select {
+	case w.trigger <- "admin":
+	default:
+	}
+	return nil
+}
+
+// Status returns the progress of the current or last run
+func (w *Warmer) Status() Status {
+	cfg := w.config.Get().Warmer
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	status := w.status
+	status.Enabled = cfg.Enabled
+	if cfg.Enabled && cfg.Interval > 0 && !status.Running && !w.lastRun.IsZero() {
+		next := w.lastRun.Add(cfg.Interval)
+		status.NextRunAt = &next
+	}
+	return status
+}
+
+// startDue starts a run on the first check after the warmer is enabled and
+// then every interval after the last run finished
+func (w *Warmer) startDue(ctx context.Context) {
+	cfg := w.config.Get().Warmer
+
+	w.mu.Lock()
+	if !cfg.Enabled {
+		// Warm again as on startup if re-enabled
+		w.started = false
+		w.mu.Unlock()
+		return
+	}
+	trigger := ""
+	switch {
+	case !w.started:
+		trigger = "startup"
+	case cfg.Interval > 0 && !w.status.Running && w.now().Sub(w.lastRun) >= cfg.Interval:
+		trigger = "schedule"
+	}
+	w.mu.Unlock()
+
+	if trigger != "" {
+		w.start(ctx, trigger)
+	}
+}
+
+// start begins a run in the background unless one is in progress
+func (w *Warmer) start(ctx context.Context, trigger string) {
+	cfg := w.config.Get()
+	if !cfg.Warmer.Enabled {
+		return
+	}
+
+	w.mu.Lock()
+	if w.status.Running {
+		w.mu.Unlock()
+		return
+	}
+	startedAt := w.now()
+	w.started = true
+	w.status = Status{Running: true, Trigger: trigger, StartedAt: &startedAt}
+	w.mu.Unlock()
+
+	go w.run(ctx, cfg, trigger)
+}
+
+// run issues every warming request, spread across the configured number of
+// workers and paced to the configured rate. The run stops early if the
+// upstream quota is exhausted.
+func (w *Warmer) run(ctx context.Context, cfg *config.Config, trigger string) {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	requests := w.requests(runCtx, cfg)
+	w.mu.Lock()
+	w.status.Total = len(requests)
+	runID := fmt.Sprintf("warm-%d", w.status.StartedAt.Unix())
+	w.mu.Unlock()
+
+	logger.WithFields(map[string]interface{}{
+		"trigger":  trigger,
+		"requests": len(requests),
+	}).Info("Cache warming started")
+
+	var pace <-chan time.Time
+	if rps := cfg.Warmer.RequestsPerSecond; rps > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / rps))
+		defer ticker.Stop()
+		pace = ticker.C
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range cfg.Warmer.WorkerCount() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if runCtx.Err() != nil {
+					continue
+				}
+				w.warm(runCtx, cancel, cfg, requests[i], fmt.Sprintf("%s-%d", runID, i))
+			}
+		}()
+	}
+
+feed:
+	for i := range requests {
+		if pace != nil && i > 0 {
+			select {
+			case <-pace:
+			case <-runCtx.Done():
+				break feed
+			}
+		}
+		select {
+		case jobs <- i:
+		case <-runCtx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	finishedAt := w.now()
+	w.mu.Lock()
+	if ctx.Err() != nil && w.status.Aborted == "" {
+		w.status.Aborted = "shutting down"
+	}
+	w.status.Running = false
+	w.status.FinishedAt = &finishedAt
+	w.lastRun = finishedAt
+	status := w.status
+	w.mu.Unlock()
+
+	w.popular.decay()
+
+	fields := map[string]interface{}{
+		"trigger":  trigger,
+		"requests": status.Total,
+		"warmed":   status.Warmed,
+		"fresh":    status.Fresh,
+		"skipped":  status.Skipped,
+		"failed":   status.Failed,
+		"duration": finishedAt.Sub(*status.StartedAt).Milliseconds(),
+	}
+	if status.Aborted != "" {
+		fields["aborted"] = status.Aborted
+		logger.WithFields(fields).Warn("Cache warming stopped early")
+		return
+	}
+	logger.WithFields(fields).Info("Cache warming finished")
+}
+
+// warm issues one warming request and records its result
+func (w *Warmer) warm(ctx context.Context, abort context.CancelFunc, cfg *config.Config, req Request, requestID string) {
+	result, err := w.fetch(ctx, req, requestID)
+	if err != nil && ctx.Err() != nil {
+		// The run was stopped; this request was not really attempted
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err == nil {
+		switch result {
+		case Warmed:
+			w.status.Warmed++
+		case Fresh:
+			w.status.Fresh++
+		default:
+			w.status.Skipped++
+		}
+		metrics.RecordCacheWarm(string(result))
+		return
+	}
+
+	w.status.Failed++
+	metrics.RecordCacheWarm("failed")
+
+	path, query, _ := strings.Cut(req.URI, "?")
+	logger.WithFields(map[string]interface{}{
+		"request_id": requestID,
+		"path":       path,
+		"query":      cfg.SanitizeQuery(query),
+		"error":      err,
+	}).Warn("Cache warming request failed")
+
+	if errors.Is(err, quota.ErrExhausted) && w.status.Aborted == "" {
+		w.status.Aborted = "upstream quota exhausted"
+		abort()
+	}
+}
+
+// requests returns the configured requests followed by the most requested
+// URLs, without duplicates
+func (w *Warmer) requests(ctx context.Context, cfg *config.Config) []Request {
+	header := make(http.Header)
+	for name, value := range cfg.Warmer.Headers {
+		header.Set(name, value)
+	}
+
+	seen := make(map[string]bool)
+	var requests []Request
+	add := func(uri string, h http.Header) {
+		if seen[uri] {
+			return
+		}
+		seen[uri] = true
+		requests = append(requests, Request{URI: uri, Header: h})
+	}
+
+	for i := range cfg.Warmer.Requests {
+		configured := &cfg.Warmer.Requests[i]
+		uris, err := configured.Expand()
+		if err != nil {
+			// Rejected when the configuration was loaded
+			continue
+		}
+		h := header.Clone()
+		for name, value := range configured.Headers {
+			h.Set(name, value)
+		}
+		for _, uri := range uris {
+			add(uri, h)
+		}
+	}
+
+	if n := cfg.Warmer.TopRequests; n > 0 {
+		for _, uri := range w.topRequests(ctx, n) {
+			add(uri, header)
+		}
+	}
+	return requests
+}
+
+// topRequests returns the n most requested URLs, across replicas when the
+// shared store is available
+func (w *Warmer) topRequests(ctx context.Context, n int) []string {
+	if w.store != nil {
+		w.flush(ctx)
+		uris, err := w.store.TopRequests(ctx, n)
+		if err == nil {
+			return uris
+		}
+		logger.WithFields(map[string]interface{}{
+			"error": err,
+		}).Warn("Failed to read shared request counts, warming this replica's most requested URLs")
+	}
+	return w.popular.top(n)
+}
+
+// flush adds request counts recorded since the last flush to the shared store
+func (w *Warmer) flush(ctx context.Context) {
+	if w.store == nil {
+		return
+	}
+	counts := w.popular.takePending()
+	if err := w.store.AddRequestCounts(ctx, counts); err != nil {
+		logger.WithFields(map[string]interface{}{
+			"error":    err,
+			"requests": len(counts),
+		}).Warn("Failed to share request counts")
+	}
+}
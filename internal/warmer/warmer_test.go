@@ -0,0 +1,198 @@
+package warmer
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/singh-gur/api_cache/internal/config"
+	"github.com/singh-gur/api_cache/internal/logger"
+	"github.com/singh-gur/api_cache/internal/quota"
+)
+
+// recorder is a FetchFunc that records the requests it was given
+type recorder struct {
+	mu       sync.Mutex
+	uris     []string
+	headers  []string
+	result   func(uri string) (Result, error)
+	inFlight int
+	maxSeen  int
+}
+
+func (r *recorder) fetch(_ context.Context, req Request, _ string) (Result, error) {
+	r.mu.Lock()
+	r.uris = append(r.uris, req.URI)
+	r.headers = append(r.headers, req.Header.Get("Authorization"))
+	r.inFlight++
+	r.maxSeen = max(r.maxSeen, r.inFlight)
+	r.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	r.mu.Lock()
+	r.inFlight--
+	r.mu.Unlock()
+	if r.result != nil {
+		return r.result(req.URI)
+	}
+	return Warmed, nil
+}
+
+// initLogger initializes logging once, since finished runs may still be
+// logging when the next test starts
+var initLogger sync.Once
+
+// newTestWarmer returns a warmer without a shared store
+func newTestWarmer(t *testing.T, cfg config.WarmerConfig, rec *recorder) *Warmer {
+	t.Helper()
+	initLogger.Do(func() { logger.Init(config.LoggingConfig{Level: "error"}) })
+	cfg.Enabled = true
+	return New(config.NewHolder(&config.Config{Warmer: cfg}), nil, rec.fetch)
+}
+
+// runOnce starts a run and waits for it to finish
+func runOnce(t *testing.T, w *Warmer) Status {
+	t.Helper()
+	w.start(t.Context(), "startup")
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if status := w.Status(); !status.Running {
+			return status
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("warming run did not finish")
+	return Status{}
+}
+
+func TestRunWarmsConfiguredAndPopularRequests(t *testing.T) {
+	rec := &recorder{}
+	w := newTestWarmer(t, config.WarmerConfig{
+		Concurrency: 2,
+		TopRequests: 2,
+		Headers:     map[string]string{"Authorization": "Bearer shared"},
+		Requests: []config.WarmRequestConfig{
+			{Path: "/users/{id}", Params: map[string][]string{"id": {"1", "2"}}},
+			{Path: "/status", Headers: map[string]string{"Authorization": "Bearer status"}},
+		},
+	}, rec)
+
+	for _, uri := range []string{"/users/1", "/popular", "/popular", "/rare", "/other", "/other"} {
+		w.Record(httptest.NewRequest("GET", uri, nil))
+	}
+
+	status := runOnce(t, w)
+	if status.Total != 5 || status.Warmed != 5 || status.Failed != 0 {
+		t.Errorf("status = %+v, want 5 warmed", status)
+	}
+
+	// /users/1 is both configured and popular, so it is warmed once
+	got := slices.Clone(rec.uris)
+	sort.Strings(got)
+	want := []string{"/other", "/popular", "/status", "/users/1", "/users/2"}
+	if !slices.Equal(got, want) {
+		t.Errorf("warmed %v, want %v", got, want)
+	}
+	for i, uri := range rec.uris {
+		want := "Bearer shared"
+		if uri == "/status" {
+			want = "Bearer status"
+		}
+		if rec.headers[i] != want {
+			t.Errorf("%s sent Authorization %q, want %q", uri, rec.headers[i], want)
+		}
+	}
+	if rec.maxSeen > 2 {
+		t.Errorf("%d requests ran at once, want at most 2", rec.maxSeen)
+	}
+}
+
+func TestRunCountsResults(t *testing.T) {
+	rec := &recorder{result: func(uri string) (Result, error) {
+		switch uri {
+		case "/fresh":
+			return Fresh, nil
+		case "/skipped":
+			return Skipped, nil
+		case "/broken":
+			return "", errors.New("upstream returned garbage")
+		}
+		return Warmed, nil
+	}}
+	w := newTestWarmer(t, config.WarmerConfig{Requests: []config.WarmRequestConfig{
+		{Path: "/fresh"}, {Path: "/skipped"}, {Path: "/broken"}, {Path: "/new"},
+	}}, rec)
+
+	status := runOnce(t, w)
+	if status.Warmed != 1 || status.Fresh != 1 || status.Skipped != 1 || status.Failed != 1 || status.Aborted != "" {
+		t.Errorf("status = %+v, want one of each result", status)
+	}
+	if status.FinishedAt == nil || status.Trigger != "startup" {
+		t.Errorf("status = %+v, want a finished startup run", status)
+	}
+}
+
+func TestRunStopsWhenQuotaExhausted(t *testing.T) {
+	rec := &recorder{result: func(string) (Result, error) {
+		return "", &quota.ExhaustedError{Window: "day", RetryAfter: time.Hour}
+	}}
+	w := newTestWarmer(t, config.WarmerConfig{Concurrency: 1, Requests: []config.WarmRequestConfig{
+		{Path: "/items/{id}", Params: map[string][]string{"id": {"1", "2", "3", "4", "5", "6", "7", "8"}}},
+	}}, rec)
+
+	status := runOnce(t, w)
+	if status.Aborted == "" || status.Failed != 1 || len(rec.uris) != 1 {
+		t.Errorf("status = %+v after %d requests, want the run stopped after the first", status, len(rec.uris))
+	}
+}
+
+func TestTriggerRequiresEnabled(t *testing.T) {
+	w := New(config.NewHolder(&config.Config{}), nil, (&recorder{}).fetch)
+	if err := w.Trigger(); !errors.Is(err, ErrDisabled) {
+		t.Errorf("Trigger() error = %v, want ErrDisabled", err)
+	}
+}
+
+func TestRecordSkipsRedactedQueries(t *testing.T) {
+	cfg := &config.Config{
+		Warmer:  config.WarmerConfig{Enabled: true, TopRequests: 10},
+		Logging: config.LoggingConfig{RedactQueryParams: []string{"apikey"}},
+	}
+	w := New(config.NewHolder(cfg), nil, (&recorder{}).fetch)
+
+	w.Record(httptest.NewRequest("GET", "/quotes?symbol=AAPL&apikey=secret", nil))
+	w.Record(httptest.NewRequest("GET", "/quotes?symbol=AAPL", nil))
+
+	if got := w.popular.top(10); !slices.Equal(got, []string{"/quotes?symbol=AAPL"}) {
+		t.Errorf("recorded %v, want only the request without credentials", got)
+	}
+}
+
+func TestPopularityDecay(t *testing.T) {
+	p := newPopularity(true)
+	for range 4 {
+		p.record("/hot")
+	}
+	p.record("/cold")
+
+	if got := p.top(1); !slices.Equal(got, []string{"/hot"}) {
+		t.Errorf("top(1) = %v, want /hot", got)
+	}
+	if pending := p.takePending(); pending["/hot"] != 4 || pending["/cold"] != 1 {
+		t.Errorf("pending = %v, want every recorded request", pending)
+	}
+	if pending := p.takePending(); len(pending) != 0 {
+		t.Errorf("pending = %v after taking it, want empty", pending)
+	}
+
+	p.decay()
+	if got := p.top(10); !slices.Equal(got, []string{"/hot"}) {
+		t.Errorf("after decay top = %v, want /cold forgotten", got)
+	}
+}